@@ -0,0 +1,265 @@
+package EXIF
+
+import (
+	"bytes"
+	"encoding/binary"
+	"unicode/utf16"
+)
+
+/******************************************************************************
+*
+* Type:         AdobeSegment
+*
+* Description:  The decoded contents of an APP14 "Adobe" segment, written by
+*               Adobe applications. Its colour transform tells how the
+*               components of the image are encoded.
+*
+******************************************************************************/
+
+type AdobeSegment struct {
+	DCTEncodeVersion uint16
+	Flags0           uint16
+	Flags1           uint16
+	ColorTransform   byte // See AdobeTransformUnknown, AdobeTransformYCbCr and AdobeTransformYCCK
+}
+
+// Colour transforms of an APP14 Adobe segment
+const (
+	AdobeTransformUnknown = 0 // RGB for 3 components, CMYK for 4 components
+	AdobeTransformYCbCr   = 1
+	AdobeTransformYCCK    = 2
+)
+
+/******************************************************************************
+*
+* Type:         DuckySegment
+*
+* Description:  The decoded contents of an APP12 "Ducky" segment, written by
+*               Photoshop's "Save for Web"
+*
+******************************************************************************/
+
+type DuckySegment struct {
+	Quality   uint32 // The quality setting, 0 to 100
+	Comment   string
+	Copyright string
+}
+
+// Block tags of an APP12 Ducky segment
+const (
+	duckyTagEnd       = 0
+	duckyTagQuality   = 1
+	duckyTagComment   = 2
+	duckyTagCopyright = 3
+)
+
+var adobeIdent = []byte("Adobe")
+var duckyIdent = []byte("Ducky")
+
+/******************************************************************************
+*
+* Function:     getAdobeSegment
+*
+* Description:  Finds the APP14 Adobe segment in the JPEG header data and
+*               decodes it
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*
+* Returns:      adobe - the decoded Adobe segment
+*               error - if no Adobe segment was found or it could not be decoded
+*
+******************************************************************************/
+
+func getAdobeSegment(jpegHeader []segment) (*AdobeSegment, error) {
+	for _, seg := range jpegHeader {
+		if seg.segType == 0xEE && bytes.HasPrefix(seg.segData, adobeIdent) {
+			return decodeAdobeSegment(seg.segData)
+		}
+	}
+	return nil, &jpegError{"Couldn't find Adobe APP14 segment"}
+}
+
+/******************************************************************************
+*
+* Function:     decodeAdobeSegment
+*
+* Description:  Decodes the data of an APP14 Adobe segment
+*
+* Parameters:   data - the data of the APP14 segment, starting with "Adobe"
+*
+* Returns:      adobe - the decoded Adobe segment
+*               error - if the data is not a valid Adobe segment
+*
+******************************************************************************/
+
+func decodeAdobeSegment(data []byte) (*AdobeSegment, error) {
+	// Layout after the identifier:
+	// DCTEncodeVersion (2), APP14Flags0 (2), APP14Flags1 (2), ColorTransform (1)
+	if !bytes.HasPrefix(data, adobeIdent) {
+		return nil, &jpegError{"Segment is not an Adobe segment"}
+	}
+	data = data[len(adobeIdent):]
+	if len(data) < 7 {
+		return nil, &jpegError{"Adobe segment is too short"}
+	}
+
+	return &AdobeSegment{
+		DCTEncodeVersion: binary.BigEndian.Uint16(data[0:2]),
+		Flags0:           binary.BigEndian.Uint16(data[2:4]),
+		Flags1:           binary.BigEndian.Uint16(data[4:6]),
+		ColorTransform:   data[6],
+	}, nil
+}
+
+/******************************************************************************
+*
+* Function:     getDuckySegment
+*
+* Description:  Finds the APP12 Ducky segment in the JPEG header data and
+*               decodes it
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*
+* Returns:      ducky - the decoded Ducky segment
+*               error - if no Ducky segment was found or it could not be decoded
+*
+******************************************************************************/
+
+func getDuckySegment(jpegHeader []segment) (*DuckySegment, error) {
+	for _, seg := range jpegHeader {
+		if seg.segType == 0xEC && bytes.HasPrefix(seg.segData, duckyIdent) {
+			return decodeDuckySegment(seg.segData)
+		}
+	}
+	return nil, &jpegError{"Couldn't find Ducky APP12 segment"}
+}
+
+/******************************************************************************
+*
+* Function:     decodeDuckySegment
+*
+* Description:  Decodes the data of an APP12 Ducky segment
+*
+* Parameters:   data - the data of the APP12 segment, starting with "Ducky"
+*
+* Returns:      ducky - the decoded Ducky segment
+*               error - if the data is not a valid Ducky segment
+*
+******************************************************************************/
+
+func decodeDuckySegment(data []byte) (*DuckySegment, error) {
+	if !bytes.HasPrefix(data, duckyIdent) {
+		return nil, &jpegError{"Segment is not a Ducky segment"}
+	}
+	data = data[len(duckyIdent):]
+
+	ducky := &DuckySegment{}
+
+	// The identifier is followed by blocks of tag (2), length (2) and data,
+	// until a zero tag
+	for len(data) >= 2 {
+		tag := binary.BigEndian.Uint16(data[0:2])
+		if tag == duckyTagEnd {
+			break
+		}
+		if len(data) < 4 {
+			return nil, &jpegError{"Ducky segment is truncated"}
+		}
+		length := int(binary.BigEndian.Uint16(data[2:4]))
+		if len(data) < 4+length {
+			return nil, &jpegError{"Ducky segment is truncated"}
+		}
+		value := data[4 : 4+length]
+		data = data[4+length:]
+
+		switch tag {
+		case duckyTagQuality:
+			if len(value) >= 4 {
+				ducky.Quality = binary.BigEndian.Uint32(value)
+			}
+		case duckyTagComment:
+			ducky.Comment = duckyText(value)
+		case duckyTagCopyright:
+			ducky.Copyright = duckyText(value)
+		}
+	}
+
+	return ducky, nil
+}
+
+// duckyText decodes the text of a Ducky block: a 4 byte character count
+// followed by UCS-2 big endian characters
+func duckyText(value []byte) string {
+	if len(value) < 4 {
+		return ""
+	}
+	count := int(binary.BigEndian.Uint32(value[0:4]))
+	value = value[4:]
+	if count > len(value)/2 {
+		count = len(value) / 2
+	}
+
+	units := make([]uint16, count)
+	for i := range units {
+		units[i] = binary.BigEndian.Uint16(value[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+/******************************************************************************
+*
+* Function:     getJPEGColorSpace
+*
+* Description:  Determines the colour space of the encoded image from the
+*               number of components in the frame header, the colour
+*               transform of an APP14 Adobe segment and a JFIF segment. This
+*               tells a 4 component CMYK image apart from a YCCK one.
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*
+* Returns:      colorSpace - "Gray", "RGB", "YCbCr", "CMYK" or "YCCK"
+*               error - if there is no frame header or the number of
+*                       components is not supported
+*
+******************************************************************************/
+
+func getJPEGColorSpace(jpegHeader []segment) (string, error) {
+	frame, err := getJPEGFrameHeader(jpegHeader)
+	if err != nil {
+		return "", err
+	}
+	adobe, _ := getAdobeSegment(jpegHeader)
+
+	switch len(frame.Components) {
+	case 1:
+		return "Gray", nil
+
+	case 3:
+		if adobe != nil {
+			if adobe.ColorTransform == AdobeTransformUnknown {
+				return "RGB", nil
+			}
+			return "YCbCr", nil
+		}
+		if _, err := getJFIFHeader(jpegHeader); err == nil {
+			return "YCbCr", nil
+		}
+		// Without Adobe or JFIF segment, component IDs of 'R', 'G' and 'B' mark an RGB image
+		c := frame.Components
+		if c[0].ID == 'R' && c[1].ID == 'G' && c[2].ID == 'B' {
+			return "RGB", nil
+		}
+		return "YCbCr", nil
+
+	case 4:
+		if adobe != nil && adobe.ColorTransform == AdobeTransformYCCK {
+			return "YCCK", nil
+		}
+		return "CMYK", nil
+	}
+
+	return "", &jpegError{"Unsupported number of components"}
+}
@@ -0,0 +1,106 @@
+package EXIF
+
+import (
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+)
+
+// newTestAdobeSegment returns an APP14 Adobe segment with a colour transform
+func newTestAdobeSegment(colorTransform byte) segment {
+	return newTestSegment(0xEE, append([]byte("Adobe\x00\x64\x80\x00\x00\x00"), colorTransform))
+}
+
+// newTestDuckyText encodes the value of a Ducky text block
+func newTestDuckyText(text string) []byte {
+	units := utf16.Encode([]rune(text))
+	value := binary.BigEndian.AppendUint32(nil, uint32(len(units)))
+	for _, unit := range units {
+		value = binary.BigEndian.AppendUint16(value, unit)
+	}
+	return value
+}
+
+func TestDecodeAdobeSegment(t *testing.T) {
+	adobe, err := getAdobeSegment([]segment{newTestSegment(0xE0, []byte("JFIF\x00")), newTestAdobeSegment(AdobeTransformYCCK)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adobe.DCTEncodeVersion != 100 || adobe.Flags0 != 0x8000 || adobe.Flags1 != 0 || adobe.ColorTransform != AdobeTransformYCCK {
+		t.Errorf("Adobe segment = %+v", adobe)
+	}
+
+	for _, data := range [][]byte{[]byte("Adobe\x00\x64\x80\x00\x00"), []byte("Ducky")} {
+		if _, err := decodeAdobeSegment(data); err == nil {
+			t.Errorf("no error for %q", data)
+		}
+	}
+	if _, err := getAdobeSegment(nil); err == nil {
+		t.Error("no error without an Adobe segment")
+	}
+}
+
+func TestDecodeDuckySegment(t *testing.T) {
+	block := func(tag uint16, value []byte) []byte {
+		data := binary.BigEndian.AppendUint16(nil, tag)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(value)))
+		return append(data, value...)
+	}
+	data := []byte("Ducky")
+	data = append(data, block(duckyTagQuality, []byte{0, 0, 0, 80})...)
+	data = append(data, block(duckyTagComment, newTestDuckyText("Grüße"))...)
+	data = append(data, block(duckyTagCopyright, newTestDuckyText("© 2024"))...)
+	data = append(data, 0, 0)
+
+	ducky, err := getDuckySegment([]segment{newTestSegment(0xEC, data)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ducky.Quality != 80 || ducky.Comment != "Grüße" || ducky.Copyright != "© 2024" {
+		t.Errorf("Ducky segment = %+v", ducky)
+	}
+
+	// Truncated inside a block header and inside a block value
+	for _, size := range []int{8, 12} {
+		if _, err := decodeDuckySegment(data[:size]); err == nil {
+			t.Errorf("no error for Ducky data truncated to %d bytes", size)
+		}
+	}
+	// A character count beyond the value is limited to the value
+	if got := duckyText([]byte{0, 0, 0, 9, 0, 'a'}); got != "a" {
+		t.Errorf("duckyText = %q, want a", got)
+	}
+}
+
+func TestGetJPEGColorSpace(t *testing.T) {
+	ycc := newTestSegment(0xC0, newTestSOF(8, 8, 0x11, 0x11))
+	rgb := newTestSegment(0xC0, []byte{8, 0, 8, 0, 8, 3, 'R', 0x11, 0, 'G', 0x11, 0, 'B', 0x11, 0})
+	gray := newTestSegment(0xC0, []byte{8, 0, 8, 0, 8, 1, 1, 0x11, 0})
+	cmyk := newTestSegment(0xC0, []byte{8, 0, 8, 0, 8, 4, 1, 0x11, 0, 2, 0x11, 0, 3, 0x11, 0, 4, 0x11, 0})
+	two := newTestSegment(0xC0, []byte{8, 0, 8, 0, 8, 2, 1, 0x11, 0, 2, 0x11, 0})
+	jfif := newTestSegment(0xE0, []byte("JFIF\x00"))
+
+	tests := []struct {
+		name       string
+		jpegHeader []segment
+		want       string
+		wantErr    bool
+	}{
+		{"grey", []segment{gray}, "Gray", false},
+		{"JFIF", []segment{jfif, ycc}, "YCbCr", false},
+		{"Adobe RGB", []segment{newTestAdobeSegment(AdobeTransformUnknown), ycc}, "RGB", false},
+		{"Adobe YCbCr", []segment{newTestAdobeSegment(AdobeTransformYCbCr), ycc}, "YCbCr", false},
+		{"RGB component IDs", []segment{rgb}, "RGB", false},
+		{"no markers", []segment{ycc}, "YCbCr", false},
+		{"CMYK", []segment{newTestAdobeSegment(AdobeTransformUnknown), cmyk}, "CMYK", false},
+		{"YCCK", []segment{newTestAdobeSegment(AdobeTransformYCCK), cmyk}, "YCCK", false},
+		{"two components", []segment{two}, "", true},
+		{"no frame", []segment{jfif}, "", true},
+	}
+	for _, test := range tests {
+		got, err := getJPEGColorSpace(test.jpegHeader)
+		if got != test.want || (err != nil) != test.wantErr {
+			t.Errorf("%s: colour space %q, error %v", test.name, got, err)
+		}
+	}
+}
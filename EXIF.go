@@ -1,3 +1,8 @@
+//go:build ignore
+
+// EXIF.go is still being ported from the PHP JPEG Metadata Toolkit and does
+// not compile yet, so it is left out of the build until it is finished.
+
 package EXIF
 
 
@@ -0,0 +1,398 @@
+package EXIF
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+/******************************************************************************
+*
+* Type:         ICCProfile
+*
+* Description:  An ICC colour profile, with the fields of its header and the
+*               description and copyright tags decoded
+*
+******************************************************************************/
+
+type ICCProfile struct {
+	Data        []byte // The complete profile
+	Header      ICCHeader
+	Description string // Contents of the 'desc' tag
+	Copyright   string // Contents of the 'cprt' tag
+}
+
+// ICCHeader holds the fields of the 128 byte header of an ICC profile
+type ICCHeader struct {
+	Size            uint32
+	PreferredCMM    string
+	MajorVersion    byte
+	MinorVersion    byte
+	BugFixVersion   byte
+	DeviceClass     string // e.g. "mntr" (display), "prtr" (output), "scnr" (input)
+	ColorSpace      string // Data colour space, e.g. "RGB ", "CMYK", "GRAY"
+	PCS             string // Profile connection space, "XYZ " or "Lab "
+	Created         time.Time
+	Platform        string
+	Manufacturer    string
+	Model           string
+	RenderingIntent uint32
+	Creator         string
+}
+
+var iccIdent = []byte("ICC_PROFILE\x00")
+
+// iccChunkMaxSize is the largest part of a profile that fits in one APP2
+// segment, after the identifier, sequence number and count
+const iccChunkMaxSize = jpegSegmentMaxSize - 14
+
+/******************************************************************************
+*
+* Function:     getICCProfileData
+*
+* Description:  Reassembles an ICC profile from the APP2 ICC_PROFILE segments
+*               in the JPEG header data. The segments are put in order of
+*               their sequence numbers, which need not be the order in
+*               which they appear in the file.
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*
+* Returns:      data - the complete ICC profile
+*               error - if there is no profile, or some chunks are missing
+*
+******************************************************************************/
+
+func getICCProfileData(jpegHeader []segment) ([]byte, error) {
+	type iccChunk struct {
+		seqNo byte
+		data  []byte
+	}
+
+	chunks := []iccChunk{}
+	count := -1
+	for _, seg := range jpegHeader {
+		if seg.segType != 0xE2 || !bytes.HasPrefix(seg.segData, iccIdent) {
+			continue
+		}
+		if len(seg.segData) < len(iccIdent)+2 {
+			return nil, &jpegError{"ICC_PROFILE segment is too short"}
+		}
+
+		// Sequence number (1 based) and total number of chunks
+		seqNo := seg.segData[len(iccIdent)]
+		numChunks := int(seg.segData[len(iccIdent)+1])
+		if count >= 0 && numChunks != count {
+			return nil, &jpegError{"ICC_PROFILE segments disagree on the number of chunks"}
+		}
+		count = numChunks
+		chunks = append(chunks, iccChunk{seqNo: seqNo, data: seg.segData[len(iccIdent)+2:]})
+	}
+
+	if len(chunks) == 0 {
+		return nil, &jpegError{"Couldn't find ICC_PROFILE segment"}
+	}
+	if len(chunks) != count {
+		return nil, &jpegError{"ICC_PROFILE segments are missing"}
+	}
+
+	sort.SliceStable(chunks, func(i, j int) bool { return chunks[i].seqNo < chunks[j].seqNo })
+
+	var data bytes.Buffer
+	for i, chunk := range chunks {
+		if int(chunk.seqNo) != i+1 {
+			return nil, &jpegError{"ICC_PROFILE segments have invalid sequence numbers"}
+		}
+		data.Write(chunk.data)
+	}
+	return data.Bytes(), nil
+}
+
+/******************************************************************************
+*
+* Function:     getICCProfile
+*
+* Description:  Reassembles and decodes the ICC profile in the JPEG header data
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*
+* Returns:      profile - the decoded ICC profile
+*               error - if there is no profile or it could not be decoded
+*
+******************************************************************************/
+
+func getICCProfile(jpegHeader []segment) (*ICCProfile, error) {
+	data, err := getICCProfileData(jpegHeader)
+	if err != nil {
+		return nil, err
+	}
+	return decodeICCProfile(data)
+}
+
+/******************************************************************************
+*
+* Function:     decodeICCProfile
+*
+* Description:  Decodes the header and the 'desc' and 'cprt' tags of an ICC
+*               profile. Both the version 2 ('desc', 'text') and version 4
+*               ('mluc') tag types are supported.
+*
+* Parameters:   data - the complete ICC profile
+*
+* Returns:      profile - the decoded ICC profile
+*               error - if the profile header or tag table is invalid
+*
+******************************************************************************/
+
+func decodeICCProfile(data []byte) (*ICCProfile, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, &jpegError{"Data is not an ICC profile"}
+	}
+
+	profile := &ICCProfile{
+		Data: data,
+		Header: ICCHeader{
+			Size:            binary.BigEndian.Uint32(data[0:4]),
+			PreferredCMM:    iccSignature(data[4:8]),
+			MajorVersion:    data[8],
+			MinorVersion:    data[9] >> 4,
+			BugFixVersion:   data[9] & 0x0F,
+			DeviceClass:     iccSignature(data[12:16]),
+			ColorSpace:      iccSignature(data[16:20]),
+			PCS:             iccSignature(data[20:24]),
+			Created:         iccDateTime(data[24:36]),
+			Platform:        iccSignature(data[40:44]),
+			Manufacturer:    iccSignature(data[48:52]),
+			Model:           iccSignature(data[52:56]),
+			RenderingIntent: binary.BigEndian.Uint32(data[64:68]),
+			Creator:         iccSignature(data[80:84]),
+		},
+	}
+
+	// Tag table: count (4), followed by count times signature (4), offset (4), size (4)
+	numTags := int(binary.BigEndian.Uint32(data[128:132]))
+	if len(data) < 132+12*numTags {
+		return nil, &jpegError{"ICC profile tag table is truncated"}
+	}
+	for i := 0; i < numTags; i++ {
+		entry := data[132+12*i:]
+		offset := uint64(binary.BigEndian.Uint32(entry[4:8]))
+		size := uint64(binary.BigEndian.Uint32(entry[8:12]))
+		if offset+size > uint64(len(data)) {
+			continue
+		}
+		tagData := data[offset : offset+size]
+
+		switch string(entry[0:4]) {
+		case "desc":
+			profile.Description = iccText(tagData)
+		case "cprt":
+			profile.Copyright = iccText(tagData)
+		}
+	}
+
+	return profile, nil
+}
+
+/******************************************************************************
+*
+* Function:     putICCProfile
+*
+* Description:  Embeds an ICC profile in the JPEG header data, replacing any
+*               existing profile. The profile is split into as many APP2
+*               segments as needed, numbered in order.
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*               data - the complete ICC profile
+*
+* Returns:      jpegHeader - the JPEG header data with the profile embedded
+*               error - if the profile is too large for 255 segments
+*
+******************************************************************************/
+
+func putICCProfile(jpegHeader []segment, data []byte) ([]segment, error) {
+	numChunks := (len(data) + iccChunkMaxSize - 1) / iccChunkMaxSize
+	if numChunks == 0 || numChunks > 255 {
+		return jpegHeader, &jpegError{"ICC profile is empty or too large to embed"}
+	}
+
+	segments := make([]segment, numChunks)
+	for i := range segments {
+		chunk := data[i*iccChunkMaxSize:]
+		if len(chunk) > iccChunkMaxSize {
+			chunk = chunk[:iccChunkMaxSize]
+		}
+
+		segData := make([]byte, 0, len(iccIdent)+2+len(chunk))
+		segData = append(segData, iccIdent...)
+		segData = append(segData, byte(i+1), byte(numChunks))
+		segData = append(segData, chunk...)
+
+		segments[i] = segment{
+			segType: 0xE2,
+			segName: aJPEGSegmentNames[0xE2],
+			segDesc: aJPEGSegmentDescriptions[0xE2],
+			segData: segData,
+		}
+	}
+
+	// Put the profile where the old one was, or else after the APP0 and APP1 segments
+	insertAt := -1
+	newHeader := []segment{}
+	for _, seg := range jpegHeader {
+		if seg.segType == 0xE2 && bytes.HasPrefix(seg.segData, iccIdent) {
+			if insertAt < 0 {
+				insertAt = len(newHeader)
+			}
+			continue
+		}
+		newHeader = append(newHeader, seg)
+	}
+	if insertAt < 0 {
+		insertAt = len(newHeader)
+		for i, seg := range newHeader {
+			if seg.segType != 0xE0 && seg.segType != 0xE1 {
+				insertAt = i
+				break
+			}
+		}
+	}
+
+	return spliceJPEGSegments(newHeader, insertAt, 0, segments), nil
+}
+
+/******************************************************************************
+*
+* Function:     deleteICCProfile
+*
+* Description:  Removes the ICC profile from the JPEG header data
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*
+* Returns:      jpegHeader - the JPEG header data without ICC_PROFILE segments
+*
+******************************************************************************/
+
+func deleteICCProfile(jpegHeader []segment) []segment {
+	newHeader := []segment{}
+	for _, seg := range jpegHeader {
+		if seg.segType != 0xE2 || !bytes.HasPrefix(seg.segData, iccIdent) {
+			newHeader = append(newHeader, seg)
+		}
+	}
+	return newHeader
+}
+
+/******************************************************************************
+*
+* Function:     RenderingIntentName
+*
+* Description:  Gets the name of the rendering intent in the profile header
+*
+* Returns:      name - the name of the rendering intent
+*
+******************************************************************************/
+
+func (h *ICCHeader) RenderingIntentName() string {
+	switch h.RenderingIntent {
+	case 0:
+		return "Perceptual"
+	case 1:
+		return "Media-Relative Colorimetric"
+	case 2:
+		return "Saturation"
+	case 3:
+		return "ICC-Absolute Colorimetric"
+	}
+	return "Unknown"
+}
+
+// iccSignature converts a four character signature to a string, zero
+// signatures become an empty string
+func iccSignature(data []byte) string {
+	return strings.TrimRight(string(data[:4]), "\x00")
+}
+
+// iccDateTime decodes a dateTimeNumber: year, month, day, hours, minutes and
+// seconds as six 16 bit numbers
+func iccDateTime(data []byte) time.Time {
+	var v [6]int
+	for i := range v {
+		v[i] = int(binary.BigEndian.Uint16(data[2*i:]))
+	}
+	if v[0] == 0 {
+		return time.Time{}
+	}
+	return time.Date(v[0], time.Month(v[1]), v[2], v[3], v[4], v[5], 0, time.UTC)
+}
+
+// iccText decodes the text of a 'desc' (textDescriptionType), 'text'
+// (textType) or 'mluc' (multiLocalizedUnicodeType) tag. For a 'mluc' tag
+// the English record is used when there is one, otherwise the first.
+func iccText(data []byte) string {
+	if len(data) < 8 {
+		return ""
+	}
+
+	switch string(data[0:4]) {
+	case "desc":
+		// ASCII count (4) followed by the ASCII description
+		if len(data) < 12 {
+			return ""
+		}
+		count := uint64(binary.BigEndian.Uint32(data[8:12]))
+		if 12+count > uint64(len(data)) {
+			return ""
+		}
+		return strings.TrimRight(string(data[12:12+count]), "\x00")
+
+	case "text":
+		return strings.TrimRight(string(data[8:]), "\x00")
+
+	case "mluc":
+		// Record count (4), record size (4), followed by records of
+		// language (2), country (2), length (4), offset (4)
+		if len(data) < 16 {
+			return ""
+		}
+		numRecords := int(binary.BigEndian.Uint32(data[8:12]))
+		recordSize := int(binary.BigEndian.Uint32(data[12:16]))
+		if recordSize < 12 {
+			return ""
+		}
+
+		text := ""
+		for i := 0; i < numRecords; i++ {
+			record := 16 + i*recordSize
+			if record+12 > len(data) {
+				break
+			}
+			length := uint64(binary.BigEndian.Uint32(data[record+4:]))
+			offset := uint64(binary.BigEndian.Uint32(data[record+8:]))
+			if offset+length > uint64(len(data)) {
+				continue
+			}
+
+			units := make([]uint16, length/2)
+			for j := range units {
+				units[j] = binary.BigEndian.Uint16(data[offset+uint64(2*j):])
+			}
+			recordText := string(utf16.Decode(units))
+
+			if i == 0 || string(data[record:record+2]) == "en" {
+				text = recordText
+			}
+			if string(data[record:record+2]) == "en" {
+				break
+			}
+		}
+		return text
+	}
+	return ""
+}
@@ -0,0 +1,171 @@
+package EXIF
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// newTestICCProfile builds an sRGB display profile with a 'desc' tag, a
+// 'cprt' tag and zero bytes making it size bytes long
+func newTestICCProfile(size int, desc []byte, cprt []byte) []byte {
+	data := make([]byte, 132+24)
+	copy(data[4:], "lcms")
+	data[8], data[9] = 4, 0x30
+	copy(data[12:], "mntrRGB XYZ ")
+	for i, v := range []uint16{2021, 6, 15, 12, 30, 45} {
+		binary.BigEndian.PutUint16(data[24+2*i:], v)
+	}
+	copy(data[36:], "acspAPPL")
+	binary.BigEndian.PutUint32(data[64:], 1)
+	binary.BigEndian.PutUint32(data[128:], 2)
+
+	for i, tag := range []struct {
+		signature string
+		data      []byte
+	}{{"desc", desc}, {"cprt", cprt}} {
+		entry := data[132+12*i:]
+		copy(entry, tag.signature)
+		binary.BigEndian.PutUint32(entry[4:], uint32(len(data)))
+		binary.BigEndian.PutUint32(entry[8:], uint32(len(tag.data)))
+		data = append(data, tag.data...)
+	}
+
+	if len(data) < size {
+		data = append(data, make([]byte, size-len(data))...)
+	}
+	binary.BigEndian.PutUint32(data[0:], uint32(len(data)))
+	return data
+}
+
+// newTestICCDesc returns a textDescriptionType tag
+func newTestICCDesc(text string) []byte {
+	data := append([]byte("desc\x00\x00\x00\x00"), 0, 0, 0, byte(len(text)+1))
+	return append(append(data, text...), 0)
+}
+
+// newTestICCMluc returns a multiLocalizedUnicodeType tag with a German and an English record
+func newTestICCMluc(german string, english string) []byte {
+	data := []byte("mluc\x00\x00\x00\x00\x00\x00\x00\x02\x00\x00\x00\x0C")
+	texts := []byte{}
+	for _, record := range []struct{ language, text string }{{"deDE", german}, {"enUS", english}} {
+		units := utf16.Encode([]rune(record.text))
+		entry := make([]byte, 12)
+		copy(entry, record.language)
+		binary.BigEndian.PutUint32(entry[4:], uint32(2*len(units)))
+		binary.BigEndian.PutUint32(entry[8:], uint32(16+24+len(texts)))
+		data = append(data, entry...)
+		for _, unit := range units {
+			texts = binary.BigEndian.AppendUint16(texts, unit)
+		}
+	}
+	return append(data, texts...)
+}
+
+func TestICCProfileRoundTrip(t *testing.T) {
+	tests := []struct {
+		size         int
+		wantSegments int
+	}{
+		{0, 1},
+		{iccChunkMaxSize, 1},
+		{iccChunkMaxSize + 1, 2},
+		{3 * iccChunkMaxSize, 3},
+		{255 * iccChunkMaxSize, 255},
+	}
+
+	for _, test := range tests {
+		profile := newTestICCProfile(test.size, newTestICCDesc("sRGB IEC61966-2.1"), []byte("text\x00\x00\x00\x00Public Domain\x00"))
+		jpegHeader := []segment{
+			newTestSegment(0xE0, []byte("JFIF\x00")),
+			newTestSegment(0xE1, []byte("Exif\x00\x00")),
+			newTestSegment(0xDB, make([]byte, 65)),
+			newTestSegment(0xDA, testSOS),
+		}
+		jpegHeader, err := putICCProfile(jpegHeader, profile)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", len(profile), err)
+		}
+		if got := bytes.Count(segmentTypes(jpegHeader), []byte{0xE2}); got != test.wantSegments || jpegHeader[2].segType != 0xE2 {
+			t.Errorf("%d bytes: %d APP2 segments after the APP0 and APP1 segments, want %d", len(profile), got, test.wantSegments)
+		}
+		if _, err := encodeJPEGSegments(jpegHeader); err != nil {
+			t.Errorf("%d bytes: %v", len(profile), err)
+		}
+
+		decoded, err := getICCProfile(jpegHeader)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", len(profile), err)
+		}
+		if !bytes.Equal(decoded.Data, profile) {
+			t.Errorf("%d bytes: the profile data changed", len(profile))
+		}
+		if decoded.Description != "sRGB IEC61966-2.1" || decoded.Copyright != "Public Domain" {
+			t.Errorf("%d bytes: description %q, copyright %q", len(profile), decoded.Description, decoded.Copyright)
+		}
+
+		if got := deleteICCProfile(jpegHeader); bytes.Contains(segmentTypes(got), []byte{0xE2}) {
+			t.Errorf("%d bytes: APP2 segments left after deleting", len(profile))
+		}
+	}
+
+	if _, err := putICCProfile(nil, make([]byte, 255*iccChunkMaxSize+1)); err == nil {
+		t.Error("no error for a profile that needs 256 segments")
+	}
+}
+
+func TestICCHeader(t *testing.T) {
+	profile, err := decodeICCProfile(newTestICCProfile(0, newTestICCMluc("Farbe", "Colour"), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := profile.Header
+	if header.PreferredCMM != "lcms" || header.MajorVersion != 4 || header.MinorVersion != 3 || header.DeviceClass != "mntr" ||
+		header.ColorSpace != "RGB " || header.PCS != "XYZ " || header.Platform != "APPL" {
+		t.Errorf("header = %+v", header)
+	}
+	if !header.Created.Equal(time.Date(2021, 6, 15, 12, 30, 45, 0, time.UTC)) {
+		t.Errorf("created = %v", header.Created)
+	}
+	if header.RenderingIntentName() != "Media-Relative Colorimetric" {
+		t.Errorf("rendering intent = %s", header.RenderingIntentName())
+	}
+	if profile.Description != "Colour" {
+		t.Errorf("description = %q, want the English record", profile.Description)
+	}
+}
+
+func TestGetICCProfileDataCorrupt(t *testing.T) {
+	chunk := func(seqNo byte, count byte) segment {
+		return newTestSegment(0xE2, append(append([]byte{}, iccIdent...), seqNo, count, 'x'))
+	}
+
+	tests := []struct {
+		name       string
+		jpegHeader []segment
+	}{
+		{"no profile", []segment{newTestSegment(0xDA, testSOS)}},
+		{"too short", []segment{newTestSegment(0xE2, iccIdent)}},
+		{"missing chunk", []segment{chunk(1, 2)}},
+		{"counts disagree", []segment{chunk(1, 2), chunk(2, 3)}},
+		{"duplicate sequence number", []segment{chunk(1, 2), chunk(1, 2)}},
+		{"sequence number zero", []segment{chunk(0, 1)}},
+	}
+	for _, test := range tests {
+		if _, err := getICCProfileData(test.jpegHeader); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+
+	// Out of order chunks are put back in order
+	data, err := getICCProfileData([]segment{chunk(2, 2), chunk(1, 2)})
+	if err != nil || string(data) != "xx" {
+		t.Errorf("out of order chunks: %q, %v", data, err)
+	}
+
+	if _, err := decodeICCProfile(make([]byte, 200)); err == nil {
+		t.Error("no error for data without the acsp signature")
+	}
+}
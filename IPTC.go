@@ -2,6 +2,7 @@ package EXIF
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
 )
@@ -11,7 +12,9 @@ import (
 * Function:     get_IPTC
 *
 * Description:  Extracts IPTC-NAA IIM data from the string provided, and returns
-*               the information as an array
+*               the information as an array. Text datasets are decoded into
+*               recText using the Coded Character Set (1:90), or guessed
+*               when there is none.
 *
 * Parameters:   Data_Str - the string containing the IPTC-NAA IIM records. Must
 *                          be exact length of the IPTC-NAA IIM data.
@@ -25,22 +28,33 @@ type iptcRecord struct {
 	recRecordNumber  byte
 	recDataSetNumber byte
 	recData          []byte
+	recText          string // recData decoded as text, for text datasets only
 }
 
 func getIPTC(reader io.Reader) []iptcRecord {
+	return getIPTCWithFallback(reader, charsetAuto)
+}
+
+// getIPTCWithFallback is getIPTC with the character set to assume for text
+// when there is no Coded Character Set (1:90) dataset
+func getIPTCWithFallback(reader io.Reader, fallback jpegCharset) []iptcRecord {
+	records := readIPTCRecords(reader)
+	decodeIPTCTexts(records, fallback)
+	return records
+}
+
+// readIPTCRecords reads the raw records of IPTC-NAA IIM data
+func readIPTCRecords(reader io.Reader) []iptcRecord {
 
 	// Create the array to receive the data
-	outputArray := make([]iptcRecord)
+	outputArray := []iptcRecord{}
 
 	header := make([]byte, 5)
 
 	// Cycle through the IPTC records, decoding and storing them
-	for true { // pos < reader.
+	for {
 
-		// @TODO - Extended Dataset record not supported
-
-		n, err := reader.Read(header)
-		if err != nill {
+		if _, err := io.ReadFull(reader, header); err != nil {
 			// Not enough data left for a record - Probably corrupt data - ERROR
 			// Change: changed to return partial data as of revision 1.01
 			return outputArray
@@ -51,33 +65,51 @@ func getIPTC(reader io.Reader) []iptcRecord {
 		// Second byte - IPTC Record Number
 		// Third byte - IPTC Dataset Number
 		// Fourth and fifth bytes - two byte size value
-		// $iptc_raw = unpack( "CIPTC_Tag_Marker/CIPTC_Record_No/CIPTC_Dataset_No/nIPTC_Size", substr($Data_Str,$pos) );
 		iptcTagMarker := header[0]
 		iptcRecordNumber := header[1]
 		iptcDataSetNumber := header[2]
-		iptcSize := uint16(header[3]) | (uint16(header[4]) << 8)
+		iptcSize := binary.BigEndian.Uint16(header[3:5])
+
+		// Check that the record starts with the tag marker - otherwise this isn't IPTC data
+		if iptcTagMarker != 0x1C {
+			return outputArray
+		}
 
 		// Construct the IPTC type string eg 2:105
 		iptcType := fmt.Sprintf("%01d:%02d", iptcRecordNumber, iptcDataSetNumber)
 
-		// Check if there is sufficient data for reading the record contents
-		content := make([]byte, iptcSize)
-		n, err = reader.Read(content)
-		if err != nill {
+		// If the high bit of the size is set, this is an Extended DataSet. The
+		// rest of the size is the number of bytes holding the real size.
+		dataSize := uint64(iptcSize)
+		if iptcSize&0x8000 != 0 {
+			lengthOfLength := int(iptcSize & 0x7FFF)
+			if lengthOfLength < 1 || lengthOfLength > 4 {
+				// Can't hold a size this package could read - Probably corrupt data - ERROR
+				return outputArray
+			}
+			extendedSize := make([]byte, lengthOfLength)
+			if _, err := io.ReadFull(reader, extendedSize); err != nil {
+				return outputArray
+			}
+			dataSize = 0
+			for _, b := range extendedSize {
+				dataSize = dataSize<<8 | uint64(b)
+			}
+		}
+
+		// Check if there is sufficient data for reading the record contents. The
+		// size isn't trusted for allocating, as corrupt data can claim up to 4GB.
+		content, err := io.ReadAll(io.LimitReader(reader, int64(dataSize)))
+		if err != nil || uint64(len(content)) != dataSize {
 			// Not enough data left for the record content - Probably corrupt data - ERROR
 			// Change: changed to return partial data as of revision 1.01
 			return outputArray
 		}
 
 		// Add the IPTC record to the output array
-		//$OutputArray[] = array( "IPTC_Type" => $iptctype ,
-		//                        "RecName" => $GLOBALS[ "IPTC_Entry_Names" ][ $iptctype ],
-		//                        "RecDesc" => $GLOBALS[ "IPTC_Entry_Descriptions" ][ $iptctype ],
-		//                        "RecData" => substr( $Data_Str, $pos, $iptc_raw['IPTC_Size'] ) );
-		record := &iptcRecord{recType: iptcType, recRecordNumber: iptcRecordNumber, recDataSetNumber: iptcDataSetNumber, recData: content}
+		record := iptcRecord{recType: iptcType, recRecordNumber: iptcRecordNumber, recDataSetNumber: iptcDataSetNumber, recData: content}
 		outputArray = append(outputArray, record)
 	}
-	return outputArray
 }
 
 /******************************************************************************
@@ -89,7 +121,9 @@ func getIPTC(reader io.Reader) []iptcRecord {
 * Function:     put_IPTC
 *
 * Description:  Encodes an array of IPTC-NAA records into a string encoded
-*               as IPTC-NAA IIM. (The reverse of get_IPTC)
+*               as IPTC-NAA IIM. (The reverse of get_IPTC) When there is
+*               UTF-8 text but no Coded Character Set (1:90), a 1:90 dataset
+*               declaring UTF-8 is added.
 *
 * Parameters:   new_IPTC_block - the IPTC-NAA array to be encoded. Should be
 *                                the same format as that received from get_IPTC
@@ -99,26 +133,68 @@ func getIPTC(reader io.Reader) []iptcRecord {
 ******************************************************************************/
 
 func putIPTC(iptcRecords []iptcRecord) ([]byte, bool) {
+	iptcData, err := putIPTCWithOptions(iptcRecords, iptcWriteOptions{})
+	return iptcData, err == nil
+}
+
+// iptcWriteOptions controls how putIPTCWithOptions encodes records
+type iptcWriteOptions struct {
+	strict bool // Refuse records that break the rules checked by validateIPTCRecords
+}
+
+/******************************************************************************
+*
+* Function:     putIPTCWithOptions
+*
+* Description:  Encodes an array of IPTC-NAA records like putIPTC. In strict
+*               mode the records are validated first, and nothing is encoded
+*               if there are violations.
+*
+* Parameters:   iptcRecords - the IPTC-NAA records to encode
+*               options - the encoding options
+*
+* Returns:      iptcData - the IPTC-NAA IIM data
+*               error - an *IPTCValidationError in strict mode if the records
+*                       are invalid, or if a record is too large to encode
+*
+******************************************************************************/
+
+func putIPTCWithOptions(iptcRecords []iptcRecord, options iptcWriteOptions) ([]byte, error) {
 	// Initialise the output
 	var iptcData bytes.Buffer
 
+	// Declare UTF-8 text, which readers would otherwise take for Latin-1
+	iptcRecords = addIPTCCharsetMarker(iptcRecords)
+
+	if options.strict {
+		if violations := validateIPTCRecords(iptcRecords); len(violations) > 0 {
+			return nil, &IPTCValidationError{violations}
+		}
+	}
+
 	// Cycle through each record in the new IPTC block
 	for _, record := range iptcRecords {
 
-		// Write the IPTC-NAA IIM Tag Marker, Record Number, Dataset Number and Data Size to the packed output data string
-		iptcData.Write(byte(28))
-		iptcData.Write(record.recRecordNumber)
-		iptcData.Write(record.recDataSetNumber)
-		iptcData.Write(uint16(len(record.recData)))
-		iptcData.Write(record.recData)
-		//$iptc_packed_data .= pack( "CCCn", 28, $IPTC_Record, $IPTC_Dataset, strlen($record['RecData']) );
+		// Write the IPTC-NAA IIM Tag Marker, Record Number and Dataset Number to the packed output data string
+		iptcData.Write([]byte{28, record.recRecordNumber, record.recDataSetNumber})
+
+		// Write the Data Size. Data over 32767 bytes needs an Extended DataSet,
+		// where the size is stored in the four bytes after the length-of-length
+		switch {
+		case len(record.recData) <= 0x7FFF:
+			binary.Write(&iptcData, binary.BigEndian, uint16(len(record.recData)))
+		case uint64(len(record.recData)) <= 0xFFFFFFFF:
+			binary.Write(&iptcData, binary.BigEndian, uint16(0x8004))
+			binary.Write(&iptcData, binary.BigEndian, uint32(len(record.recData)))
+		default:
+			return nil, &jpegError{"IPTC record is too large to encode"}
+		}
 
 		// Write the IPTC-NAA IIM Data to the packed output data string
-		//$iptc_packed_data .= $record['RecData'];
-
+		iptcData.Write(record.recData)
 	}
 	// Return the IPTC-NAA IIM data
-	return iptcData.Bytes(), true
+	return iptcData.Bytes(), nil
 }
 
 /******************************************************************************
@@ -165,7 +241,7 @@ var aIPTCEntryNames = map[uint16]string{
 	2*256 + 30:  "Release Date",
 	2*256 + 35:  "Release Time",
 	2*256 + 37:  "Expiration Date",
-	2*256 + 35:  "Expiration Time",
+	2*256 + 38:  "Expiration Time",
 	2*256 + 40:  "Special Instructions",
 	2*256 + 42:  "Action Advised",
 	2*256 + 45:  "Reference Service",
@@ -264,7 +340,7 @@ var aIPTCEntryDescriptions = map[uint16]string{
 	2*256 + 30:  "Release Date - 8 numeric characters CCYYMMDD",
 	2*256 + 35:  "Release Time - 11 characters HHMMSS±HHMM",
 	2*256 + 37:  "Expiration Date - 8 numeric characters CCYYMMDD",
-	2*256 + 35:  "Expiration Time - 11 characters HHMMSS±HHMM",
+	2*256 + 38:  "Expiration Time - 11 characters HHMMSS±HHMM",
 	2*256 + 40:  "Special Instructions - Max 256 Characters",
 	2*256 + 42:  "Action Advised - 2 numeric characters",
 	2*256 + 45:  "Reference Service - Max 10 characters",
@@ -0,0 +1,150 @@
+package EXIF
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// iptcUTF8Marker is the ISO 2022 escape sequence for UTF-8 in 1:90
+var iptcUTF8Marker = []byte{0x1B, '%', 'G'}
+
+/******************************************************************************
+*
+* Function:     iptcCharset
+*
+* Description:  Works out the character set of IPTC text from the value of
+*               the Coded Character Set (1:90) dataset, which holds ISO 2022
+*               escape sequences
+*
+* Parameters:   codedCharacterSet - the value of 1:90, nil if there is none
+*               fallback - the character set to use when 1:90 is missing or
+*                          not understood
+*
+* Returns:      charset - the character set of the text
+*
+******************************************************************************/
+
+func iptcCharset(codedCharacterSet []byte, fallback jpegCharset) jpegCharset {
+	switch {
+	case bytes.Contains(codedCharacterSet, iptcUTF8Marker),
+		bytes.Contains(codedCharacterSet, []byte{0x1B, '%', '/', 'G'}),
+		bytes.Contains(codedCharacterSet, []byte{0x1B, '%', '/', 'H'}),
+		bytes.Contains(codedCharacterSet, []byte{0x1B, '%', '/', 'I'}):
+		return charsetUTF8
+	case bytes.Contains(codedCharacterSet, []byte{0x1B, '-', 'A'}),
+		bytes.Contains(codedCharacterSet, []byte{0x1B, '.', 'A'}):
+		// ISO 8859-1 designated as the G1 or G2 set
+		return charsetLatin1
+	}
+	return fallback
+}
+
+// decodeIPTCTexts fills in recText for the text datasets of the records
+func decodeIPTCTexts(records []iptcRecord, fallback jpegCharset) {
+	var codedCharacterSet []byte
+	for _, record := range records {
+		if record.recRecordNumber == 1 && record.recDataSetNumber == 90 {
+			codedCharacterSet = record.recData
+		}
+	}
+	charset := iptcCharset(codedCharacterSet, fallback)
+
+	for i, record := range records {
+		if isIPTCTextDataSet(record.recRecordNumber, record.recDataSetNumber) {
+			// Text is decoded with the same rules as JPEG comments
+			records[i].recText = decodeJPEGComment(record.recData, charset)
+		}
+	}
+}
+
+/******************************************************************************
+*
+* Function:     transcodeIPTCToUTF8
+*
+* Description:  Re-encodes the text datasets of IPTC data as UTF-8, from the
+*               character set declared by the Coded Character Set (1:90) or
+*               the fallback, so UTF-8 text from elsewhere can be merged in.
+*               The Coded Character Set is removed, as it no longer applies.
+*
+* Parameters:   iptc - the IPTC datasets, which are left unchanged
+*
+* Returns:      iptc - a copy of the datasets with UTF-8 text
+*
+******************************************************************************/
+
+func transcodeIPTCToUTF8(iptc *IPTC) *IPTC {
+	charset := iptc.charset()
+	utf8IPTC := &IPTC{fallback: charsetUTF8}
+	for _, ds := range iptc.DataSets {
+		switch {
+		case ds.Record == 1 && ds.DataSet == 90:
+			continue
+		case isIPTCTextDataSet(ds.Record, ds.DataSet):
+			ds.Value = []byte(decodeJPEGComment(ds.Value, charset))
+		}
+		utf8IPTC.DataSets = append(utf8IPTC.DataSets, ds)
+	}
+	return utf8IPTC
+}
+
+/******************************************************************************
+*
+* Function:     addIPTCCharsetMarker
+*
+* Description:  Adds a Coded Character Set (1:90) dataset declaring UTF-8 when
+*               the records hold non-ASCII UTF-8 text and don't declare their
+*               character set. Text that is not valid UTF-8 next to it is
+*               taken to be legacy Latin-1 and converted to UTF-8, so the
+*               declaration holds for all of it. Records holding only Latin-1
+*               text are left alone.
+*
+* Parameters:   iptcRecords - the records to check
+*
+* Returns:      iptcRecords - the records, with 1:90 added if needed
+*
+******************************************************************************/
+
+func addIPTCCharsetMarker(iptcRecords []iptcRecord) []iptcRecord {
+	nonASCII := false
+	for _, record := range iptcRecords {
+		if record.recRecordNumber == 1 && record.recDataSetNumber == 90 {
+			return iptcRecords
+		}
+		if isIPTCTextDataSet(record.recRecordNumber, record.recDataSetNumber) && !isASCII(record.recData) && utf8.Valid(record.recData) {
+			nonASCII = true
+		}
+	}
+	if !nonASCII {
+		return iptcRecords
+	}
+
+	// The envelope record comes first, and needs its Model Version (1:00)
+	newRecords := []iptcRecord{}
+	if len(iptcRecords) == 0 || iptcRecords[0].recRecordNumber != 1 {
+		newRecords = append(newRecords, iptcRecord{recType: "1:00", recRecordNumber: 1, recDataSetNumber: 0, recData: []byte{0, 4}})
+	}
+
+	marker := iptcRecord{recType: "1:90", recRecordNumber: 1, recDataSetNumber: 90, recData: iptcUTF8Marker}
+	added := false
+	for _, record := range iptcRecords {
+		if isIPTCTextDataSet(record.recRecordNumber, record.recDataSetNumber) && !utf8.Valid(record.recData) {
+			record.recData = []byte(decodeJPEGComment(record.recData, charsetLatin1))
+		}
+		if !added && (record.recRecordNumber > 1 || record.recDataSetNumber > 90) {
+			newRecords = append(newRecords, marker)
+			added = true
+		}
+		newRecords = append(newRecords, record)
+	}
+	if !added {
+		newRecords = append(newRecords, marker)
+	}
+	return newRecords
+}
+
+// isIPTCTextDataSet checks if a dataset holds text. Unknown datasets are
+// taken to be binary.
+func isIPTCTextDataSet(record byte, dataset byte) bool {
+	spec, ok := aIPTCDataSetSpecs[uint16(record)*256+uint16(dataset)]
+	return ok && spec.Kind == IPTCText
+}
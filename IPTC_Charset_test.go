@@ -0,0 +1,104 @@
+package EXIF
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestIPTCCharset(t *testing.T) {
+	tests := []struct {
+		name     string
+		coded    []byte
+		fallback jpegCharset
+		want     jpegCharset
+	}{
+		{"none", nil, charsetAuto, charsetAuto},
+		{"none with fallback", nil, charsetLatin1, charsetLatin1},
+		{"utf-8", []byte{0x1B, '%', 'G'}, charsetLatin1, charsetUTF8},
+		{"utf-8 level 1", []byte{0x1B, '%', '/', 'G'}, charsetLatin1, charsetUTF8},
+		{"utf-8 level 3", []byte{0x1B, '%', '/', 'I'}, charsetAuto, charsetUTF8},
+		{"latin-1 G1", []byte{0x1B, '-', 'A'}, charsetUTF8, charsetLatin1},
+		{"latin-1 G2", []byte{0x1B, '.', 'A'}, charsetUTF8, charsetLatin1},
+		{"latin-1 after G0", []byte{0x1B, '(', 'B', 0x1B, '-', 'A'}, charsetAuto, charsetLatin1},
+		{"unknown", []byte{0x1B, '$', 'B'}, charsetLatin1, charsetLatin1},
+		{"truncated", []byte{0x1B, '%'}, charsetAuto, charsetAuto},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := iptcCharset(tt.coded, tt.fallback); got != tt.want {
+				t.Errorf("iptcCharset() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetIPTCText(t *testing.T) {
+	caption := newTestIPTCRecord(2, 120, "Caf\xe9")
+	utf8Caption := newTestIPTCRecord(2, 120, "Café")
+	tests := []struct {
+		name     string
+		records  []iptcRecord
+		fallback jpegCharset
+		want     string
+	}{
+		{"declared utf-8", []iptcRecord{newTestIPTCRecord(1, 90, "\x1b%G"), utf8Caption}, charsetLatin1, "Café"},
+		{"declared latin-1", []iptcRecord{newTestIPTCRecord(1, 90, "\x1b-A"), caption}, charsetUTF8, "Café"},
+		{"guessed utf-8", []iptcRecord{utf8Caption}, charsetAuto, "Café"},
+		{"guessed latin-1", []iptcRecord{caption}, charsetAuto, "Café"},
+		{"fallback latin-1", []iptcRecord{utf8Caption}, charsetLatin1, "CafÃ©"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := []byte{}
+			for _, record := range tt.records {
+				// Written by hand, as putIPTC would declare the character set
+				data = append(data, 0x1C, record.recRecordNumber, record.recDataSetNumber, 0, byte(len(record.recData)))
+				data = append(data, record.recData...)
+			}
+			records := getIPTCWithFallback(bytes.NewReader(data), tt.fallback)
+			if got := records[len(records)-1].recText; got != tt.want {
+				t.Errorf("recText = %q, want %q", got, tt.want)
+			}
+			if records[0].recType == "1:90" && records[0].recText != "" {
+				t.Errorf("1:90 decoded as text %q", records[0].recText)
+			}
+		})
+	}
+}
+
+func TestPutIPTCCharsetMarker(t *testing.T) {
+	tests := []struct {
+		name      string
+		records   []iptcRecord
+		wantTypes string
+		wantText  string
+	}{
+		{"ascii", []iptcRecord{newTestIPTCRecord(2, 0, "\x00\x04"), newTestIPTCRecord(2, 5, "Title")}, "2:00 2:05", "Title"},
+		{"utf-8", []iptcRecord{newTestIPTCRecord(2, 0, "\x00\x04"), newTestIPTCRecord(2, 5, "Café")}, "1:00 1:90 2:00 2:05", "Café"},
+		{"utf-8 with envelope", []iptcRecord{newTestIPTCRecord(1, 0, "\x00\x04"), newTestIPTCRecord(1, 100, strings.Repeat("u", 14)), newTestIPTCRecord(2, 5, "Café")}, "1:00 1:90 1:100 2:05", "Café"},
+		{"latin-1", []iptcRecord{newTestIPTCRecord(2, 5, "Caf\xe9")}, "2:05", "Café"},
+		{"latin-1 and utf-8", []iptcRecord{newTestIPTCRecord(2, 0, "\x00\x04"), newTestIPTCRecord(2, 5, "Café"), newTestIPTCRecord(2, 120, "Cr\xe8me")}, "1:00 1:90 2:00 2:05 2:120", "Crème"},
+		{"already declared", []iptcRecord{newTestIPTCRecord(1, 90, "\x1b-A"), newTestIPTCRecord(2, 5, "Caf\xe9")}, "1:90 2:05", "Café"},
+		{"binary", []iptcRecord{newTestIPTCRecord(2, 202, "\xff\xd8\xff")}, "2:202", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, ok := putIPTC(tt.records)
+			if !ok {
+				t.Fatal("putIPTC() failed")
+			}
+			records := getIPTC(bytes.NewReader(data))
+			types := []string{}
+			for _, record := range records {
+				types = append(types, record.recType)
+			}
+			if got := strings.Join(types, " "); got != tt.wantTypes {
+				t.Errorf("putIPTC() wrote %s, want %s", got, tt.wantTypes)
+			}
+			if got := records[len(records)-1].recText; got != tt.wantText {
+				t.Errorf("round trip text = %q, want %q", got, tt.wantText)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package EXIF
+
+import (
+	"bytes"
+	"crypto/md5"
+)
+
+// IPTCSyncState says how the IIM and the XMP of a file relate, following the
+// rules of the Metadata Working Group
+type IPTCSyncState int
+
+const (
+	IPTCNoIIM      IPTCSyncState = iota // There is no IIM, XMP is used
+	IPTCInSync                          // The digest matches, IIM was written along with XMP, XMP is preferred
+	IPTCIIMChanged                      // The digest doesn't match, IIM was edited by a tool unaware of XMP and is preferred
+	IPTCNoDigest                        // There is no digest, XMP is preferred and IIM fills the gaps
+)
+
+func (s IPTCSyncState) String() string {
+	switch s {
+	case IPTCNoIIM:
+		return "No IIM"
+	case IPTCInSync:
+		return "In sync"
+	case IPTCIIMChanged:
+		return "IIM changed"
+	case IPTCNoDigest:
+		return "No digest"
+	}
+	return "Unknown"
+}
+
+/******************************************************************************
+*
+* Type:         IPTCSyncReport
+*
+* Description:  The result of comparing the IIM of a file with its XMP and
+*               the IPTC digest
+*
+******************************************************************************/
+
+type IPTCSyncReport struct {
+	State         IPTCSyncState
+	DigestPresent bool     // The Photoshop IRB has a digest resource (0x0425)
+	DigestValid   bool     // The digest matches the IIM data
+	Differences   []string // XMP properties whose value differs from the IIM datasets they correspond to
+}
+
+// computeIPTCDigest returns the MD5 digest of IIM data, as stored by
+// Photoshop in resource 0x0425
+func computeIPTCDigest(iptcData []byte) []byte {
+	digest := md5.Sum(iptcData)
+	return digest[:]
+}
+
+/******************************************************************************
+*
+* Function:     verifyIPTCDigest
+*
+* Description:  Checks the IPTC digest resource (0x0425) against the IIM data
+*               of the IPTC-NAA resource (0x0404)
+*
+* Parameters:   resources - the Photoshop IRB resources
+*
+* Returns:      present - if there is a digest resource
+*               valid - if the digest matches the IIM data
+*
+******************************************************************************/
+
+func verifyIPTCDigest(resources []irbResource) (bool, bool) {
+	var iptcData, digest []byte
+	present := false
+	for _, resource := range resources {
+		switch resource.resID {
+		case 0x0404:
+			iptcData = resource.resData
+		case 0x0425:
+			digest = resource.resData
+			present = true
+		}
+	}
+	return present, present && bytes.Equal(digest, computeIPTCDigest(iptcData))
+}
+
+/******************************************************************************
+*
+* Function:     checkJPEGIPTCSync
+*
+* Description:  Verifies the IPTC digest of the JPEG header data and compares
+*               the IIM datasets with the XMP properties they correspond to
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*
+* Returns:      report - the digest state and the differences
+*               error - if the Photoshop IRB or the XMP could not be decoded
+*
+******************************************************************************/
+
+func checkJPEGIPTCSync(jpegHeader []segment) (IPTCSyncReport, error) {
+	report, _, _, err := compareJPEGIPTCAndXMP(jpegHeader)
+	return report, err
+}
+
+/******************************************************************************
+*
+* Function:     getJPEGIPTCReconciled
+*
+* Description:  Retrieves the IPTC datasets of the JPEG header data, taking
+*               the values from IIM or XMP according to the rules of the
+*               Metadata Working Group. Datasets without an XMP counterpart
+*               always come from IIM. The text is transcoded to UTF-8 before
+*               the XMP values are merged in, and 1:90 declares UTF-8.
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*
+* Returns:      iptc - the reconciled IPTC datasets
+*               report - the digest state and the differences
+*               error - if the Photoshop IRB or the XMP could not be decoded
+*
+******************************************************************************/
+
+func getJPEGIPTCReconciled(jpegHeader []segment) (*IPTC, IPTCSyncReport, error) {
+	report, iim, xmp, err := compareJPEGIPTCAndXMP(jpegHeader)
+	if err != nil {
+		return nil, report, err
+	}
+
+	datasets := []uint16{}
+	for _, mapping := range aIPTCXMPMappings {
+		datasets = append(datasets, mapping.dataset)
+	}
+	for _, mapping := range aIPTCXMPDateMappings {
+		key := uint16(mapping.record) * 256
+		datasets = append(datasets, key+uint16(mapping.date), key+uint16(mapping.time))
+	}
+	// Subject References carry names that the XMP subject codes don't, so
+	// they are only taken from XMP when the numbers differ
+	if containsString(report.Differences, xmpSubjectCodeProperty) {
+		datasets = append(datasets, 2*256+12)
+	}
+
+	// XMP text is UTF-8, whatever character set the IIM uses
+	iptc := transcodeIPTCToUTF8(iim)
+	for _, dataset := range datasets {
+		record, number := byte(dataset>>8), byte(dataset)
+		xmpValues := xmp.Values(record, number)
+		if len(xmpValues) == 0 {
+			continue
+		}
+		if report.State == IPTCIIMChanged && len(iim.Values(record, number)) > 0 {
+			continue
+		}
+		iptc.Set(record, number, xmpValues...)
+	}
+	if len(iptc.DataSets) > 0 {
+		iptc.Set(1, 90, iptcUTF8Marker)
+	}
+	return iptc, report, nil
+}
+
+// compareJPEGIPTCAndXMP decodes the IIM and the XMP of the JPEG header data
+// into IPTC datasets and compares them
+func compareJPEGIPTCAndXMP(jpegHeader []segment) (IPTCSyncReport, *IPTC, *IPTC, error) {
+	report := IPTCSyncReport{State: IPTCNoIIM}
+
+	iim := &IPTC{}
+	for _, seg := range jpegHeader {
+		if !isPhotoshopIRBSegment(seg) {
+			continue
+		}
+		resources, err := getPhotoshopIRB(jpegHeader)
+		if err != nil {
+			return report, nil, nil, err
+		}
+		for _, resource := range resources {
+			if resource.resID == 0x0404 {
+				iim = decodeIPTC(resource.resData)
+				report.State = IPTCNoDigest
+			}
+		}
+		report.DigestPresent, report.DigestValid = verifyIPTCDigest(resources)
+		break
+	}
+	if report.State != IPTCNoIIM && report.DigestPresent {
+		report.State = IPTCIIMChanged
+		if report.DigestValid {
+			report.State = IPTCInSync
+		}
+	}
+
+	xmp := &IPTC{}
+	if packet := getJPEGXMP(jpegHeader); packet != nil {
+		properties, err := decodeXMPProperties(packet)
+		if err != nil {
+			return report, nil, nil, err
+		}
+		// Properties that don't fit IIM are left out, as they can't be compared
+		xmp, _ = xmpPropertiesToIPTC(properties)
+	}
+
+	// Compare both after the conversion to XMP, so the XMP values are cut to
+	// the IIM lengths and dates are in the same form
+	iimProperties := iptcToXMPProperties(iim)
+	xmpProps := iptcToXMPProperties(xmp)
+	for _, name := range iptcXMPPropertyNames() {
+		a, b := iimProperties[name].values, xmpProps[name].values
+		if !equalStrings(a, b) {
+			report.Differences = append(report.Differences, name)
+		}
+	}
+	return report, iim, xmp, nil
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
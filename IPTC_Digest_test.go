@@ -0,0 +1,178 @@
+package EXIF
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// newTestSyncHeader returns header data with IIM records, a digest of them
+// that can be spoiled, and XMP properties
+func newTestSyncHeader(t *testing.T, records []iptcRecord, digest string, properties xmpProperties) []segment {
+	t.Helper()
+	jpegHeader := []segment{newTestSegment(0xE0, []byte("JFIF\x00")), newTestSegment(0xDB, nil)}
+	if records != nil {
+		// Written by hand, as putIPTC would declare the character set
+		iptcData := []byte{}
+		for _, record := range records {
+			iptcData = append(iptcData, 0x1C, record.recRecordNumber, record.recDataSetNumber, 0, byte(len(record.recData)))
+			iptcData = append(iptcData, record.recData...)
+		}
+		resources := []irbResource{{resID: 0x0404, resData: iptcData}}
+		switch digest {
+		case "valid":
+			resources = append(resources, irbResource{resID: 0x0425, resData: computeIPTCDigest(iptcData)})
+		case "stale":
+			resources = append(resources, irbResource{resID: 0x0425, resData: computeIPTCDigest(nil)})
+		}
+		var err error
+		if jpegHeader, err = putPhotoshopIRB(jpegHeader, resources); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if properties != nil {
+		var err error
+		if jpegHeader, err = putJPEGXMP(jpegHeader, newTestXMPPacket(t, properties)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return jpegHeader
+}
+
+func TestVerifyIPTCDigest(t *testing.T) {
+	iptcData := []byte{0x1C, 2, 5, 0, 1, 'x'}
+	tests := []struct {
+		name        string
+		resources   []irbResource
+		wantPresent bool
+		wantValid   bool
+	}{
+		{"none", []irbResource{{resID: 0x0404, resData: iptcData}}, false, false},
+		{"valid", setIPTCResources(nil, iptcData), true, true},
+		{"stale", []irbResource{{resID: 0x0404, resData: iptcData}, {resID: 0x0425, resData: make([]byte, 16)}}, true, false},
+		{"digest without IIM", []irbResource{{resID: 0x0425, resData: computeIPTCDigest(nil)}}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			present, valid := verifyIPTCDigest(tt.resources)
+			if present != tt.wantPresent || valid != tt.wantValid {
+				t.Errorf("verifyIPTCDigest() = %v, %v, want %v, %v", present, valid, tt.wantPresent, tt.wantValid)
+			}
+		})
+	}
+}
+
+func TestGetJPEGIPTCReconciled(t *testing.T) {
+	iimRecords := []iptcRecord{
+		newTestIPTCRecord(2, 0, "\x00\x04"),
+		newTestIPTCRecord(2, 5, "IIM title"),
+		newTestIPTCRecord(2, 7, "IIM only"),
+	}
+	xmp := xmpProperties{
+		"dc:title":           {"Alt", []string{"XMP title"}},
+		"photoshop:Headline": {"", []string{"XMP headline"}},
+	}
+	tests := []struct {
+		name         string
+		records      []iptcRecord
+		digest       string
+		properties   xmpProperties
+		wantState    IPTCSyncState
+		wantTitle    string
+		wantHeadline string
+		wantDiffs    []string
+	}{
+		{"no IIM", nil, "", xmp, IPTCNoIIM, "XMP title", "XMP headline", []string{"dc:title", "photoshop:Headline"}},
+		{"in sync", iimRecords, "valid", xmp, IPTCInSync, "XMP title", "XMP headline", []string{"dc:title", "photoshop:Headline"}},
+		{"IIM changed", iimRecords, "stale", xmp, IPTCIIMChanged, "IIM title", "XMP headline", []string{"dc:title", "photoshop:Headline"}},
+		{"no digest", iimRecords, "", xmp, IPTCNoDigest, "XMP title", "XMP headline", []string{"dc:title", "photoshop:Headline"}},
+		{"no XMP", iimRecords, "valid", nil, IPTCInSync, "IIM title", "", []string{"dc:title"}},
+		{"same values", iimRecords, "valid", xmpProperties{"dc:title": {"Alt", []string{"IIM title"}}}, IPTCInSync, "IIM title", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iptc, report, err := getJPEGIPTCReconciled(newTestSyncHeader(t, tt.records, tt.digest, tt.properties))
+			if err != nil {
+				t.Fatalf("getJPEGIPTCReconciled() error = %v", err)
+			}
+			if report.State != tt.wantState {
+				t.Errorf("State = %v, want %v", report.State, tt.wantState)
+			}
+			if !reflect.DeepEqual(report.Differences, tt.wantDiffs) {
+				t.Errorf("Differences = %v, want %v", report.Differences, tt.wantDiffs)
+			}
+			if got := iptc.ObjectName(); got != tt.wantTitle {
+				t.Errorf("ObjectName() = %q, want %q", got, tt.wantTitle)
+			}
+			if got := iptc.Headline(); got != tt.wantHeadline {
+				t.Errorf("Headline() = %q, want %q", got, tt.wantHeadline)
+			}
+			if tt.records != nil && iptc.Text(2, 7) != "IIM only" {
+				t.Errorf("dataset without XMP counterpart = %q", iptc.Text(2, 7))
+			}
+		})
+	}
+}
+
+func TestGetJPEGIPTCReconciledCharset(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []iptcRecord
+	}{
+		{"declared latin-1", []iptcRecord{newTestIPTCRecord(1, 90, "\x1b-A"), newTestIPTCRecord(2, 0, "\x00\x04"), newTestIPTCRecord(2, 90, "Z\xfcrich"), newTestIPTCRecord(2, 105, "Old")}},
+		{"guessed latin-1", []iptcRecord{newTestIPTCRecord(2, 0, "\x00\x04"), newTestIPTCRecord(2, 90, "Z\xfcrich"), newTestIPTCRecord(2, 105, "Old")}},
+		{"declared utf-8", []iptcRecord{newTestIPTCRecord(1, 90, "\x1b%G"), newTestIPTCRecord(2, 0, "\x00\x04"), newTestIPTCRecord(2, 90, "Zürich"), newTestIPTCRecord(2, 105, "Old")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			xmp := xmpProperties{"photoshop:Headline": {"", []string{"Grüße"}}}
+			iptc, _, err := getJPEGIPTCReconciled(newTestSyncHeader(t, tt.records, "", xmp))
+			if err != nil {
+				t.Fatalf("getJPEGIPTCReconciled() error = %v", err)
+			}
+			if got := iptc.Values(1, 90); len(got) != 1 || !bytes.Equal(got[0], iptcUTF8Marker) {
+				t.Errorf("1:90 = %q, want UTF-8 declared", got)
+			}
+			if got := string(iptc.Values(2, 90)[0]); got != "Zürich" {
+				t.Errorf("2:90 stored as %q, want UTF-8", got)
+			}
+			if got := iptc.Headline(); got != "Grüße" {
+				t.Errorf("Headline() = %q", got)
+			}
+
+			// The reconciled data decodes the same once written
+			data, err := encodeIPTC(iptc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded := decodeIPTC(data)
+			if decoded.City() != "Zürich" || decoded.Headline() != "Grüße" {
+				t.Errorf("written data decodes to %q, %q", decoded.City(), decoded.Headline())
+			}
+		})
+	}
+}
+
+func TestGetJPEGIPTCReconciledSubjectReference(t *testing.T) {
+	records := []iptcRecord{newTestIPTCRecord(2, 0, "\x00\x04"), newTestIPTCRecord(2, 12, "IPTC:15000000:Sports:Local name:")}
+	tests := []struct {
+		name  string
+		codes []string
+		want  []string
+	}{
+		{"same numbers keep the IIM names", []string{"15000000"}, []string{"IPTC:15000000:Sports:Local name:"}},
+		{"other numbers come from XMP", []string{"17000000"}, []string{"IPTC:17000000:weather::"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			xmp := xmpProperties{xmpSubjectCodeProperty: {"Bag", tt.codes}}
+			iptc, _, err := getJPEGIPTCReconciled(newTestSyncHeader(t, records, "valid", xmp))
+			if err != nil {
+				t.Fatalf("getJPEGIPTCReconciled() error = %v", err)
+			}
+			if got := iptc.Texts(2, 12); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("2:12 = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
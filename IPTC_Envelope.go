@@ -0,0 +1,255 @@
+package EXIF
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+/******************************************************************************
+*
+* Type:         IPTCUNO
+*
+* Description:  The Unique Name of Object (1:100), made of four elements
+*               separated by colons: UCD:IPR:ODE:OVI
+*
+******************************************************************************/
+
+type IPTCUNO struct {
+	Date     string // UCD - Unique Class Date, 8 numeric characters CCYYMMDD
+	Provider string // IPR - Information Provider Reference, 1 to 32 characters
+	Object   string // ODE - Object Descriptor Element, 1 to 61 characters
+	Variant  string // OVI - Object Variant Indicator, 1 to 9 characters
+}
+
+// parseIPTCUNO splits a 1:100 value into its elements and checks them
+func parseIPTCUNO(value string) (IPTCUNO, error) {
+	elements := strings.Split(value, ":")
+	if len(elements) != 4 {
+		return IPTCUNO{}, &jpegError{"UNO must have four elements separated by colons"}
+	}
+	uno := IPTCUNO{elements[0], elements[1], elements[2], elements[3]}
+	return uno, uno.validate()
+}
+
+func (u IPTCUNO) String() string {
+	return u.Date + ":" + u.Provider + ":" + u.Object + ":" + u.Variant
+}
+
+// validate checks the length and characters of the UNO elements. The
+// elements may only use graphic characters of ISO 646 IRV, other than the
+// colon separator and the wildcards * and ?
+func (u IPTCUNO) validate() error {
+	if len(u.Date) != 8 || !isIPTCDate([]byte(u.Date)) {
+		return &jpegError{"UNO date must be formatted as CCYYMMDD"}
+	}
+	elements := []struct {
+		name  string
+		value string
+		max   int
+	}{
+		{"provider", u.Provider, 32},
+		{"object descriptor", u.Object, 61},
+		{"object variant", u.Variant, 9},
+	}
+	for _, element := range elements {
+		if len(element.value) < 1 || len(element.value) > element.max {
+			return &jpegError{fmt.Sprintf("UNO %s must be 1 to %d characters long", element.name, element.max)}
+		}
+		for _, c := range []byte(element.value) {
+			if c <= ' ' || c > '~' || c == ':' || c == '*' || c == '?' {
+				return &jpegError{fmt.Sprintf("UNO %s contains the invalid character %q", element.name, c)}
+			}
+		}
+	}
+	return nil
+}
+
+// UNO returns the Unique Name of Object (1:100)
+func (m *IPTC) UNO() (IPTCUNO, bool) {
+	values := m.Values(1, 100)
+	if len(values) == 0 {
+		return IPTCUNO{}, false
+	}
+	uno, err := parseIPTCUNO(string(values[0]))
+	return uno, err == nil
+}
+
+// SetUNO sets the Unique Name of Object (1:100)
+func (m *IPTC) SetUNO(uno IPTCUNO) error {
+	if err := uno.validate(); err != nil {
+		return err
+	}
+	return m.Set(1, 100, []byte(uno.String()))
+}
+
+// FileFormat returns the File Format (1:20) and File Format Version (1:22)
+// of the ObjectData
+func (m *IPTC) FileFormat() (uint16, uint16, bool) {
+	format, ok := m.Uint16(1, 20)
+	if !ok {
+		return 0, 0, false
+	}
+	version, _ := m.Uint16(1, 22)
+	return format, version, true
+}
+
+// FileFormatName returns the name of the File Format (1:20) of the
+// ObjectData, empty if there is none
+func (m *IPTC) FileFormatName() string {
+	format, _, ok := m.FileFormat()
+	if !ok {
+		return ""
+	}
+	return iptcFileFormatName(format)
+}
+
+// SetFileFormat sets the File Format (1:20) and File Format Version (1:22)
+func (m *IPTC) SetFileFormat(format uint16, version uint16) error {
+	if err := m.SetUint16(1, 20, format); err != nil {
+		return err
+	}
+	return m.SetUint16(1, 22, version)
+}
+
+// DateSent returns the Date Sent (1:70) and Time Sent (1:80)
+func (m *IPTC) DateSent() (time.Time, bool) { return m.DateTime(1, 70, 80) }
+
+// SetDateSent sets the Date Sent (1:70) and Time Sent (1:80)
+func (m *IPTC) SetDateSent(t time.Time) error { return m.SetDateTime(1, 70, 80, t) }
+
+// iptcFileFormatName returns the name of a File Format (1:20) number
+func iptcFileFormatName(format uint16) string {
+	if int(format) < len(aIPTCFileFormats) {
+		return aIPTCFileFormats[format]
+	}
+	return fmt.Sprintf("Unknown File Format (%d)", format)
+}
+
+// iptcBinaryNumber decodes the big endian binary numbers of the ObjectData
+// size datasets (7:20, 7:90, 7:95 and 9:10), which are 1 to 4 bytes long
+func iptcBinaryNumber(value []byte) uint64 {
+	n := uint64(0)
+	for _, b := range value {
+		n = n<<8 | uint64(b)
+	}
+	return n
+}
+
+/******************************************************************************
+*
+* Function:     getIIMFile
+*
+* Description:  Reads a standalone IPTC-NAA IIM file, such as a news-wire
+*               object, which holds the envelope and application records
+*               followed by the ObjectData in one or more Subfile (8:10)
+*               datasets
+*
+* Parameters:   filename - the name of the IIM file to read
+*
+* Returns:      iptc - the datasets of the file, without the Subfiles
+*               objectData - the ObjectData, joined from the Subfiles
+*               error - if the file could not be read, or is not IIM data
+*
+******************************************************************************/
+
+func getIIMFile(filename string) (*IPTC, []byte, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	return decodeIIM(data)
+}
+
+// decodeIIM splits standalone IIM data into its datasets and its ObjectData,
+// checking the ObjectData size against the Confirmed ObjectData Size (9:10)
+func decodeIIM(data []byte) (*IPTC, []byte, error) {
+	if len(data) == 0 || data[0] != 0x1C {
+		return nil, nil, &jpegError{"Not an IPTC-NAA IIM file"}
+	}
+	iptc := decodeIPTC(data)
+	objectData := bytes.Join(iptc.Values(8, 10), nil)
+	iptc.Delete(8, 10)
+
+	if values := iptc.Values(9, 10); len(values) > 0 {
+		if confirmed := iptcBinaryNumber(values[0]); confirmed != uint64(len(objectData)) {
+			return iptc, objectData, &jpegError{fmt.Sprintf("ObjectData is %d bytes long, but its Confirmed ObjectData Size is %d", len(objectData), confirmed)}
+		}
+	}
+	return iptc, objectData, nil
+}
+
+/******************************************************************************
+*
+* Function:     putIIMFile
+*
+* Description:  Writes a standalone IPTC-NAA IIM file with the datasets and
+*               the ObjectData provided. The ObjectData is split into Subfiles
+*               (8:10) no larger than the Max Subfile Size (7:20) if there
+*               is one, and its size is announced (7:90) and confirmed (9:10).
+*
+* Parameters:   filename - the name of the IIM file to write
+*               iptc - the datasets to write, which must include the File
+*                      Format (1:20) and its version (1:22) if there is
+*                      ObjectData
+*               objectData - the ObjectData, can be nil
+*
+* Returns:      nil - on Success
+*               error - if the datasets are invalid or the file could not be written
+*
+******************************************************************************/
+
+func putIIMFile(filename string, iptc *IPTC, objectData []byte) error {
+	data, err := encodeIIM(iptc, objectData)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// encodeIIM encodes datasets and ObjectData as standalone IIM data. The
+// datasets are validated strictly, as a receiving system may reject them.
+func encodeIIM(iptc *IPTC, objectData []byte) ([]byte, error) {
+	m := &IPTC{DataSets: append([]IPTCDataSet{}, iptc.DataSets...), fallback: iptc.fallback}
+	m.Delete(7, 90)
+	m.Delete(8, 10)
+	m.Delete(9, 10)
+
+	if len(objectData) > 0 {
+		if _, _, ok := m.FileFormat(); !ok || len(m.Values(1, 22)) == 0 {
+			return nil, &jpegError{"IIM ObjectData needs a File Format (1:20) and File Format Version (1:22)"}
+		}
+
+		if uint64(len(objectData)) > 0xFFFFFFFF {
+			return nil, &jpegError{"IIM ObjectData is too large to encode"}
+		}
+		size := uint32(len(objectData))
+		if err := m.Set(7, 90, []byte{byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size)}); err != nil {
+			return nil, err
+		}
+
+		maxSubfileSize := uint64(len(objectData))
+		if values := m.Values(7, 20); len(values) > 0 && iptcBinaryNumber(values[0]) > 0 {
+			maxSubfileSize = iptcBinaryNumber(values[0])
+		}
+		subfiles := [][]byte{}
+		for remaining := objectData; len(remaining) > 0; {
+			n := len(remaining)
+			if uint64(n) > maxSubfileSize {
+				n = int(maxSubfileSize)
+			}
+			subfiles = append(subfiles, remaining[:n])
+			remaining = remaining[n:]
+		}
+		if err := m.Set(8, 10, subfiles...); err != nil {
+			return nil, err
+		}
+		if err := m.Set(9, 10, []byte{byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size)}); err != nil {
+			return nil, err
+		}
+	}
+
+	return putIPTCWithOptions(m.records(), iptcWriteOptions{strict: true})
+}
@@ -0,0 +1,224 @@
+package EXIF
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseIPTCUNO(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr string
+	}{
+		{"valid", "20240229:example.com:object-1:1", ""},
+		{"unknown day", "20240200:example.com:object-1:1", ""},
+		{"longest elements", "20240229:" + strings.Repeat("p", 32) + ":" + strings.Repeat("o", 61) + ":" + strings.Repeat("v", 9), ""},
+		{"three elements", "20240229:example.com:object-1", "four elements"},
+		{"five elements", "20240229:example.com:object:1:2", "four elements"},
+		{"bad date", "2024229:example.com:object-1:1", "CCYYMMDD"},
+		{"impossible date", "20230229:example.com:object-1:1", "CCYYMMDD"},
+		{"empty provider", "20240229::object-1:1", "provider must be 1 to 32"},
+		{"long provider", "20240229:" + strings.Repeat("p", 33) + ":object-1:1", "provider must be 1 to 32"},
+		{"long object", "20240229:example.com:" + strings.Repeat("o", 62) + ":1", "object descriptor must be 1 to 61"},
+		{"long variant", "20240229:example.com:object-1:" + strings.Repeat("v", 10), "object variant must be 1 to 9"},
+		{"wildcard", "20240229:example.com:object?:1", "invalid character"},
+		{"space", "20240229:example com:object-1:1", "invalid character"},
+		{"non-ASCII", "20240229:exämple.com:object-1:1", "invalid character"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uno, err := parseIPTCUNO(tt.value)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("parseIPTCUNO() error = %v", err)
+				}
+				if uno.String() != tt.value {
+					t.Errorf("String() = %q, want %q", uno.String(), tt.value)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseIPTCUNO() error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIPTCEnvelope(t *testing.T) {
+	iptc := &IPTC{}
+	if _, ok := iptc.UNO(); ok {
+		t.Error("UNO() of empty data succeeded")
+	}
+	if got := iptc.FileFormatName(); got != "" {
+		t.Errorf("FileFormatName() of empty data = %q", got)
+	}
+
+	uno := IPTCUNO{"20240229", "example.com", "object-1", "1"}
+	if err := iptc.SetUNO(uno); err != nil {
+		t.Fatalf("SetUNO() error = %v", err)
+	}
+	if got, ok := iptc.UNO(); !ok || got != uno {
+		t.Errorf("UNO() = %v, %v, want %v", got, ok, uno)
+	}
+	if err := iptc.SetUNO(IPTCUNO{"20240229", "example.com", "a:b", "1"}); err == nil {
+		t.Error("SetUNO() with a colon succeeded")
+	}
+
+	if err := iptc.SetFileFormat(11, 2); err != nil {
+		t.Fatalf("SetFileFormat() error = %v", err)
+	}
+	if format, version, ok := iptc.FileFormat(); !ok || format != 11 || version != 2 {
+		t.Errorf("FileFormat() = %d, %d, %v", format, version, ok)
+	}
+	if got := iptc.FileFormatName(); got != "JPEG File Interchange (JFIF)" {
+		t.Errorf("FileFormatName() = %q", got)
+	}
+	iptc.SetFileFormat(999, 1)
+	if got := iptc.FileFormatName(); got != "Unknown File Format (999)" {
+		t.Errorf("FileFormatName() = %q", got)
+	}
+
+	sent := time.Date(2024, 2, 29, 23, 59, 1, 0, time.FixedZone("", 5*3600+45*60))
+	if err := iptc.SetDateSent(sent); err != nil {
+		t.Fatalf("SetDateSent() error = %v", err)
+	}
+	if got := string(iptc.Values(1, 70)[0]) + string(iptc.Values(1, 80)[0]); got != "20240229235901+0545" {
+		t.Errorf("stored %q", got)
+	}
+	if got, ok := iptc.DateSent(); !ok || !got.Equal(sent) {
+		t.Errorf("DateSent() = %v, %v, want %v", got, ok, sent)
+	}
+	if got, ok := iptc.DateTime(1, 70, 80); !ok || !got.Equal(sent) {
+		t.Errorf("DateTime(1, 70, 80) = %v, %v, want %v", got, ok, sent)
+	}
+	if _, ok := iptc.DateTime(2, 70, 80); ok {
+		t.Error("DateTime(2, 70, 80) read the envelope record")
+	}
+
+	want := []string{"1:00", "1:20", "1:22", "1:70", "1:80", "1:100"}
+	if got := dataSetKeys(iptc); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("datasets = %v, want %v", got, want)
+	}
+}
+
+func TestIPTCBinaryNumber(t *testing.T) {
+	tests := []struct {
+		value []byte
+		want  uint64
+	}{
+		{nil, 0},
+		{[]byte{0x7F}, 0x7F},
+		{[]byte{0x01, 0x00}, 0x100},
+		{[]byte{0x01, 0x02, 0x03}, 0x010203},
+		{[]byte{0xFF, 0xFF, 0xFF, 0xFF}, 0xFFFFFFFF},
+	}
+	for _, tt := range tests {
+		if got := iptcBinaryNumber(tt.value); got != tt.want {
+			t.Errorf("iptcBinaryNumber(% x) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+// newTestIIM returns envelope and application datasets for an IIM file
+func newTestIIM(t *testing.T) *IPTC {
+	t.Helper()
+	iptc := &IPTC{}
+	if err := iptc.SetFileFormat(11, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := iptc.SetHeadline("Headline"); err != nil {
+		t.Fatal(err)
+	}
+	return iptc
+}
+
+func TestIIMFileRoundTrip(t *testing.T) {
+	tests := []struct {
+		name           string
+		size           int
+		maxSubfileSize []byte
+		wantSubfiles   int
+	}{
+		{"no ObjectData", 0, nil, 0},
+		{"one subfile", 1000, nil, 1},
+		{"largest standard subfile", 0x7FFF, nil, 1},
+		{"extended subfile", 70000, nil, 1},
+		{"split subfiles", 70000, []byte{0x7F, 0xFF}, 3},
+		{"exact subfiles", 65534, []byte{0x7F, 0xFF}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iptc := newTestIIM(t)
+			if tt.maxSubfileSize != nil {
+				iptc.DataSets = append(iptc.DataSets, IPTCDataSet{7, 20, tt.maxSubfileSize})
+			}
+			objectData := bytes.Repeat([]byte{0xD8}, tt.size)
+
+			filename := filepath.Join(t.TempDir(), "object.iim")
+			if err := putIIMFile(filename, iptc, objectData); err != nil {
+				t.Fatalf("putIIMFile() error = %v", err)
+			}
+			data, _ := encodeIIM(iptc, objectData)
+			if got := len(decodeIPTC(data).Values(8, 10)); got != tt.wantSubfiles {
+				t.Errorf("wrote %d subfiles, want %d", got, tt.wantSubfiles)
+			}
+
+			got, gotObjectData, err := getIIMFile(filename)
+			if err != nil {
+				t.Fatalf("getIIMFile() error = %v", err)
+			}
+			if !bytes.Equal(gotObjectData, objectData) {
+				t.Errorf("ObjectData is %d bytes, want %d", len(gotObjectData), len(objectData))
+			}
+			if got.Headline() != "Headline" || len(got.Values(8, 10)) != 0 {
+				t.Errorf("datasets = %v", dataSetKeys(got))
+			}
+			if tt.size > 0 && iptcBinaryNumber(got.Values(9, 10)[0]) != uint64(tt.size) {
+				t.Errorf("Confirmed ObjectData Size = % x", got.Values(9, 10)[0])
+			}
+		})
+	}
+}
+
+func TestIIMFileErrors(t *testing.T) {
+	iptc := newTestIIM(t)
+	data, err := encodeIIM(iptc, []byte("object data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizeAt := bytes.LastIndex(data, []byte{0x1C, 9, 10})
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"empty", nil, "Not an IPTC-NAA IIM file"},
+		{"not IIM", []byte("\xFF\xD8\xFF"), "Not an IPTC-NAA IIM file"},
+		{"wrong confirmed size", append(append([]byte{}, data[:sizeAt+5]...), 0, 0, 0, 99), "Confirmed ObjectData Size is 99"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := decodeIIM(tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("decodeIIM() error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, _, err := getIIMFile(filepath.Join(t.TempDir(), "missing.iim")); err == nil {
+		t.Error("getIIMFile() of a missing file succeeded")
+	}
+	if _, err := encodeIIM(&IPTC{}, []byte("object data")); err == nil || !strings.Contains(err.Error(), "File Format") {
+		t.Errorf("encodeIIM() without a File Format error = %v", err)
+	}
+	invalid := newTestIIM(t)
+	invalid.DataSets = append(invalid.DataSets, IPTCDataSet{2, 5, []byte("a")}, IPTCDataSet{2, 5, []byte("b")})
+	if _, err := encodeIIM(invalid, nil); err == nil {
+		t.Error("encodeIIM() of invalid datasets succeeded")
+	}
+}
@@ -0,0 +1,564 @@
+package EXIF
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+/******************************************************************************
+*
+* Type:         IPTC
+*
+* Description:  A typed view of IPTC-NAA IIM data. The datasets are kept in
+*               the order in which they are stored, the accessors decode and
+*               the setters validate the values according to the dataset
+*               specifications in aIPTCDataSetSpecs.
+*
+******************************************************************************/
+
+type IPTC struct {
+	DataSets []IPTCDataSet
+	fallback jpegCharset // Character set of text when there is no Coded Character Set (1:90)
+}
+
+// IPTCDataSet is one dataset of IPTC-NAA IIM data, with its raw value
+type IPTCDataSet struct {
+	Record  byte
+	DataSet byte
+	Value   []byte
+}
+
+// IPTCKind is the kind of value a dataset holds
+type IPTCKind int
+
+const (
+	IPTCText   IPTCKind = iota // Graphic characters
+	IPTCDigits                 // Numeric characters only
+	IPTCDate                   // 8 numeric characters CCYYMMDD
+	IPTCTime                   // 11 characters HHMMSS±HHMM
+	IPTCUint16                 // 2 byte big endian binary number
+	IPTCBinary                 // Binary data
+)
+
+// IPTCDataSetSpec describes the value of one dataset. Lengths are in bytes,
+// a MaxLength of 0 means there is no limit.
+type IPTCDataSetSpec struct {
+	Name       string
+	Kind       IPTCKind
+	Repeatable bool
+	MinLength  int
+	MaxLength  int
+}
+
+/******************************************************************************
+*
+* Function:     decodeIPTC
+*
+* Description:  Decodes IPTC-NAA IIM data into the typed model
+*
+* Parameters:   data - the IPTC-NAA IIM data, e.g. from a Photoshop 0x0404 resource
+*
+* Returns:      iptc - the decoded datasets
+*
+******************************************************************************/
+
+func decodeIPTC(data []byte) *IPTC {
+	return newIPTC(getIPTC(bytes.NewReader(data)))
+}
+
+// newIPTC wraps records as returned by getIPTC in the typed model
+func newIPTC(records []iptcRecord) *IPTC {
+	iptc := &IPTC{}
+	for _, record := range records {
+		iptc.DataSets = append(iptc.DataSets, IPTCDataSet{record.recRecordNumber, record.recDataSetNumber, record.recData})
+	}
+	return iptc
+}
+
+/******************************************************************************
+*
+* Function:     encodeIPTC
+*
+* Description:  Encodes the typed model as IPTC-NAA IIM data
+*
+* Parameters:   iptc - the datasets to encode
+*
+* Returns:      data - the IPTC-NAA IIM data
+*               error - if a dataset is too large to be encoded
+*
+******************************************************************************/
+
+func encodeIPTC(iptc *IPTC) ([]byte, error) {
+	data, ok := putIPTC(iptc.records())
+	if !ok {
+		return nil, &jpegError{"IPTC dataset is too large to be encoded"}
+	}
+	return data, nil
+}
+
+// records converts the datasets to records as used by putIPTC
+func (m *IPTC) records() []iptcRecord {
+	records := []iptcRecord{}
+	for _, ds := range m.DataSets {
+		records = append(records, iptcRecord{
+			recType:          fmt.Sprintf("%01d:%02d", ds.Record, ds.DataSet),
+			recRecordNumber:  ds.Record,
+			recDataSetNumber: ds.DataSet,
+			recData:          ds.Value,
+		})
+	}
+	return records
+}
+
+// getIPTCDataSetSpec returns the specification of a dataset
+func getIPTCDataSetSpec(record byte, dataset byte) (IPTCDataSetSpec, bool) {
+	key := uint16(record)*256 + uint16(dataset)
+	spec, ok := aIPTCDataSetSpecs[key]
+	spec.Name = aIPTCEntryNames[key]
+	return spec, ok
+}
+
+// Values returns the raw values of a dataset, in order
+func (m *IPTC) Values(record byte, dataset byte) [][]byte {
+	values := [][]byte{}
+	for _, ds := range m.DataSets {
+		if ds.Record == record && ds.DataSet == dataset {
+			values = append(values, ds.Value)
+		}
+	}
+	return values
+}
+
+// Text returns the first value of a dataset as a string
+func (m *IPTC) Text(record byte, dataset byte) string {
+	values := m.Values(record, dataset)
+	if len(values) == 0 {
+		return ""
+	}
+	return m.text(values[0])
+}
+
+// Texts returns all the values of a repeatable dataset as strings
+func (m *IPTC) Texts(record byte, dataset byte) []string {
+	texts := []string{}
+	for _, value := range m.Values(record, dataset) {
+		texts = append(texts, m.text(value))
+	}
+	return texts
+}
+
+// text decodes a text value in the character set of the data
+func (m *IPTC) text(value []byte) string {
+	return decodeJPEGComment(value, m.charset())
+}
+
+// charset returns the character set declared by 1:90, or the fallback
+func (m *IPTC) charset() jpegCharset {
+	values := m.Values(1, 90)
+	if len(values) == 0 {
+		return m.fallback
+	}
+	return iptcCharset(values[0], m.fallback)
+}
+
+// Number returns the first value of a numeric dataset
+func (m *IPTC) Number(record byte, dataset byte) (int, bool) {
+	values := m.Values(record, dataset)
+	if len(values) == 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(string(values[0]))
+	return n, err == nil
+}
+
+// Uint16 returns the first value of a 2 byte binary dataset
+func (m *IPTC) Uint16(record byte, dataset byte) (uint16, bool) {
+	values := m.Values(record, dataset)
+	if len(values) == 0 || len(values[0]) != 2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(values[0]), true
+}
+
+// DateTime combines a date dataset and a time dataset of a record into a
+// time.Time. If the time dataset is missing, the result is midnight UTC of
+// the date.
+func (m *IPTC) DateTime(record byte, dateDataSet byte, timeDataSet byte) (time.Time, bool) {
+	dates := m.Values(record, dateDataSet)
+	if len(dates) == 0 {
+		return time.Time{}, false
+	}
+	value := string(dates[0])
+	layout := "20060102"
+
+	times := m.Values(record, timeDataSet)
+	if len(times) > 0 {
+		switch len(times[0]) {
+		case 11:
+			value += string(times[0])
+			layout += "150405-0700"
+		case 6:
+			value += string(times[0])
+			layout += "150405"
+		}
+	}
+
+	t, err := time.Parse(layout, value)
+	return t, err == nil
+}
+
+// Set replaces all the values of a dataset. The values are validated
+// against the dataset specification, and no values deletes the dataset.
+// The record version dataset is added when the record doesn't have one.
+func (m *IPTC) Set(record byte, dataset byte, values ...[]byte) error {
+	spec, known := getIPTCDataSetSpec(record, dataset)
+	if known {
+		if len(values) > 1 && !spec.Repeatable {
+			return &jpegError{fmt.Sprintf("IPTC %d:%02d %s is not repeatable", record, dataset, spec.Name)}
+		}
+		for _, value := range values {
+			if err := validateIPTCValue(record, dataset, spec, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	m.Delete(record, dataset)
+	if len(values) == 0 {
+		return nil
+	}
+
+	if dataset != 0 && (record == 1 || record == 2) && len(m.Values(record, 0)) == 0 {
+		m.insert(IPTCDataSet{record, 0, []byte{0, 4}})
+	}
+	for _, value := range values {
+		m.insert(IPTCDataSet{record, dataset, value})
+	}
+	return nil
+}
+
+// insert adds a dataset after the datasets that sort before or with it, so
+// records stay in ascending order
+func (m *IPTC) insert(ds IPTCDataSet) {
+	key := uint16(ds.Record)*256 + uint16(ds.DataSet)
+	i := len(m.DataSets)
+	for i > 0 && uint16(m.DataSets[i-1].Record)*256+uint16(m.DataSets[i-1].DataSet) > key {
+		i--
+	}
+	m.DataSets = append(m.DataSets, IPTCDataSet{})
+	copy(m.DataSets[i+1:], m.DataSets[i:])
+	m.DataSets[i] = ds
+}
+
+// Delete removes all the values of a dataset
+func (m *IPTC) Delete(record byte, dataset byte) {
+	dataSets := m.DataSets[:0]
+	for _, ds := range m.DataSets {
+		if ds.Record != record || ds.DataSet != dataset {
+			dataSets = append(dataSets, ds)
+		}
+	}
+	m.DataSets = dataSets
+}
+
+// SetText replaces all the values of a text dataset. Text is stored as
+// UTF-8, unless 1:90 declares Latin-1. When non-ASCII text is stored and
+// 1:90 is missing, the text already there is converted to UTF-8 and 1:90 is
+// set to declare UTF-8, so legacy Latin-1 text is not mixed with UTF-8.
+func (m *IPTC) SetText(record byte, dataset byte, texts ...string) error {
+	values := [][]byte{}
+	nonASCII := false
+	for _, text := range texts {
+		value := []byte(text)
+		if m.charset() == charsetLatin1 && len(m.Values(1, 90)) > 0 {
+			var err error
+			if value, err = encodeJPEGComment(text, charsetLatin1); err != nil {
+				return &jpegError{fmt.Sprintf("IPTC %d:%02d text is not in the Latin-1 character set declared by 1:90", record, dataset)}
+			}
+		}
+		nonASCII = nonASCII || !isASCII(value)
+		values = append(values, value)
+	}
+
+	if !nonASCII || len(m.Values(1, 90)) > 0 {
+		return m.Set(record, dataset, values...)
+	}
+	utf8IPTC := transcodeIPTCToUTF8(m)
+	if err := utf8IPTC.Set(record, dataset, values...); err != nil {
+		return err
+	}
+	*m = *utf8IPTC
+	return m.Set(1, 90, iptcUTF8Marker)
+}
+
+// SetNumber sets a numeric dataset, padding it with zeros to its length
+func (m *IPTC) SetNumber(record byte, dataset byte, n int) error {
+	spec, _ := getIPTCDataSetSpec(record, dataset)
+	return m.Set(record, dataset, []byte(fmt.Sprintf("%0*d", spec.MinLength, n)))
+}
+
+// SetUint16 sets a 2 byte binary dataset
+func (m *IPTC) SetUint16(record byte, dataset byte, n uint16) error {
+	value := make([]byte, 2)
+	binary.BigEndian.PutUint16(value, n)
+	return m.Set(record, dataset, value)
+}
+
+// SetDateTime sets a date dataset and a time dataset of a record from a time.Time
+func (m *IPTC) SetDateTime(record byte, dateDataSet byte, timeDataSet byte, t time.Time) error {
+	if err := m.Set(record, dateDataSet, []byte(t.Format("20060102"))); err != nil {
+		return err
+	}
+	return m.Set(record, timeDataSet, []byte(t.Format("150405-0700")))
+}
+
+/******************************************************************************
+*
+* Function:     validateIPTCValue
+*
+* Description:  Checks that a value matches the kind and length of a dataset
+*
+* Parameters:   record - the record number
+*               dataset - the dataset number
+*               spec - the dataset specification
+*               value - the value to check
+*
+* Returns:      error - describing why the value is invalid, nil if it is valid
+*
+******************************************************************************/
+
+func validateIPTCValue(record byte, dataset byte, spec IPTCDataSetSpec, value []byte) error {
+	if rule, problem := checkIPTCValue(spec, value); rule != IPTCRuleNone {
+		return &jpegError{fmt.Sprintf("IPTC %d:%02d %s %s", record, dataset, spec.Name, problem)}
+	}
+	return nil
+}
+
+// isIPTCDate checks a CCYYMMDD date, where IIM allows 00 for an unknown
+// month or day
+func isIPTCDate(value []byte) bool {
+	if len(value) != 8 || !isIPTCDigits(value) {
+		return false
+	}
+	month, day := string(value[4:6]), string(value[6:8])
+	switch {
+	case month == "00":
+		return day == "00"
+	case day == "00":
+		_, err := time.Parse("200601", string(value[0:6]))
+		return err == nil
+	}
+	_, err := time.Parse("20060102", string(value))
+	return err == nil
+}
+
+func isIPTCDigits(value []byte) bool {
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Keywords returns the keywords, 2:25
+func (m *IPTC) Keywords() []string { return m.Texts(2, 25) }
+
+// SetKeywords replaces the keywords, 2:25
+func (m *IPTC) SetKeywords(keywords []string) error { return m.SetText(2, 25, keywords...) }
+
+// ObjectName returns the object name (title), 2:05
+func (m *IPTC) ObjectName() string { return m.Text(2, 5) }
+
+// SetObjectName sets the object name (title), 2:05
+func (m *IPTC) SetObjectName(name string) error { return m.setSingleText(2, 5, name) }
+
+// Headline returns the headline, 2:105
+func (m *IPTC) Headline() string { return m.Text(2, 105) }
+
+// SetHeadline sets the headline, 2:105
+func (m *IPTC) SetHeadline(headline string) error { return m.setSingleText(2, 105, headline) }
+
+// Caption returns the caption/abstract, 2:120
+func (m *IPTC) Caption() string { return m.Text(2, 120) }
+
+// SetCaption sets the caption/abstract, 2:120
+func (m *IPTC) SetCaption(caption string) error { return m.setSingleText(2, 120, caption) }
+
+// ByLines returns the by-lines (authors), 2:80
+func (m *IPTC) ByLines() []string { return m.Texts(2, 80) }
+
+// SetByLines replaces the by-lines (authors), 2:80
+func (m *IPTC) SetByLines(byLines []string) error { return m.SetText(2, 80, byLines...) }
+
+// Credit returns the credit, 2:110
+func (m *IPTC) Credit() string { return m.Text(2, 110) }
+
+// SetCredit sets the credit, 2:110
+func (m *IPTC) SetCredit(credit string) error { return m.setSingleText(2, 110, credit) }
+
+// Source returns the source, 2:115
+func (m *IPTC) Source() string { return m.Text(2, 115) }
+
+// SetSource sets the source, 2:115
+func (m *IPTC) SetSource(source string) error { return m.setSingleText(2, 115, source) }
+
+// CopyrightNotice returns the copyright notice, 2:116
+func (m *IPTC) CopyrightNotice() string { return m.Text(2, 116) }
+
+// SetCopyrightNotice sets the copyright notice, 2:116
+func (m *IPTC) SetCopyrightNotice(notice string) error { return m.setSingleText(2, 116, notice) }
+
+// City returns the city, 2:90
+func (m *IPTC) City() string { return m.Text(2, 90) }
+
+// SetCity sets the city, 2:90
+func (m *IPTC) SetCity(city string) error { return m.setSingleText(2, 90, city) }
+
+// ProvinceState returns the province or state, 2:95
+func (m *IPTC) ProvinceState() string { return m.Text(2, 95) }
+
+// SetProvinceState sets the province or state, 2:95
+func (m *IPTC) SetProvinceState(provinceState string) error {
+	return m.setSingleText(2, 95, provinceState)
+}
+
+// CountryName returns the country name, 2:101
+func (m *IPTC) CountryName() string { return m.Text(2, 101) }
+
+// SetCountryName sets the country name, 2:101
+func (m *IPTC) SetCountryName(country string) error { return m.setSingleText(2, 101, country) }
+
+// CountryCode returns the country code, 2:100
+func (m *IPTC) CountryCode() string { return m.Text(2, 100) }
+
+// SetCountryCode sets the country code, 2:100
+func (m *IPTC) SetCountryCode(code string) error { return m.setSingleText(2, 100, code) }
+
+// DateCreated returns the date and time the content was created, 2:55 and 2:60
+func (m *IPTC) DateCreated() (time.Time, bool) { return m.DateTime(2, 55, 60) }
+
+// SetDateCreated sets the date and time the content was created, 2:55 and 2:60
+func (m *IPTC) SetDateCreated(t time.Time) error { return m.SetDateTime(2, 55, 60, t) }
+
+// DigitalCreationDate returns the date and time the digital representation
+// was created, 2:62 and 2:63
+func (m *IPTC) DigitalCreationDate() (time.Time, bool) { return m.DateTime(2, 62, 63) }
+
+// SetDigitalCreationDate sets the date and time the digital representation
+// was created, 2:62 and 2:63
+func (m *IPTC) SetDigitalCreationDate(t time.Time) error {
+	return m.SetDateTime(2, 62, 63, t)
+}
+
+// setSingleText sets a non-repeatable text dataset, an empty text deletes it
+func (m *IPTC) setSingleText(record byte, dataset byte, text string) error {
+	if text == "" {
+		m.Delete(record, dataset)
+		return nil
+	}
+	return m.SetText(record, dataset, text)
+}
+
+/******************************************************************************
+* Global Variable:      IPTC_DataSet_Specs
+*
+* Contents:     The value kind, repeatability and length of the IPTC-NAA IIM
+*               fields, as described in aIPTCEntryDescriptions
+*
+******************************************************************************/
+
+var aIPTCDataSetSpecs = map[uint16]IPTCDataSetSpec{
+	// Envelope Record
+	1*256 + 0:   {Kind: IPTCUint16, MinLength: 2, MaxLength: 2},
+	1*256 + 5:   {Kind: IPTCText, Repeatable: true, MaxLength: 1024},
+	1*256 + 20:  {Kind: IPTCUint16, MinLength: 2, MaxLength: 2},
+	1*256 + 22:  {Kind: IPTCUint16, MinLength: 2, MaxLength: 2},
+	1*256 + 30:  {Kind: IPTCText, MaxLength: 10},
+	1*256 + 40:  {Kind: IPTCDigits, MinLength: 8, MaxLength: 8},
+	1*256 + 50:  {Kind: IPTCText, Repeatable: true, MaxLength: 32},
+	1*256 + 60:  {Kind: IPTCDigits, MinLength: 1, MaxLength: 1},
+	1*256 + 70:  {Kind: IPTCDate, MinLength: 8, MaxLength: 8},
+	1*256 + 80:  {Kind: IPTCTime, MinLength: 11, MaxLength: 11},
+	1*256 + 90:  {Kind: IPTCBinary, MaxLength: 32},
+	1*256 + 100: {Kind: IPTCText, MinLength: 14, MaxLength: 80},
+	1*256 + 120: {Kind: IPTCUint16, MinLength: 2, MaxLength: 2},
+	1*256 + 122: {Kind: IPTCUint16, MinLength: 2, MaxLength: 2},
+
+	// Application Record
+	2*256 + 0:   {Kind: IPTCUint16, MinLength: 2, MaxLength: 2},
+	2*256 + 3:   {Kind: IPTCText, MinLength: 3, MaxLength: 67},
+	2*256 + 5:   {Kind: IPTCText, MaxLength: 64},
+	2*256 + 7:   {Kind: IPTCText, MaxLength: 64},
+	2*256 + 8:   {Kind: IPTCDigits, MinLength: 2, MaxLength: 2},
+	2*256 + 10:  {Kind: IPTCDigits, MinLength: 1, MaxLength: 1},
+	2*256 + 12:  {Kind: IPTCText, Repeatable: true, MinLength: 13, MaxLength: 236},
+	2*256 + 15:  {Kind: IPTCText, MaxLength: 3},
+	2*256 + 20:  {Kind: IPTCText, Repeatable: true, MaxLength: 32},
+	2*256 + 22:  {Kind: IPTCText, MaxLength: 32},
+	2*256 + 25:  {Kind: IPTCText, Repeatable: true, MaxLength: 64},
+	2*256 + 26:  {Kind: IPTCText, Repeatable: true, MinLength: 3, MaxLength: 3},
+	2*256 + 27:  {Kind: IPTCText, Repeatable: true, MaxLength: 64},
+	2*256 + 30:  {Kind: IPTCDate, MinLength: 8, MaxLength: 8},
+	2*256 + 35:  {Kind: IPTCTime, MinLength: 11, MaxLength: 11},
+	2*256 + 37:  {Kind: IPTCDate, MinLength: 8, MaxLength: 8},
+	2*256 + 38:  {Kind: IPTCTime, MinLength: 11, MaxLength: 11},
+	2*256 + 40:  {Kind: IPTCText, MaxLength: 256},
+	2*256 + 42:  {Kind: IPTCDigits, MinLength: 2, MaxLength: 2},
+	2*256 + 45:  {Kind: IPTCText, Repeatable: true, MaxLength: 10},
+	2*256 + 47:  {Kind: IPTCDate, Repeatable: true, MinLength: 8, MaxLength: 8},
+	2*256 + 50:  {Kind: IPTCDigits, Repeatable: true, MinLength: 8, MaxLength: 8},
+	2*256 + 55:  {Kind: IPTCDate, MinLength: 8, MaxLength: 8},
+	2*256 + 60:  {Kind: IPTCTime, MinLength: 11, MaxLength: 11},
+	2*256 + 62:  {Kind: IPTCDate, MinLength: 8, MaxLength: 8},
+	2*256 + 63:  {Kind: IPTCTime, MinLength: 11, MaxLength: 11},
+	2*256 + 65:  {Kind: IPTCText, MaxLength: 32},
+	2*256 + 70:  {Kind: IPTCText, MaxLength: 10},
+	2*256 + 75:  {Kind: IPTCText, MinLength: 1, MaxLength: 1},
+	2*256 + 80:  {Kind: IPTCText, Repeatable: true, MaxLength: 32},
+	2*256 + 85:  {Kind: IPTCText, Repeatable: true, MaxLength: 32},
+	2*256 + 90:  {Kind: IPTCText, MaxLength: 32},
+	2*256 + 92:  {Kind: IPTCText, MaxLength: 32},
+	2*256 + 95:  {Kind: IPTCText, MaxLength: 32},
+	2*256 + 100: {Kind: IPTCText, MinLength: 3, MaxLength: 3},
+	2*256 + 101: {Kind: IPTCText, MaxLength: 64},
+	2*256 + 103: {Kind: IPTCText, MaxLength: 32},
+	2*256 + 105: {Kind: IPTCText, MaxLength: 256},
+	2*256 + 110: {Kind: IPTCText, MaxLength: 32},
+	2*256 + 115: {Kind: IPTCText, MaxLength: 32},
+	2*256 + 116: {Kind: IPTCText, MaxLength: 128},
+	2*256 + 118: {Kind: IPTCText, Repeatable: true, MaxLength: 128},
+	2*256 + 120: {Kind: IPTCText, MaxLength: 2000},
+	2*256 + 122: {Kind: IPTCText, Repeatable: true, MaxLength: 32},
+	2*256 + 125: {Kind: IPTCBinary, MinLength: 7360, MaxLength: 7360},
+	2*256 + 130: {Kind: IPTCText, MinLength: 2, MaxLength: 2},
+	2*256 + 131: {Kind: IPTCText, MinLength: 1, MaxLength: 1},
+	2*256 + 135: {Kind: IPTCText, MinLength: 2, MaxLength: 3},
+	2*256 + 150: {Kind: IPTCText, MinLength: 2, MaxLength: 2},
+	2*256 + 151: {Kind: IPTCDigits, MinLength: 6, MaxLength: 6},
+	2*256 + 152: {Kind: IPTCDigits, MinLength: 2, MaxLength: 2},
+	2*256 + 153: {Kind: IPTCDigits, MinLength: 6, MaxLength: 6},
+	2*256 + 154: {Kind: IPTCText, MaxLength: 64},
+	2*256 + 200: {Kind: IPTCUint16, MinLength: 2, MaxLength: 2},
+	2*256 + 201: {Kind: IPTCUint16, MinLength: 2, MaxLength: 2},
+	2*256 + 202: {Kind: IPTCBinary, MaxLength: 256000},
+
+	// Pre-ObjectData Descriptor Record
+	7*256 + 10: {Kind: IPTCDigits, MinLength: 1, MaxLength: 1},
+	7*256 + 20: {Kind: IPTCBinary, MinLength: 1, MaxLength: 4},
+	7*256 + 90: {Kind: IPTCBinary, MinLength: 1, MaxLength: 4},
+	7*256 + 95: {Kind: IPTCBinary, MinLength: 1, MaxLength: 4},
+
+	// ObjectData Record
+	8*256 + 10: {Kind: IPTCBinary, Repeatable: true},
+
+	// Post ObjectData Descriptor Record
+	9*256 + 10: {Kind: IPTCBinary, MinLength: 1, MaxLength: 4},
+}
+
+/******************************************************************************
+* End of Global Variable:     IPTC_DataSet_Specs
+******************************************************************************/
@@ -0,0 +1,331 @@
+package EXIF
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dataSetKeys lists the record and dataset numbers of the datasets, in order
+func dataSetKeys(iptc *IPTC) []string {
+	keys := []string{}
+	for _, ds := range iptc.DataSets {
+		keys = append(keys, newTestIPTCRecord(ds.Record, ds.DataSet, "").recType)
+	}
+	return keys
+}
+
+func TestIPTCSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  byte
+		dataset byte
+		values  []string
+		wantErr string
+	}{
+		{"text", 2, 5, []string{"Title"}, ""},
+		{"repeatable", 2, 25, []string{"one", "two", "three"}, ""},
+		{"not repeatable", 2, 5, []string{"one", "two"}, "not repeatable"},
+		{"too long", 2, 5, []string{strings.Repeat("x", 65)}, "2:05"},
+		{"longest", 2, 5, []string{strings.Repeat("x", 64)}, ""},
+		{"too short", 2, 3, []string{"ab"}, "2:03"},
+		{"digits", 2, 10, []string{"5"}, ""},
+		{"not digits", 2, 10, []string{"x"}, "2:10"},
+		{"date", 2, 55, []string{"20240229"}, ""},
+		{"date unknown day", 2, 55, []string{"20240200"}, ""},
+		{"date unknown month", 2, 55, []string{"20240000"}, ""},
+		{"date unknown month only", 2, 55, []string{"20240015"}, "2:55"},
+		{"date invalid", 2, 55, []string{"20230229"}, "2:55"},
+		{"date too short", 2, 55, []string{"2024022"}, "2:55"},
+		{"time", 2, 60, []string{"133015+0100"}, ""},
+		{"time too short", 2, 60, []string{"133015"}, "2:60"},
+		{"uint16", 1, 20, []string{"\x00\x0b"}, ""},
+		{"uint16 too long", 1, 20, []string{"\x00\x00\x0b"}, "1:20"},
+		{"unknown dataset", 3, 99, []string{"anything", "goes"}, ""},
+		{"delete", 2, 25, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iptc := &IPTC{}
+			values := [][]byte{}
+			for _, value := range tt.values {
+				values = append(values, []byte(value))
+			}
+			err := iptc.Set(tt.record, tt.dataset, values...)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Set() error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				if len(iptc.DataSets) != 0 {
+					t.Errorf("Set() failed but stored %v", dataSetKeys(iptc))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			if got := iptc.Values(tt.record, tt.dataset); !reflect.DeepEqual(got, values) {
+				t.Errorf("Values() = %q, want %q", got, values)
+			}
+		})
+	}
+}
+
+func TestIPTCSetOrder(t *testing.T) {
+	iptc := &IPTC{}
+	steps := []struct {
+		record  byte
+		dataset byte
+		value   string
+	}{
+		{2, 120, "Caption"},
+		{2, 5, "Title"},
+		{1, 90, "\x1b%G"},
+		{2, 25, "keyword"},
+		{2, 5, "New title"},
+		{9, 10, "\x00\x00"},
+	}
+	for _, step := range steps {
+		if err := iptc.Set(step.record, step.dataset, []byte(step.value)); err != nil {
+			t.Fatalf("Set(%d, %d) error = %v", step.record, step.dataset, err)
+		}
+	}
+
+	want := []string{"1:00", "1:90", "2:00", "2:05", "2:25", "2:120", "9:10"}
+	if got := dataSetKeys(iptc); !reflect.DeepEqual(got, want) {
+		t.Errorf("datasets = %v, want %v", got, want)
+	}
+	if got := iptc.ObjectName(); got != "New title" {
+		t.Errorf("ObjectName() = %q, want %q", got, "New title")
+	}
+	if got, ok := iptc.Uint16(2, 0); !ok || got != 4 {
+		t.Errorf("record version = %d, %v, want 4", got, ok)
+	}
+
+	// An existing record version is kept
+	iptc = &IPTC{DataSets: []IPTCDataSet{{2, 0, []byte{0, 2}}}}
+	if err := iptc.SetHeadline("Headline"); err != nil {
+		t.Fatalf("SetHeadline() error = %v", err)
+	}
+	if got, _ := iptc.Uint16(2, 0); got != 2 || len(iptc.Values(2, 0)) != 1 {
+		t.Errorf("record version = %d, %d times, want 2 once", got, len(iptc.Values(2, 0)))
+	}
+
+	iptc.Delete(2, 105)
+	if got := dataSetKeys(iptc); !reflect.DeepEqual(got, []string{"2:00"}) {
+		t.Errorf("datasets after Delete() = %v", got)
+	}
+}
+
+func TestIPTCAccessors(t *testing.T) {
+	iptc := &IPTC{}
+	setters := []error{
+		iptc.SetKeywords([]string{"sea", "sky"}),
+		iptc.SetObjectName("Object"),
+		iptc.SetHeadline("Headline"),
+		iptc.SetCaption("Caption"),
+		iptc.SetByLines([]string{"Jo", "Sam"}),
+		iptc.SetCredit("Credit"),
+		iptc.SetSource("Source"),
+		iptc.SetCopyrightNotice("© Someone"),
+		iptc.SetCity("City"),
+		iptc.SetProvinceState("State"),
+		iptc.SetCountryName("Country"),
+		iptc.SetCountryCode("XYZ"),
+		iptc.SetNumber(2, 10, 5),
+		iptc.SetUint16(1, 20, 11),
+	}
+	for i, err := range setters {
+		if err != nil {
+			t.Fatalf("setter %d error = %v", i, err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"Keywords", iptc.Keywords(), []string{"sea", "sky"}},
+		{"ObjectName", iptc.ObjectName(), "Object"},
+		{"Headline", iptc.Headline(), "Headline"},
+		{"Caption", iptc.Caption(), "Caption"},
+		{"ByLines", iptc.ByLines(), []string{"Jo", "Sam"}},
+		{"Credit", iptc.Credit(), "Credit"},
+		{"Source", iptc.Source(), "Source"},
+		{"CopyrightNotice", iptc.CopyrightNotice(), "© Someone"},
+		{"City", iptc.City(), "City"},
+		{"ProvinceState", iptc.ProvinceState(), "State"},
+		{"CountryName", iptc.CountryName(), "Country"},
+		{"CountryCode", iptc.CountryCode(), "XYZ"},
+		{"Urgency raw", string(iptc.Values(2, 10)[0]), "5"},
+		{"Missing text", iptc.Text(2, 40), ""},
+		{"Missing texts", iptc.Texts(2, 40), []string{}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s = %#v, want %#v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	if n, ok := iptc.Number(2, 10); !ok || n != 5 {
+		t.Errorf("Number(2, 10) = %d, %v, want 5", n, ok)
+	}
+	if _, ok := iptc.Number(2, 5); ok {
+		t.Error("Number(2, 5) of text succeeded")
+	}
+	if n, ok := iptc.Uint16(1, 20); !ok || n != 11 {
+		t.Errorf("Uint16(1, 20) = %d, %v, want 11", n, ok)
+	}
+
+	// An empty text deletes a single dataset
+	if err := iptc.SetHeadline(""); err != nil || len(iptc.Values(2, 105)) != 0 {
+		t.Errorf("SetHeadline(\"\") = %v, left %q", err, iptc.Values(2, 105))
+	}
+}
+
+func TestIPTCDateTime(t *testing.T) {
+	tests := []struct {
+		name   string
+		date   string
+		time   string
+		want   time.Time
+		wantOK bool
+	}{
+		{"date and time", "20240229", "133015+0100", time.Date(2024, 2, 29, 13, 30, 15, 0, time.FixedZone("", 3600)), true},
+		{"negative offset", "19991231", "235959-0500", time.Date(1999, 12, 31, 23, 59, 59, 0, time.FixedZone("", -5*3600)), true},
+		{"time without offset", "20240229", "133015", time.Date(2024, 2, 29, 13, 30, 15, 0, time.UTC), true},
+		{"date only", "20240229", "", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), true},
+		{"no date", "", "133015+0100", time.Time{}, false},
+		{"unknown day", "20240200", "", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iptc := &IPTC{}
+			if tt.date != "" {
+				iptc.DataSets = append(iptc.DataSets, IPTCDataSet{2, 55, []byte(tt.date)})
+			}
+			if tt.time != "" {
+				iptc.DataSets = append(iptc.DataSets, IPTCDataSet{2, 60, []byte(tt.time)})
+			}
+			got, ok := iptc.DateCreated()
+			if ok != tt.wantOK || !got.Equal(tt.want) {
+				t.Errorf("DateCreated() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+
+	// Round trip
+	iptc := &IPTC{}
+	want := time.Date(2021, 6, 1, 8, 5, 9, 0, time.FixedZone("", -(3*3600+30*60)))
+	if err := iptc.SetDigitalCreationDate(want); err != nil {
+		t.Fatalf("SetDigitalCreationDate() error = %v", err)
+	}
+	if got := iptc.Text(2, 62) + " " + iptc.Text(2, 63); got != "20210601 080509-0330" {
+		t.Errorf("stored %q", got)
+	}
+	if got, ok := iptc.DigitalCreationDate(); !ok || !got.Equal(want) {
+		t.Errorf("DigitalCreationDate() = %v, %v, want %v", got, ok, want)
+	}
+}
+
+func TestIPTCSetTextCharset(t *testing.T) {
+	tests := []struct {
+		name     string
+		charset  string
+		text     string
+		want     []byte
+		wantFail bool
+	}{
+		{"undeclared", "", "Café", []byte("Café"), false},
+		{"utf-8", "\x1b%G", "Café", []byte("Café"), false},
+		{"latin-1", "\x1b-A", "Café", []byte("Caf\xe9"), false},
+		{"latin-1 unencodable", "\x1b-A", "Ωmega", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iptc := &IPTC{}
+			if tt.charset != "" {
+				iptc.DataSets = append(iptc.DataSets, IPTCDataSet{1, 90, []byte(tt.charset)})
+			}
+			err := iptc.SetText(2, 120, tt.text)
+			if tt.wantFail {
+				if err == nil {
+					t.Fatal("SetText() succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SetText() error = %v", err)
+			}
+			if got := iptc.Values(2, 120)[0]; !bytes.Equal(got, tt.want) {
+				t.Errorf("stored % x, want % x", got, tt.want)
+			}
+			if got := iptc.Caption(); got != tt.text {
+				t.Errorf("Caption() = %q, want %q", got, tt.text)
+			}
+		})
+	}
+}
+
+func TestIPTCSetTextLegacyLatin1(t *testing.T) {
+	// Latin-1 text from an old writer, without a Coded Character Set
+	legacy := []IPTCDataSet{{2, 0, []byte{0, 4}}, {2, 5, []byte("Caf\xe9")}}
+
+	tests := []struct {
+		name        string
+		caption     string
+		wantMarker  bool
+		wantCaption []byte
+		wantTitle   []byte
+	}{
+		{"ascii", "Plain", false, []byte("Plain"), []byte("Caf\xe9")},
+		{"non-ascii", "Crème", true, []byte("Crème"), []byte("Café")},
+		{"not latin-1", "Ωmega", true, []byte("Ωmega"), []byte("Café")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iptc := &IPTC{DataSets: append([]IPTCDataSet{}, legacy...), fallback: charsetLatin1}
+			if err := iptc.SetCaption(tt.caption); err != nil {
+				t.Fatalf("SetCaption() error = %v", err)
+			}
+			if got := len(iptc.Values(1, 90)) > 0; got != tt.wantMarker {
+				t.Errorf("1:90 set = %v, want %v", got, tt.wantMarker)
+			}
+			if got := iptc.Values(2, 120)[0]; !bytes.Equal(got, tt.wantCaption) {
+				t.Errorf("caption stored % x, want % x", got, tt.wantCaption)
+			}
+			if got := iptc.Values(2, 5)[0]; !bytes.Equal(got, tt.wantTitle) {
+				t.Errorf("title stored % x, want % x", got, tt.wantTitle)
+			}
+			if iptc.ObjectName() != "Café" || iptc.Caption() != tt.caption {
+				t.Errorf("read back %q and %q", iptc.ObjectName(), iptc.Caption())
+			}
+		})
+	}
+}
+
+func TestIPTCEncodeDecode(t *testing.T) {
+	iptc := &IPTC{}
+	if err := iptc.SetKeywords([]string{"one", "two"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := iptc.SetCaption(strings.Repeat("c", 2000)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := encodeIPTC(iptc)
+	if err != nil {
+		t.Fatalf("encodeIPTC() error = %v", err)
+	}
+	if got := decodeIPTC(data); !reflect.DeepEqual(got.DataSets, iptc.DataSets) {
+		t.Errorf("decodeIPTC() = %v, want %v", dataSetKeys(got), dataSetKeys(iptc))
+	}
+	if got := decodeIPTC(nil); len(got.DataSets) != 0 {
+		t.Errorf("decodeIPTC(nil) = %v", dataSetKeys(got))
+	}
+	if got := decodeIPTC(data[:len(data)-10]); len(got.DataSets) > len(iptc.DataSets) {
+		t.Errorf("decodeIPTC() of truncated data = %v", dataSetKeys(got))
+	}
+}
@@ -0,0 +1,260 @@
+package EXIF
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+/******************************************************************************
+*
+* Type:         IPTCImageType
+*
+* Description:  The Image Type (2:130): the number of colour components of
+*               the ObjectData and what they are
+*
+******************************************************************************/
+
+type IPTCImageType struct {
+	Components byte // 0 for no ObjectData, 1 to 4 components, or 9 for supplemental objects
+	Colour     byte // The component letter, as in aImageTypeNames
+}
+
+// parseIPTCImageType decodes a 2:130 value
+func parseIPTCImageType(value []byte) (IPTCImageType, error) {
+	if len(value) != 2 {
+		return IPTCImageType{}, &jpegError{"Image Type must be 2 characters long"}
+	}
+	imageType := IPTCImageType{value[0] - '0', value[1]}
+	return imageType, imageType.validate()
+}
+
+func (t IPTCImageType) validate() error {
+	if t.Components > 4 && t.Components != 9 {
+		return &jpegError{"Image Type must have 0 to 4 or 9 components"}
+	}
+	if _, ok := aImageTypeNames[string(t.Colour)]; !ok {
+		return &jpegError{fmt.Sprintf("Image Type has an unknown component %q", t.Colour)}
+	}
+	return nil
+}
+
+// ComponentsName describes the number of components
+func (t IPTCImageType) ComponentsName() string {
+	switch t.Components {
+	case 0:
+		return "No ObjectData"
+	case 9:
+		return "Supplemental objects related to other ObjectData"
+	}
+	return fmt.Sprintf("%d component(s)", t.Components)
+}
+
+// ColourName returns the name of the component letter
+func (t IPTCImageType) ColourName() string {
+	return aImageTypeNames[string(t.Colour)]
+}
+
+func (t IPTCImageType) String() string {
+	return t.ComponentsName() + ", " + t.ColourName()
+}
+
+// ImageType returns the Image Type (2:130)
+func (m *IPTC) ImageType() (IPTCImageType, bool) {
+	values := m.Values(2, 130)
+	if len(values) == 0 {
+		return IPTCImageType{}, false
+	}
+	imageType, err := parseIPTCImageType(values[0])
+	return imageType, err == nil
+}
+
+// SetImageType sets the Image Type (2:130)
+func (m *IPTC) SetImageType(imageType IPTCImageType) error {
+	if err := imageType.validate(); err != nil {
+		return err
+	}
+	return m.Set(2, 130, []byte{'0' + imageType.Components, imageType.Colour})
+}
+
+// IPTCImageOrientation is the layout of the image area (2:131)
+type IPTCImageOrientation byte
+
+const (
+	IPTCPortrait  IPTCImageOrientation = 'P'
+	IPTCLandscape IPTCImageOrientation = 'L'
+	IPTCSquare    IPTCImageOrientation = 'S'
+)
+
+func (o IPTCImageOrientation) String() string {
+	switch o {
+	case IPTCPortrait:
+		return "Portrait"
+	case IPTCLandscape:
+		return "Landscape"
+	case IPTCSquare:
+		return "Square"
+	}
+	return "Unknown"
+}
+
+// ImageOrientation returns the Image Orientation (2:131)
+func (m *IPTC) ImageOrientation() (IPTCImageOrientation, bool) {
+	values := m.Values(2, 131)
+	if len(values) == 0 || len(values[0]) != 1 {
+		return 0, false
+	}
+	orientation := IPTCImageOrientation(values[0][0])
+	return orientation, orientation.String() != "Unknown"
+}
+
+// SetImageOrientation sets the Image Orientation (2:131)
+func (m *IPTC) SetImageOrientation(orientation IPTCImageOrientation) error {
+	if orientation.String() == "Unknown" {
+		return &jpegError{fmt.Sprintf("Image Orientation must be P, L or S, not %q", byte(orientation))}
+	}
+	return m.Set(2, 131, []byte{byte(orientation)})
+}
+
+/******************************************************************************
+*
+* Type:         IPTCSubjectReference
+*
+* Description:  A Subject Reference (2:12), made of five elements separated
+*               by colons: IPR:Subject Reference Number:Subject Name:
+*               Subject Matter Name:Subject Detail Name
+*
+******************************************************************************/
+
+type IPTCSubjectReference struct {
+	Provider   string // IPR - Information Provider Reference, IPTC for the NewsCodes vocabulary
+	Number     string // 8 digits: subject, subject matter and subject detail
+	Name       string // Subject Name
+	MatterName string // Subject Matter Name, can be empty
+	DetailName string // Subject Detail Name, can be empty
+}
+
+// parseIPTCSubjectReference splits a 2:12 value into its elements
+func parseIPTCSubjectReference(value string) (IPTCSubjectReference, error) {
+	elements := strings.Split(value, ":")
+	if len(elements) != 5 {
+		return IPTCSubjectReference{}, &jpegError{"Subject Reference must have five elements separated by colons"}
+	}
+	reference := IPTCSubjectReference{elements[0], elements[1], elements[2], elements[3], elements[4]}
+	return reference, reference.validate()
+}
+
+// newIPTCSubjectReference constructs a Subject Reference from the NewsCodes
+// vocabulary, filling in the names of the number
+func newIPTCSubjectReference(number string) (IPTCSubjectReference, error) {
+	reference := IPTCSubjectReference{Provider: "IPTC", Number: number}
+	if err := reference.validate(); err != nil {
+		return reference, err
+	}
+	reference.Name, reference.MatterName, reference.DetailName = reference.Lookup()
+	if reference.Name == "" {
+		return reference, &jpegError{fmt.Sprintf("Subject Reference Number %s is not an IPTC NewsCodes subject", number)}
+	}
+	return reference, nil
+}
+
+func (s IPTCSubjectReference) validate() error {
+	if len(s.Provider) < 1 || len(s.Provider) > 32 {
+		return &jpegError{"Subject Reference provider must be 1 to 32 characters long"}
+	}
+	if len(s.Number) != 8 || !isIPTCDigits([]byte(s.Number)) {
+		return &jpegError{"Subject Reference Number must be 8 digits"}
+	}
+	if len(s.Name) > 64 || len(s.MatterName) > 64 || len(s.DetailName) > 64 {
+		return &jpegError{"Subject Reference names must be at most 64 characters long"}
+	}
+	return nil
+}
+
+func (s IPTCSubjectReference) String() string {
+	return strings.Join([]string{s.Provider, s.Number, s.Name, s.MatterName, s.DetailName}, ":")
+}
+
+// Subject returns the number of the subject, e.g. 15000000
+func (s IPTCSubjectReference) Subject() string { return s.Number[:2] + "000000" }
+
+// Matter returns the number of the subject matter, e.g. 15008000
+func (s IPTCSubjectReference) Matter() string { return s.Number[:5] + "000" }
+
+// Lookup returns the vocabulary names of the subject, subject matter and
+// subject detail of an IPTC reference, empty for those that aren't listed
+func (s IPTCSubjectReference) Lookup() (string, string, string) {
+	if s.Provider != "IPTC" || len(s.Number) != 8 {
+		return "", "", ""
+	}
+	subject, _ := lookupIPTCNewsCode(s.Subject())
+	matter, detail := "", ""
+	if s.Matter() != s.Subject() {
+		matter, _ = lookupIPTCNewsCode(s.Matter())
+	}
+	if s.Number != s.Matter() {
+		detail, _ = lookupIPTCNewsCode(s.Number)
+	}
+	return subject, matter, detail
+}
+
+// SubjectReferences returns the Subject References (2:12) that could be decoded
+func (m *IPTC) SubjectReferences() []IPTCSubjectReference {
+	references := []IPTCSubjectReference{}
+	for _, text := range m.Texts(2, 12) {
+		if reference, err := parseIPTCSubjectReference(text); err == nil {
+			references = append(references, reference)
+		}
+	}
+	return references
+}
+
+// SetSubjectReferences replaces the Subject References (2:12)
+func (m *IPTC) SetSubjectReferences(references ...IPTCSubjectReference) error {
+	texts := []string{}
+	for _, reference := range references {
+		if err := reference.validate(); err != nil {
+			return err
+		}
+		texts = append(texts, reference.String())
+	}
+	return m.SetText(2, 12, texts...)
+}
+
+/******************************************************************************
+* Global Variable:      IPTC_NewsCodes
+*
+* Contents:     The IPTC NewsCodes Subject vocabulary, from IPTC_NewsCodes.txt
+*
+******************************************************************************/
+
+//go:embed IPTC_NewsCodes.txt
+var sIPTCNewsCodes string
+
+var aIPTCNewsCodes map[string]string
+var onceIPTCNewsCodes sync.Once
+
+/******************************************************************************
+* End of Global Variable:     IPTC_NewsCodes
+******************************************************************************/
+
+// lookupIPTCNewsCode returns the name of an 8 digit Subject Reference Number
+func lookupIPTCNewsCode(number string) (string, bool) {
+	onceIPTCNewsCodes.Do(func() {
+		aIPTCNewsCodes = map[string]string{}
+		scanner := bufio.NewScanner(strings.NewReader(sIPTCNewsCodes))
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" || line[0] == '#' {
+				continue
+			}
+			if number, name, ok := strings.Cut(line, "\t"); ok {
+				aIPTCNewsCodes[number] = name
+			}
+		}
+	})
+	name, ok := aIPTCNewsCodes[number]
+	return name, ok
+}
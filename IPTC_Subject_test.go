@@ -0,0 +1,217 @@
+package EXIF
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIPTCImageType(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"0T", "No ObjectData, Text Only", false},
+		{"1M", "1 component(s), Monochrome", false},
+		{"3P", "3 component(s), Full colour composite, pixel sequential", false},
+		{"4K", "4 component(s), Black Component", false},
+		{"9S", "Supplemental objects related to other ObjectData, Full colour composite, special interleaving", false},
+		{"5M", "", true},
+		{"3X", "", true},
+		{"3", "", true},
+		{"3PP", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			imageType, err := parseIPTCImageType([]byte(tt.value))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseIPTCImageType() error = %v", err)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := imageType.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+
+			iptc := &IPTC{}
+			if err := iptc.SetImageType(imageType); err != nil {
+				t.Fatalf("SetImageType() error = %v", err)
+			}
+			if got, ok := iptc.ImageType(); !ok || got != imageType || string(iptc.Values(2, 130)[0]) != tt.value {
+				t.Errorf("ImageType() = %v, %v", got, ok)
+			}
+		})
+	}
+
+	iptc := &IPTC{DataSets: []IPTCDataSet{{2, 130, []byte("7Z")}}}
+	if _, ok := iptc.ImageType(); ok {
+		t.Error("ImageType() of an invalid value succeeded")
+	}
+	if err := iptc.SetImageType(IPTCImageType{3, 'Z'}); err == nil {
+		t.Error("SetImageType() of an unknown component succeeded")
+	}
+}
+
+func TestIPTCImageOrientation(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   IPTCImageOrientation
+		name   string
+		wantOK bool
+	}{
+		{"P", IPTCPortrait, "Portrait", true},
+		{"L", IPTCLandscape, "Landscape", true},
+		{"S", IPTCSquare, "Square", true},
+		{"X", IPTCImageOrientation('X'), "Unknown", false},
+		{"PL", 0, "Unknown", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			iptc := &IPTC{DataSets: []IPTCDataSet{{2, 131, []byte(tt.value)}}}
+			got, ok := iptc.ImageOrientation()
+			if ok != tt.wantOK || got != tt.want || got.String() != tt.name {
+				t.Errorf("ImageOrientation() = %v (%d), %v", got, got, ok)
+			}
+			if err := (&IPTC{}).SetImageOrientation(tt.want); (err == nil) != tt.wantOK {
+				t.Errorf("SetImageOrientation() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestIPTCSubjectReference(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      string
+		wantLookup [3]string
+		wantErr    string
+	}{
+		{"subject", "IPTC:15000000:Sport::", [3]string{"sport", "", ""}, ""},
+		{"matter", "IPTC:15008000:Sport:Basketball:", [3]string{"sport", "basketball", ""}, ""},
+		{"detail", "IPTC:15008001:Sport:Basketball:NBA", [3]string{"sport", "basketball", "National Basketball Association (NBA)"}, ""},
+		{"unlisted matter", "IPTC:15999000:Sport:Other:", [3]string{"sport", "", ""}, ""},
+		{"unlisted detail", "IPTC:15008999:Sport:Basketball:Other", [3]string{"sport", "basketball", ""}, ""},
+		{"unlisted subject", "IPTC:99000000:Other::", [3]string{"", "", ""}, ""},
+		{"other provider", "ABC:15000000:Sport::", [3]string{"", "", ""}, ""},
+		{"four elements", "IPTC:15000000:Sport:", [3]string{}, "five elements"},
+		{"short number", "IPTC:1500000:Sport::", [3]string{}, "8 digits"},
+		{"letters in number", "IPTC:15OOOOOO:Sport::", [3]string{}, "8 digits"},
+		{"no provider", ":15000000:Sport::", [3]string{}, "provider"},
+		{"long name", "IPTC:15000000:" + strings.Repeat("n", 65) + "::", [3]string{}, "at most 64"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reference, err := parseIPTCSubjectReference(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseIPTCSubjectReference() error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseIPTCSubjectReference() error = %v", err)
+			}
+			if reference.String() != tt.value {
+				t.Errorf("String() = %q, want %q", reference.String(), tt.value)
+			}
+			subject, matter, detail := reference.Lookup()
+			if got := [3]string{subject, matter, detail}; got != tt.wantLookup {
+				t.Errorf("Lookup() = %q, want %q", got, tt.wantLookup)
+			}
+		})
+	}
+
+	reference := IPTCSubjectReference{Provider: "IPTC", Number: "15008001"}
+	if reference.Subject() != "15000000" || reference.Matter() != "15008000" {
+		t.Errorf("Subject(), Matter() = %s, %s", reference.Subject(), reference.Matter())
+	}
+}
+
+func TestNewIPTCSubjectReference(t *testing.T) {
+	tests := []struct {
+		number  string
+		want    string
+		wantErr bool
+	}{
+		{"17000000", "IPTC:17000000:weather::", false},
+		{"15008000", "IPTC:15008000:sport:basketball:", false},
+		{"04016005", "IPTC:04016005:economy, business and finance:company information:merger, acquisition and takeover", false},
+		{"99000000", "", true},
+		{"170", "", true},
+	}
+	for _, tt := range tests {
+		reference, err := newIPTCSubjectReference(tt.number)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newIPTCSubjectReference(%s) error = %v", tt.number, err)
+			continue
+		}
+		if !tt.wantErr && reference.String() != tt.want {
+			t.Errorf("newIPTCSubjectReference(%s) = %q, want %q", tt.number, reference.String(), tt.want)
+		}
+	}
+}
+
+func TestIPTCSubjectReferences(t *testing.T) {
+	iptc := &IPTC{}
+	references := []IPTCSubjectReference{
+		{"IPTC", "15000000", "sport", "", ""},
+		{"ABC", "12345678", "Local", "Matter", "Detail"},
+	}
+	if err := iptc.SetSubjectReferences(references...); err != nil {
+		t.Fatalf("SetSubjectReferences() error = %v", err)
+	}
+	iptc.DataSets = append(iptc.DataSets, IPTCDataSet{2, 12, []byte("not a reference")})
+	if got := iptc.SubjectReferences(); !reflect.DeepEqual(got, references) {
+		t.Errorf("SubjectReferences() = %v, want %v", got, references)
+	}
+	if err := iptc.SetSubjectReferences(IPTCSubjectReference{"IPTC", "123", "", "", ""}); err == nil {
+		t.Error("SetSubjectReferences() of an invalid reference succeeded")
+	}
+}
+
+func TestIPTCNewsCodesFile(t *testing.T) {
+	scanner := bufio.NewScanner(strings.NewReader(sIPTCNewsCodes))
+	subjects, matters, details := 0, 0, 0
+	previous := ""
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		number, name, ok := strings.Cut(text, "\t")
+		if !ok || len(number) != 8 || !isIPTCDigits([]byte(number)) || name == "" || len(name) > 64 {
+			t.Errorf("line %d is not an 8 digit number and a name: %q", line, text)
+			continue
+		}
+		if got, _ := lookupIPTCNewsCode(number); got != name {
+			t.Errorf("lookupIPTCNewsCode(%s) = %q, want %q", number, got, name)
+		}
+		if number <= previous {
+			t.Errorf("line %d: %s is not in ascending order", line, number)
+		}
+		previous = number
+
+		// Subject matters and details are listed with the ones they belong to
+		reference := IPTCSubjectReference{Provider: "IPTC", Number: number}
+		switch {
+		case number == reference.Subject():
+			subjects++
+		case number == reference.Matter():
+			matters++
+		default:
+			details++
+		}
+		if _, ok := lookupIPTCNewsCode(reference.Subject()); !ok {
+			t.Errorf("line %d: subject of %s is not listed", line, number)
+		}
+		if _, ok := lookupIPTCNewsCode(reference.Matter()); !ok {
+			t.Errorf("line %d: subject matter of %s is not listed", line, number)
+		}
+	}
+	if subjects != 17 || matters == 0 || details == 0 {
+		t.Errorf("found %d subjects, %d subject matters and %d details, want 17 subjects with their matters and details", subjects, matters, details)
+	}
+}
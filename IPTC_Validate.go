@@ -0,0 +1,178 @@
+package EXIF
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// IPTCRule identifies the rule an IPTC record breaks
+type IPTCRule int
+
+const (
+	IPTCRuleNone                 IPTCRule = iota
+	IPTCRuleLength                        // The value is too short or too long
+	IPTCRuleDigits                        // The value must only contain digits
+	IPTCRuleDate                          // The value must be a CCYYMMDD date
+	IPTCRuleTime                          // The value must be a HHMMSS±HHMM time
+	IPTCRuleNotRepeatable                 // The dataset appears more than once
+	IPTCRuleMissingRecordVersion          // The record has no 1:00 or 2:00 version dataset
+	IPTCRuleRecordOrder                   // The records are not in ascending order
+	IPTCRuleUNO                           // The value is not a valid Unique Name of Object
+)
+
+func (r IPTCRule) String() string {
+	switch r {
+	case IPTCRuleNone:
+		return "None"
+	case IPTCRuleLength:
+		return "Length"
+	case IPTCRuleDigits:
+		return "Digits"
+	case IPTCRuleDate:
+		return "Date"
+	case IPTCRuleTime:
+		return "Time"
+	case IPTCRuleNotRepeatable:
+		return "Not repeatable"
+	case IPTCRuleMissingRecordVersion:
+		return "Missing record version"
+	case IPTCRuleRecordOrder:
+		return "Record order"
+	case IPTCRuleUNO:
+		return "UNO"
+	}
+	return "Unknown"
+}
+
+/******************************************************************************
+*
+* Type:         IPTCViolation
+*
+* Description:  One broken rule found by validateIPTCRecords
+*
+******************************************************************************/
+
+type IPTCViolation struct {
+	Index   int // Index of the offending record, -1 if the rule concerns a whole IIM record
+	Record  byte
+	DataSet byte
+	Rule    IPTCRule
+	Message string
+}
+
+func (v IPTCViolation) String() string {
+	return fmt.Sprintf("%d:%02d %s", v.Record, v.DataSet, v.Message)
+}
+
+// IPTCValidationError is returned by putIPTCWithOptions in strict mode when
+// the records break the rules
+type IPTCValidationError struct {
+	Violations []IPTCViolation
+}
+
+func (e *IPTCValidationError) Error() string {
+	messages := []string{}
+	for _, v := range e.Violations {
+		messages = append(messages, v.String())
+	}
+	return "Invalid IPTC records: " + strings.Join(messages, "; ")
+}
+
+/******************************************************************************
+*
+* Function:     validateIPTCRecords
+*
+* Description:  Checks IPTC-NAA records against the dataset specifications
+*               in aIPTCDataSetSpecs: value lengths, digits, dates and times,
+*               datasets that must not repeat, the mandatory record version
+*               datasets and the order of the records. Datasets without a
+*               specification are not checked.
+*
+* Parameters:   iptcRecords - the records to check
+*
+* Returns:      violations - every broken rule, empty if the records are valid
+*
+******************************************************************************/
+
+func validateIPTCRecords(iptcRecords []iptcRecord) []IPTCViolation {
+	violations := []IPTCViolation{}
+	seen := map[uint16]bool{}
+	hasVersion := map[byte]bool{}
+	hasRecord := map[byte]int{}
+	lastRecordNumber := byte(0)
+
+	for i, record := range iptcRecords {
+		number, dataset := record.recRecordNumber, record.recDataSetNumber
+		key := uint16(number)*256 + uint16(dataset)
+
+		if number < lastRecordNumber {
+			violations = append(violations, IPTCViolation{i, number, dataset, IPTCRuleRecordOrder,
+				fmt.Sprintf("comes after record %d, records must be in ascending order", lastRecordNumber)})
+		}
+		lastRecordNumber = number
+
+		if _, ok := hasRecord[number]; !ok {
+			hasRecord[number] = i
+		}
+		if dataset == 0 {
+			hasVersion[number] = true
+		}
+
+		spec, known := getIPTCDataSetSpec(number, dataset)
+		if !known {
+			continue
+		}
+
+		if seen[key] && !spec.Repeatable {
+			violations = append(violations, IPTCViolation{i, number, dataset, IPTCRuleNotRepeatable,
+				fmt.Sprintf("%s appears more than once, but is not repeatable", spec.Name)})
+		}
+		seen[key] = true
+
+		if rule, problem := checkIPTCValue(spec, record.recData); rule != IPTCRuleNone {
+			violations = append(violations, IPTCViolation{i, number, dataset, rule, spec.Name + " " + problem})
+		} else if key == 1*256+100 {
+			if _, err := parseIPTCUNO(string(record.recData)); err != nil {
+				violations = append(violations, IPTCViolation{i, number, dataset, IPTCRuleUNO, err.Error()})
+			}
+		}
+	}
+
+	// The envelope and application records must start with their version
+	for _, number := range []byte{1, 2} {
+		if _, ok := hasRecord[number]; ok && !hasVersion[number] {
+			spec, _ := getIPTCDataSetSpec(number, 0)
+			violations = append(violations, IPTCViolation{-1, number, 0, IPTCRuleMissingRecordVersion,
+				fmt.Sprintf("%s is mandatory in record %d", spec.Name, number)})
+		}
+	}
+	return violations
+}
+
+// checkIPTCValue checks a value against the kind and length of its dataset
+// specification, returning the broken rule and what is wrong
+func checkIPTCValue(spec IPTCDataSetSpec, value []byte) (IPTCRule, string) {
+	if len(value) < spec.MinLength {
+		return IPTCRuleLength, fmt.Sprintf("must be at least %d bytes long", spec.MinLength)
+	}
+	if spec.MaxLength > 0 && len(value) > spec.MaxLength {
+		return IPTCRuleLength, fmt.Sprintf("must be at most %d bytes long", spec.MaxLength)
+	}
+
+	switch spec.Kind {
+	case IPTCDigits:
+		if !isIPTCDigits(value) {
+			return IPTCRuleDigits, "must only contain digits"
+		}
+	case IPTCDate:
+		if !isIPTCDate(value) {
+			return IPTCRuleDate, "must be a date formatted as CCYYMMDD"
+		}
+	case IPTCTime:
+		if _, err := time.Parse("150405-0700", string(value)); err != nil {
+			return IPTCRuleTime, "must be a time formatted as HHMMSS±HHMM"
+		}
+	}
+	return IPTCRuleNone, ""
+}
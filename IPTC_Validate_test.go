@@ -0,0 +1,95 @@
+package EXIF
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateIPTCRecords(t *testing.T) {
+	version := newTestIPTCRecord(2, 0, "\x00\x04")
+	uno := "20240229:example.com:object-1:1"
+	type violation struct {
+		index int
+		rule  IPTCRule
+	}
+	tests := []struct {
+		name    string
+		records []iptcRecord
+		want    []violation
+	}{
+		{"valid", []iptcRecord{version, newTestIPTCRecord(2, 25, "a"), newTestIPTCRecord(2, 25, "b")}, nil},
+		{"empty", []iptcRecord{}, nil},
+		{"longest caption", []iptcRecord{version, newTestIPTCRecord(2, 120, strings.Repeat("c", 2000))}, nil},
+		{"caption too long", []iptcRecord{version, newTestIPTCRecord(2, 120, strings.Repeat("c", 2001))}, []violation{{1, IPTCRuleLength}}},
+		{"too short", []iptcRecord{version, newTestIPTCRecord(2, 3, "ab")}, []violation{{1, IPTCRuleLength}}},
+		{"digits", []iptcRecord{version, newTestIPTCRecord(2, 10, "x")}, []violation{{1, IPTCRuleDigits}}},
+		{"date", []iptcRecord{version, newTestIPTCRecord(2, 55, "20241301")}, []violation{{1, IPTCRuleDate}}},
+		{"time", []iptcRecord{version, newTestIPTCRecord(2, 60, "256000+0000")}, []violation{{1, IPTCRuleTime}}},
+		{"not repeatable", []iptcRecord{version, newTestIPTCRecord(2, 5, "a"), newTestIPTCRecord(2, 5, "b")}, []violation{{2, IPTCRuleNotRepeatable}}},
+		{"missing record version", []iptcRecord{newTestIPTCRecord(2, 5, "a")}, []violation{{-1, IPTCRuleMissingRecordVersion}}},
+		{"missing envelope version", []iptcRecord{newTestIPTCRecord(1, 90, "\x1b%G"), version}, []violation{{-1, IPTCRuleMissingRecordVersion}}},
+		{"record order", []iptcRecord{version, newTestIPTCRecord(1, 0, "\x00\x04")}, []violation{{1, IPTCRuleRecordOrder}}},
+		{"valid UNO", []iptcRecord{newTestIPTCRecord(1, 0, "\x00\x04"), newTestIPTCRecord(1, 100, uno)}, nil},
+		{"invalid UNO", []iptcRecord{newTestIPTCRecord(1, 0, "\x00\x04"), newTestIPTCRecord(1, 100, "20240229:example.com:obj*:1")}, []violation{{1, IPTCRuleUNO}}},
+		{"unknown dataset", []iptcRecord{version, newTestIPTCRecord(2, 99, ""), newTestIPTCRecord(2, 99, "")}, nil},
+		{"several", []iptcRecord{newTestIPTCRecord(2, 10, "xx"), newTestIPTCRecord(2, 10, "1")}, []violation{{0, IPTCRuleLength}, {1, IPTCRuleNotRepeatable}, {-1, IPTCRuleMissingRecordVersion}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []violation{}
+			for _, v := range validateIPTCRecords(tt.records) {
+				got = append(got, violation{v.Index, v.Rule})
+				if v.Index >= 0 && (v.Record != tt.records[v.Index].recRecordNumber || v.DataSet != tt.records[v.Index].recDataSetNumber) {
+					t.Errorf("violation %v names the wrong dataset", v)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("validateIPTCRecords() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("violation %d = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPutIPTCStrict(t *testing.T) {
+	invalid := []iptcRecord{newTestIPTCRecord(2, 5, "a"), newTestIPTCRecord(2, 5, "b")}
+
+	if _, err := putIPTCWithOptions(invalid, iptcWriteOptions{}); err != nil {
+		t.Errorf("putIPTCWithOptions() without strict error = %v", err)
+	}
+
+	data, err := putIPTCWithOptions(invalid, iptcWriteOptions{strict: true})
+	var validationError *IPTCValidationError
+	if !errors.As(err, &validationError) || data != nil {
+		t.Fatalf("putIPTCWithOptions() strict = %d bytes, %v", len(data), err)
+	}
+	if len(validationError.Violations) != 2 {
+		t.Errorf("Violations = %v", validationError.Violations)
+	}
+	want := "Invalid IPTC records: 2:05 Object Name (Title) appears more than once, but is not repeatable; 2:00 Record Version is mandatory in record 2"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+
+	// The character set marker added on write is validated too
+	valid := []iptcRecord{newTestIPTCRecord(2, 0, "\x00\x04"), newTestIPTCRecord(2, 5, "Café")}
+	if _, err := putIPTCWithOptions(valid, iptcWriteOptions{strict: true}); err != nil {
+		t.Errorf("putIPTCWithOptions() strict error = %v", err)
+	}
+}
+
+func TestIPTCRuleString(t *testing.T) {
+	for rule := IPTCRuleNone; rule <= IPTCRuleUNO; rule++ {
+		if rule.String() == "Unknown" {
+			t.Errorf("IPTCRule(%d) has no name", rule)
+		}
+	}
+	if got := IPTCRule(-1).String(); got != "Unknown" {
+		t.Errorf("String() = %q, want Unknown", got)
+	}
+}
@@ -0,0 +1,629 @@
+package EXIF
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+/******************************************************************************
+*
+* Type:         xmpProperty
+*
+* Description:  The value of one XMP property, either a simple value or the
+*               items of an rdf:Bag, rdf:Seq or rdf:Alt array. For language
+*               alternatives only the x-default item is kept, first.
+*
+******************************************************************************/
+
+type xmpProperty struct {
+	array  string // "", "Bag", "Seq" or "Alt"
+	values []string
+}
+
+// xmpProperties holds XMP properties by their name with the usual prefix,
+// e.g. "dc:subject", whatever prefix the packet itself uses
+type xmpProperties map[string]xmpProperty
+
+// iptcXMPMapping is the XMP property an IIM dataset corresponds to
+type iptcXMPMapping struct {
+	dataset  uint16 // record * 256 + dataset
+	property string
+	array    string
+}
+
+// iptcXMPDateMapping is the XMP date property an IIM date and time
+// dataset pair of a record corresponds to
+type iptcXMPDateMapping struct {
+	record   byte
+	date     byte
+	time     byte
+	property string
+}
+
+/******************************************************************************
+*
+* Function:     iptcToXMPProperties
+*
+* Description:  Converts IPTC-NAA IIM datasets to the corresponding
+*               Iptc4xmpCore, dc, photoshop and xmp properties, following
+*               the IPTC Photo Metadata Standard. Date and time datasets are
+*               merged into one ISO 8601 date, and only the numbers of the
+*               Subject References (2:12) are kept.
+*
+* Parameters:   iptc - the IPTC datasets
+*
+* Returns:      properties - the XMP properties
+*
+******************************************************************************/
+
+func iptcToXMPProperties(iptc *IPTC) xmpProperties {
+	properties := xmpProperties{}
+	for _, mapping := range aIPTCXMPMappings {
+		values := iptc.Texts(byte(mapping.dataset>>8), byte(mapping.dataset))
+		if len(values) == 0 {
+			continue
+		}
+		if mapping.array == "" || mapping.array == "Alt" {
+			values = values[:1]
+		}
+		properties[mapping.property] = xmpProperty{mapping.array, values}
+	}
+
+	for _, mapping := range aIPTCXMPDateMappings {
+		dates := iptc.Values(mapping.record, mapping.date)
+		if len(dates) == 0 {
+			continue
+		}
+		var tm []byte
+		if times := iptc.Values(mapping.record, mapping.time); len(times) > 0 {
+			tm = times[0]
+		}
+		if date, ok := iimToXMPDate(dates[0], tm); ok {
+			properties[mapping.property] = xmpProperty{"", []string{date}}
+		}
+	}
+
+	if references := iptc.SubjectReferences(); len(references) > 0 {
+		codes := []string{}
+		for _, reference := range references {
+			codes = append(codes, reference.Number)
+		}
+		properties[xmpSubjectCodeProperty] = xmpProperty{"Bag", codes}
+	}
+	return properties
+}
+
+/******************************************************************************
+*
+* Function:     xmpPropertiesToIPTC
+*
+* Description:  Converts Iptc4xmpCore, dc, photoshop and xmp properties to
+*               the corresponding IPTC-NAA IIM datasets. Text longer than
+*               IIM allows is cut short at a character boundary, and only
+*               the first value is kept for datasets that don't repeat.
+*               Subject codes become IPTC Subject References (2:12) named
+*               from the NewsCodes vocabulary.
+*
+* Parameters:   properties - the XMP properties
+*
+* Returns:      iptc - the IPTC datasets
+*               error - listing the properties that could not be converted,
+*                       the other properties are converted regardless
+*
+******************************************************************************/
+
+func xmpPropertiesToIPTC(properties xmpProperties) (*IPTC, error) {
+	iptc := &IPTC{}
+	problems := []string{}
+
+	for _, mapping := range aIPTCXMPMappings {
+		property, ok := properties[mapping.property]
+		if !ok || len(property.values) == 0 {
+			continue
+		}
+		record, dataset := byte(mapping.dataset>>8), byte(mapping.dataset)
+		spec, _ := getIPTCDataSetSpec(record, dataset)
+
+		values := property.values
+		if !spec.Repeatable {
+			values = values[:1]
+		}
+		texts := []string{}
+		for _, value := range values {
+			texts = append(texts, truncateUTF8(value, spec.MaxLength))
+		}
+		if err := iptc.SetText(record, dataset, texts...); err != nil {
+			problems = append(problems, mapping.property)
+		}
+	}
+
+	for _, mapping := range aIPTCXMPDateMappings {
+		property, ok := properties[mapping.property]
+		if !ok || len(property.values) == 0 {
+			continue
+		}
+		date, tm, ok := xmpToIIMDate(property.values[0])
+		if !ok || iptc.Set(mapping.record, mapping.date, date) != nil {
+			problems = append(problems, mapping.property)
+			continue
+		}
+		if tm != nil {
+			iptc.Set(mapping.record, mapping.time, tm)
+		}
+	}
+
+	if property, ok := properties[xmpSubjectCodeProperty]; ok && len(property.values) > 0 {
+		references := []IPTCSubjectReference{}
+		valid := true
+		for _, code := range property.values {
+			reference := IPTCSubjectReference{Provider: "IPTC", Number: strings.TrimSpace(code)}
+			if reference.validate() != nil {
+				valid = false
+				continue
+			}
+			reference.Name, reference.MatterName, reference.DetailName = reference.Lookup()
+			references = append(references, reference)
+		}
+		if len(references) > 0 && iptc.SetSubjectReferences(references...) != nil {
+			valid = false
+		}
+		if !valid {
+			problems = append(problems, xmpSubjectCodeProperty)
+		}
+	}
+
+	if len(problems) > 0 {
+		return iptc, &jpegError{"XMP properties could not be converted to IPTC: " + strings.Join(problems, ", ")}
+	}
+	return iptc, nil
+}
+
+// iimToXMPDate merges an IIM date (CCYYMMDD) and optional time (HHMMSS±HHMM)
+// into an ISO 8601 date as used by XMP. Unknown months and days are 00 in
+// IIM and are left out of the XMP date.
+func iimToXMPDate(date []byte, tm []byte) (string, bool) {
+	if len(date) != 8 || !isIPTCDigits(date) {
+		return "", false
+	}
+	year, month, day := string(date[0:4]), string(date[4:6]), string(date[6:8])
+	switch {
+	case month == "00":
+		return year, true
+	case day == "00":
+		return year + "-" + month, true
+	}
+	xmpDate := year + "-" + month + "-" + day
+
+	switch {
+	case len(tm) == 11 && isIPTCDigits(tm[0:6]) && isIPTCDigits(tm[7:11]):
+		xmpDate += fmt.Sprintf("T%s:%s:%s%c%s:%s", tm[0:2], tm[2:4], tm[4:6], tm[6], tm[7:9], tm[9:11])
+	case len(tm) == 6 && isIPTCDigits(tm):
+		xmpDate += fmt.Sprintf("T%s:%s:%s", tm[0:2], tm[2:4], tm[4:6])
+	}
+	return xmpDate, true
+}
+
+var xmpDatePattern = regexp.MustCompile(`^(\d{4})(?:-(\d{2})(?:-(\d{2})(?:T(\d{2}):(\d{2})(?::(\d{2})(?:\.\d+)?)?(Z|[+-]\d{2}:\d{2})?)?)?)?$`)
+
+// xmpToIIMDate splits an ISO 8601 date as used by XMP into an IIM date and
+// time. The time is nil if the date has none, and taken to be UTC if it
+// has no time zone, as IIM requires one.
+func xmpToIIMDate(xmpDate string) ([]byte, []byte, bool) {
+	m := xmpDatePattern.FindStringSubmatch(strings.TrimSpace(xmpDate))
+	if m == nil {
+		return nil, nil, false
+	}
+	for _, i := range []int{2, 3, 6} {
+		if m[i] == "" {
+			m[i] = "00"
+		}
+	}
+	date := []byte(m[1] + m[2] + m[3])
+	if m[4] == "" {
+		return date, nil, true
+	}
+
+	zone := "+0000"
+	if len(m[7]) == 6 {
+		zone = m[7][0:3] + m[7][4:6]
+	}
+	return date, []byte(m[4] + m[5] + m[6] + zone), true
+}
+
+// truncateUTF8 cuts text short to at most maxLength bytes, without
+// splitting a character. A maxLength of 0 means there is no limit.
+func truncateUTF8(text string, maxLength int) string {
+	if maxLength <= 0 || len(text) <= maxLength {
+		return text
+	}
+	text = text[:maxLength]
+	for len(text) > 0 && !utf8.ValidString(text) {
+		text = text[:len(text)-1]
+	}
+	return text
+}
+
+/******************************************************************************
+*
+* Function:     decodeXMPProperties
+*
+* Description:  Decodes the simple and array properties of an XMP packet.
+*               Properties are named with the usual prefix of their
+*               namespace, see aXMPNamespaces, and properties in other
+*               namespaces or with structured values are left out.
+*
+* Parameters:   packet - the XMP packet, without the APP1 identifier
+*
+* Returns:      properties - the XMP properties
+*               error - if the packet is not valid XML
+*
+******************************************************************************/
+
+func decodeXMPProperties(packet []byte) (xmpProperties, error) {
+	var root xmpNode
+	if err := xml.Unmarshal(packet, &root); err != nil {
+		return nil, &jpegError{"XMP packet is not valid XML: " + err.Error()}
+	}
+
+	properties := xmpProperties{}
+	root.walk(func(description *xmpNode) {
+		// Simple properties can be attributes of rdf:Description
+		for _, attr := range description.Attrs {
+			if name, ok := xmpPropertyName(attr.Name); ok {
+				properties[name] = xmpProperty{"", []string{attr.Value}}
+			}
+		}
+
+		for _, child := range description.Children {
+			name, ok := xmpPropertyName(child.XMLName)
+			if !ok {
+				continue
+			}
+			if len(child.Children) == 0 {
+				properties[name] = xmpProperty{"", []string{child.Text}}
+				continue
+			}
+
+			array := child.Children[0]
+			if array.XMLName.Space != xmpNamespaceRDF {
+				continue
+			}
+			property := xmpProperty{array: array.XMLName.Local}
+			for _, item := range array.Children {
+				if item.XMLName.Space != xmpNamespaceRDF || item.XMLName.Local != "li" || len(item.Children) > 0 {
+					continue
+				}
+				if property.array == "Alt" && item.lang() == "x-default" {
+					property.values = append([]string{item.Text}, property.values...)
+				} else {
+					property.values = append(property.values, item.Text)
+				}
+			}
+			if property.array == "Alt" && len(property.values) > 1 {
+				property.values = property.values[:1]
+			}
+			properties[name] = property
+		}
+	})
+	return properties, nil
+}
+
+// xmpNode is any element of an XMP packet
+type xmpNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Text     string     `xml:",chardata"`
+	Children []xmpNode  `xml:",any"`
+}
+
+// walk calls visit for every rdf:Description element under the node
+func (n *xmpNode) walk(visit func(description *xmpNode)) {
+	if n.XMLName.Space == xmpNamespaceRDF && n.XMLName.Local == "Description" {
+		visit(n)
+		return
+	}
+	for i := range n.Children {
+		n.Children[i].walk(visit)
+	}
+}
+
+// lang returns the xml:lang attribute of the node
+func (n *xmpNode) lang() string {
+	for _, attr := range n.Attrs {
+		if attr.Name.Local == "lang" && (attr.Name.Space == "xml" || attr.Name.Space == "http://www.w3.org/XML/1998/namespace") {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// xmpPropertyName returns the name of a property with its usual prefix
+func xmpPropertyName(name xml.Name) (string, bool) {
+	for _, ns := range aXMPNamespaces {
+		if ns.uri == name.Space {
+			return ns.prefix + ":" + name.Local, true
+		}
+	}
+	return "", false
+}
+
+/******************************************************************************
+*
+* Function:     putXMPProperties
+*
+* Description:  Stores XMP properties in an XMP packet. The properties
+*               listed in remove are taken out of the packet first, then the
+*               new properties are added in an rdf:Description of their own,
+*               the rest of the packet is kept as it is. Properties to remove
+*               are recognised by their usual namespace prefixes.
+*
+* Parameters:   packet - the XMP packet, without the APP1 identifier, nil
+*                        to create a new packet
+*               properties - the properties to store
+*               remove - the names of the properties to take out
+*
+* Returns:      packet - the new XMP packet
+*               error - if the packet has no rdf:RDF element
+*
+******************************************************************************/
+
+func putXMPProperties(packet []byte, properties xmpProperties, remove []string) ([]byte, error) {
+	if len(bytes.TrimSpace(packet)) == 0 {
+		packet = []byte("<?xpacket begin=\"\xEF\xBB\xBF\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n" +
+			"<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n" +
+			" <rdf:RDF xmlns:rdf=\"" + xmpNamespaceRDF + "\">\n" +
+			" </rdf:RDF>\n" +
+			"</x:xmpmeta>\n" +
+			"<?xpacket end=\"w\"?>")
+	} else {
+		packet = append([]byte{}, packet...)
+	}
+
+	for _, name := range remove {
+		packet = removeXMPProperty(packet, regexp.QuoteMeta(name))
+	}
+
+	end := bytes.LastIndex(packet, []byte("</rdf:RDF>"))
+	if end < 0 {
+		return nil, &jpegError{"XMP packet has no rdf:RDF element"}
+	}
+	if len(properties) == 0 {
+		return packet, nil
+	}
+
+	description := encodeXMPDescription(properties)
+	return append(append(append([]byte{}, packet[:end]...), description...), packet[end:]...), nil
+}
+
+// encodeXMPDescription encodes properties as an rdf:Description element,
+// declaring the namespaces it uses
+func encodeXMPDescription(properties xmpProperties) []byte {
+	var description bytes.Buffer
+	description.WriteString("  <rdf:Description rdf:about=\"\"")
+	for _, ns := range aXMPNamespaces {
+		for name := range properties {
+			if strings.HasPrefix(name, ns.prefix+":") {
+				fmt.Fprintf(&description, "\n    xmlns:%s=\"%s\"", ns.prefix, ns.uri)
+				break
+			}
+		}
+	}
+	description.WriteString(">\n")
+
+	// Keep the output stable by following the order of the mapping tables
+	names := iptcXMPPropertyNames()
+	for name := range properties {
+		if !containsString(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range names {
+		property, ok := properties[name]
+		if !ok {
+			continue
+		}
+		if property.array == "" {
+			fmt.Fprintf(&description, "   <%s>%s</%s>\n", name, escapeXMLText(property.values[0]), name)
+			continue
+		}
+		fmt.Fprintf(&description, "   <%s>\n    <rdf:%s>\n", name, property.array)
+		for _, value := range property.values {
+			if property.array == "Alt" {
+				fmt.Fprintf(&description, "     <rdf:li xml:lang=\"x-default\">%s</rdf:li>\n", escapeXMLText(value))
+			} else {
+				fmt.Fprintf(&description, "     <rdf:li>%s</rdf:li>\n", escapeXMLText(value))
+			}
+		}
+		fmt.Fprintf(&description, "    </rdf:%s>\n   </%s>\n", property.array, name)
+	}
+	description.WriteString("  </rdf:Description>\n ")
+	return description.Bytes()
+}
+
+func escapeXMLText(text string) string {
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(text))
+	return escaped.String()
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+/******************************************************************************
+*
+* Function:     getJPEGXMP
+*
+* Description:  Retrieves the XMP packet from the APP1 XMP segment of the
+*               JPEG header data
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*
+* Returns:      packet - the XMP packet, nil if there is none
+*
+******************************************************************************/
+
+func getJPEGXMP(jpegHeader []segment) []byte {
+	for _, seg := range jpegHeader {
+		if seg.segType == 0xE1 && bytes.HasPrefix(seg.segData, xmpIdent) {
+			return seg.segData[len(xmpIdent):]
+		}
+	}
+	return nil
+}
+
+/******************************************************************************
+*
+* Function:     putJPEGXMP
+*
+* Description:  Stores an XMP packet in the APP1 XMP segment of the JPEG
+*               header data, replacing the existing one or adding one after
+*               the APP0 and APP1 segments
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*               packet - the XMP packet
+*
+* Returns:      jpegHeader - the JPEG header data with the XMP segment
+*               error - if the packet is too large for a segment
+*
+******************************************************************************/
+
+func putJPEGXMP(jpegHeader []segment, packet []byte) ([]segment, error) {
+	segData := append(append([]byte{}, xmpIdent...), packet...)
+	if len(segData) > jpegSegmentMaxSize {
+		return jpegHeader, &jpegError{"XMP packet is too large to fit in JPEG segment"}
+	}
+	xmpSegment := segment{
+		segType: 0xE1,
+		segName: aJPEGSegmentNames[0xE1],
+		segDesc: aJPEGSegmentDescriptions[0xE1],
+		segData: segData,
+	}
+
+	insertAt := 0
+	for i, seg := range jpegHeader {
+		if seg.segType == 0xE1 && bytes.HasPrefix(seg.segData, xmpIdent) {
+			return spliceJPEGSegments(jpegHeader, i, 1, []segment{xmpSegment}), nil
+		}
+		if seg.segType == 0xE0 || seg.segType == 0xE1 {
+			insertAt = i + 1
+		}
+	}
+	return spliceJPEGSegments(jpegHeader, insertAt, 0, []segment{xmpSegment}), nil
+}
+
+/******************************************************************************
+*
+* Function:     putJPEGIPTCWithXMP
+*
+* Description:  Stores IPTC datasets both as IIM in the Photoshop IRB and as
+*               the corresponding properties in the XMP packet, so tools that
+*               only read one of them see the same values. The XMP
+*               properties that correspond to IIM datasets are replaced, the
+*               rest of the XMP packet is kept.
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*               iptc - the IPTC datasets
+*
+* Returns:      jpegHeader - the JPEG header data with the IPTC data
+*               error - if the IPTC data could not be stored
+*
+******************************************************************************/
+
+func putJPEGIPTCWithXMP(jpegHeader []segment, iptc *IPTC) ([]segment, error) {
+	newHeader, err := putJPEGIPTC(jpegHeader, iptc.records())
+	if err != nil {
+		return jpegHeader, err
+	}
+
+	packet, err := putXMPProperties(getJPEGXMP(newHeader), iptcToXMPProperties(iptc), iptcXMPPropertyNames())
+	if err != nil {
+		return jpegHeader, err
+	}
+	return putJPEGXMP(newHeader, packet)
+}
+
+// iptcXMPPropertyNames returns the names of all the XMP properties that
+// correspond to IIM datasets
+func iptcXMPPropertyNames() []string {
+	names := []string{}
+	for _, mapping := range aIPTCXMPMappings {
+		names = append(names, mapping.property)
+	}
+	for _, mapping := range aIPTCXMPDateMappings {
+		names = append(names, mapping.property)
+	}
+	return append(names, xmpSubjectCodeProperty)
+}
+
+const xmpNamespaceRDF = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+
+// aXMPNamespaces are the XMP namespaces used by the IIM mapping, with their
+// usual prefixes
+var aXMPNamespaces = []struct {
+	prefix string
+	uri    string
+}{
+	{"dc", "http://purl.org/dc/elements/1.1/"},
+	{"photoshop", "http://ns.adobe.com/photoshop/1.0/"},
+	{"Iptc4xmpCore", "http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/"},
+	{"xmp", "http://ns.adobe.com/xap/1.0/"},
+}
+
+/******************************************************************************
+* Global Variable:      IPTC_XMP_Mappings
+*
+* Contents:     The XMP properties corresponding to IPTC-NAA IIM datasets,
+*               as given by the IPTC Photo Metadata Standard
+*
+******************************************************************************/
+
+var aIPTCXMPMappings = []iptcXMPMapping{
+	{2*256 + 5, "dc:title", "Alt"},
+	{2*256 + 10, "photoshop:Urgency", ""},
+	{2*256 + 15, "photoshop:Category", ""},
+	{2*256 + 20, "photoshop:SupplementalCategories", "Bag"},
+	{2*256 + 25, "dc:subject", "Bag"},
+	{2*256 + 40, "photoshop:Instructions", ""},
+	{2*256 + 80, "dc:creator", "Seq"},
+	{2*256 + 85, "photoshop:AuthorsPosition", ""},
+	{2*256 + 90, "photoshop:City", ""},
+	{2*256 + 92, "Iptc4xmpCore:Location", ""},
+	{2*256 + 95, "photoshop:State", ""},
+	{2*256 + 100, "Iptc4xmpCore:CountryCode", ""},
+	{2*256 + 101, "photoshop:Country", ""},
+	{2*256 + 103, "photoshop:TransmissionReference", ""},
+	{2*256 + 105, "photoshop:Headline", ""},
+	{2*256 + 110, "photoshop:Credit", ""},
+	{2*256 + 115, "photoshop:Source", ""},
+	{2*256 + 116, "dc:rights", "Alt"},
+	{2*256 + 120, "dc:description", "Alt"},
+	{2*256 + 122, "photoshop:CaptionWriter", ""},
+	{2*256 + 135, "dc:language", "Bag"},
+}
+
+var aIPTCXMPDateMappings = []iptcXMPDateMapping{
+	{2, 55, 60, "photoshop:DateCreated"},
+	{2, 62, 63, "xmp:CreateDate"},
+}
+
+// xmpSubjectCodeProperty holds the 8 digit numbers of the Subject
+// References (2:12), as a bag
+const xmpSubjectCodeProperty = "Iptc4xmpCore:SubjectCode"
+
+/******************************************************************************
+* End of Global Variable:     IPTC_XMP_Mappings
+******************************************************************************/
@@ -0,0 +1,191 @@
+package EXIF
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestXMPPacket returns an XMP packet holding the properties
+func newTestXMPPacket(t *testing.T, properties xmpProperties) []byte {
+	t.Helper()
+	packet, err := putXMPProperties(nil, properties, nil)
+	if err != nil {
+		t.Fatalf("putXMPProperties() error = %v", err)
+	}
+	return packet
+}
+
+func TestIPTCXMPRoundTrip(t *testing.T) {
+	iptc := &IPTC{}
+	steps := []error{
+		iptc.SetObjectName("Title"),
+		iptc.SetKeywords([]string{"sea", "sky & sun"}),
+		iptc.SetByLines([]string{"Jo", "Sam"}),
+		iptc.SetCaption("A <caption>"),
+		iptc.SetCity("Zürich"),
+		iptc.SetDateCreated(time.Date(2024, 2, 29, 13, 30, 15, 0, time.FixedZone("", 3600))),
+		iptc.Set(2, 62, []byte("20240200")),
+		iptc.SetSubjectReferences(IPTCSubjectReference{"IPTC", "15008000", "sport", "basketball", ""}, IPTCSubjectReference{"IPTC", "04000000", "economy, business and finance", "", ""}),
+	}
+	for i, err := range steps {
+		if err != nil {
+			t.Fatalf("step %d error = %v", i, err)
+		}
+	}
+
+	properties := iptcToXMPProperties(iptc)
+	want := xmpProperties{
+		"dc:title":                 {"Alt", []string{"Title"}},
+		"dc:subject":               {"Bag", []string{"sea", "sky & sun"}},
+		"dc:creator":               {"Seq", []string{"Jo", "Sam"}},
+		"dc:description":           {"Alt", []string{"A <caption>"}},
+		"photoshop:City":           {"", []string{"Zürich"}},
+		"photoshop:DateCreated":    {"", []string{"2024-02-29T13:30:15+01:00"}},
+		"xmp:CreateDate":           {"", []string{"2024-02"}},
+		"Iptc4xmpCore:SubjectCode": {"Bag", []string{"15008000", "04000000"}},
+	}
+	if !reflect.DeepEqual(properties, want) {
+		t.Errorf("iptcToXMPProperties() = %v, want %v", properties, want)
+	}
+
+	decoded, err := decodeXMPProperties(newTestXMPPacket(t, properties))
+	if err != nil {
+		t.Fatalf("decodeXMPProperties() error = %v", err)
+	}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("decodeXMPProperties() = %v, want %v", decoded, want)
+	}
+
+	back, err := xmpPropertiesToIPTC(decoded)
+	if err != nil {
+		t.Fatalf("xmpPropertiesToIPTC() error = %v", err)
+	}
+	if got := iptcToXMPProperties(back); !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip = %v, want %v", got, want)
+	}
+	if got := back.Texts(2, 12); !reflect.DeepEqual(got, iptc.Texts(2, 12)) {
+		t.Errorf("Subject References = %q, want %q", got, iptc.Texts(2, 12))
+	}
+}
+
+func TestXMPPropertiesToIPTC(t *testing.T) {
+	tests := []struct {
+		name         string
+		properties   xmpProperties
+		record       byte
+		dataset      byte
+		want         []string
+		wantProblems string
+	}{
+		{"single value kept", xmpProperties{"photoshop:Headline": {"", []string{"one"}}}, 2, 105, []string{"one"}, ""},
+		{"not repeatable", xmpProperties{"dc:title": {"Alt", []string{"first", "second"}}}, 2, 5, []string{"first"}, ""},
+		{"cut at 64 bytes", xmpProperties{"dc:title": {"Alt", []string{strings.Repeat("é", 40)}}}, 2, 5, []string{strings.Repeat("é", 32)}, ""},
+		{"cut inside a character", xmpProperties{"dc:title": {"Alt", []string{"x" + strings.Repeat("é", 40)}}}, 2, 5, []string{"x" + strings.Repeat("é", 31)}, ""},
+		{"date only", xmpProperties{"photoshop:DateCreated": {"", []string{"2024-02-29"}}}, 2, 55, []string{"20240229"}, ""},
+		{"date without zone", xmpProperties{"photoshop:DateCreated": {"", []string{"2024-02-29T13:30"}}}, 2, 60, []string{"133000+0000"}, ""},
+		{"date UTC", xmpProperties{"photoshop:DateCreated": {"", []string{"2024-02-29T13:30:15.25Z"}}}, 2, 60, []string{"133015+0000"}, ""},
+		{"year only", xmpProperties{"photoshop:DateCreated": {"", []string{"2024"}}}, 2, 55, []string{"20240000"}, ""},
+		{"invalid date", xmpProperties{"photoshop:DateCreated": {"", []string{"yesterday"}}}, 2, 55, []string{}, "photoshop:DateCreated"},
+		{"invalid urgency", xmpProperties{"photoshop:Urgency": {"", []string{"high"}}}, 2, 10, []string{}, "photoshop:Urgency"},
+		{"subject code", xmpProperties{"Iptc4xmpCore:SubjectCode": {"Bag", []string{" 15000000 "}}}, 2, 12, []string{"IPTC:15000000:sport::"}, ""},
+		{"subject code detail", xmpProperties{"Iptc4xmpCore:SubjectCode": {"Bag", []string{"15073001"}}}, 2, 12, []string{"IPTC:15073001:sport:sports event:Summer Olympics"}, ""},
+		{"subject code unlisted", xmpProperties{"Iptc4xmpCore:SubjectCode": {"Bag", []string{"99000000"}}}, 2, 12, []string{"IPTC:99000000:::"}, ""},
+		{"subject code invalid", xmpProperties{"Iptc4xmpCore:SubjectCode": {"Bag", []string{"1500", "17000000"}}}, 2, 12, []string{"IPTC:17000000:weather::"}, "Iptc4xmpCore:SubjectCode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iptc, err := xmpPropertiesToIPTC(tt.properties)
+			if tt.wantProblems == "" && err != nil {
+				t.Errorf("xmpPropertiesToIPTC() error = %v", err)
+			}
+			if tt.wantProblems != "" && (err == nil || !strings.HasSuffix(err.Error(), tt.wantProblems)) {
+				t.Errorf("xmpPropertiesToIPTC() error = %v, want one listing %s", err, tt.wantProblems)
+			}
+			if got := iptc.Texts(tt.record, tt.dataset); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%d:%02d = %q, want %q", tt.record, tt.dataset, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPutXMPProperties(t *testing.T) {
+	packet := newTestXMPPacket(t, xmpProperties{
+		"dc:title":   {"Alt", []string{"Old"}},
+		"dc:subject": {"Bag", []string{"old"}},
+		"dc:format":  {"", []string{"image/jpeg"}},
+	})
+	packet, err := putXMPProperties(packet, xmpProperties{"dc:title": {"Alt", []string{"New"}}}, iptcXMPPropertyNames())
+	if err != nil {
+		t.Fatalf("putXMPProperties() error = %v", err)
+	}
+	got, err := decodeXMPProperties(packet)
+	if err != nil {
+		t.Fatalf("decodeXMPProperties() error = %v", err)
+	}
+	want := xmpProperties{
+		"dc:title":  {"Alt", []string{"New"}},
+		"dc:format": {"", []string{"image/jpeg"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("properties = %v, want %v", got, want)
+	}
+
+	if _, err := putXMPProperties([]byte("<x:xmpmeta/>"), want, nil); err == nil {
+		t.Error("putXMPProperties() without rdf:RDF succeeded")
+	}
+	if _, err := decodeXMPProperties([]byte("<x:xmpmeta")); err == nil {
+		t.Error("decodeXMPProperties() of invalid XML succeeded")
+	}
+}
+
+func TestDecodeXMPPropertiesLanguages(t *testing.T) {
+	packet := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="` + xmpNamespaceRDF + `">
+<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/" photoshop:City="Bern">
+<dc:title><rdf:Alt><rdf:li xml:lang="de">Titel</rdf:li><rdf:li xml:lang="x-default">Title</rdf:li></rdf:Alt></dc:title>
+<dc:unknown xmlns:dc="urn:other">ignored</dc:unknown>
+</rdf:Description></rdf:RDF></x:xmpmeta>`
+	got, err := decodeXMPProperties([]byte(packet))
+	if err != nil {
+		t.Fatalf("decodeXMPProperties() error = %v", err)
+	}
+	want := xmpProperties{
+		"photoshop:City": {"", []string{"Bern"}},
+		"dc:title":       {"Alt", []string{"Title"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decodeXMPProperties() = %v, want %v", got, want)
+	}
+}
+
+func TestPutJPEGIPTCWithXMP(t *testing.T) {
+	iptc := &IPTC{}
+	iptc.SetHeadline("Headline")
+	iptc.SetSubjectReferences(IPTCSubjectReference{"IPTC", "17000000", "weather", "", ""})
+
+	header := []segment{newTestSegment(0xE0, []byte("JFIF\x00")), newTestSegment(0xDB, nil)}
+	header, err := putJPEGIPTCWithXMP(header, iptc)
+	if err != nil {
+		t.Fatalf("putJPEGIPTCWithXMP() error = %v", err)
+	}
+	properties, err := decodeXMPProperties(getJPEGXMP(header))
+	if err != nil {
+		t.Fatalf("decodeXMPProperties() error = %v", err)
+	}
+	if got := properties[xmpSubjectCodeProperty].values; !reflect.DeepEqual(got, []string{"17000000"}) {
+		t.Errorf("SubjectCode = %q", got)
+	}
+
+	// Clearing the subject removes the subject codes from XMP too
+	iptc.SetSubjectReferences()
+	header, _ = putJPEGIPTCWithXMP(header, iptc)
+	properties, _ = decodeXMPProperties(getJPEGXMP(header))
+	if _, ok := properties[xmpSubjectCodeProperty]; ok || properties["photoshop:Headline"].values[0] != "Headline" {
+		t.Errorf("properties after clearing the subject = %v", properties)
+	}
+
+	if _, err := putJPEGXMP(header, make([]byte, jpegSegmentMaxSize)); err == nil {
+		t.Error("putJPEGXMP() of an oversized packet succeeded")
+	}
+}
@@ -0,0 +1,84 @@
+package EXIF
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// newTestIPTCRecord returns a record as putIPTC takes it
+func newTestIPTCRecord(record byte, dataset byte, value string) iptcRecord {
+	return iptcRecord{
+		recType:          fmt.Sprintf("%01d:%02d", record, dataset),
+		recRecordNumber:  record,
+		recDataSetNumber: dataset,
+		recData:          []byte(value),
+	}
+}
+
+func TestPutIPTCExtendedDataSet(t *testing.T) {
+	tests := []struct {
+		name       string
+		size       int
+		wantHeader []byte
+	}{
+		{"empty", 0, []byte{0x1C, 2, 202, 0x00, 0x00}},
+		{"largest standard", 0x7FFF, []byte{0x1C, 2, 202, 0x7F, 0xFF}},
+		{"smallest extended", 0x8000, []byte{0x1C, 2, 202, 0x80, 0x04, 0x00, 0x00, 0x80, 0x00}},
+		{"segment sized", 65533, []byte{0x1C, 2, 202, 0x80, 0x04, 0x00, 0x00, 0xFF, 0xFD}},
+		{"over 64K", 70000, []byte{0x1C, 2, 202, 0x80, 0x04, 0x00, 0x01, 0x11, 0x70}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value := strings.Repeat("\xAB", tt.size)
+			data, ok := putIPTC([]iptcRecord{newTestIPTCRecord(2, 202, value)})
+			if !ok {
+				t.Fatal("putIPTC() failed")
+			}
+			if !bytes.HasPrefix(data, tt.wantHeader) || len(data) != len(tt.wantHeader)+tt.size {
+				t.Fatalf("putIPTC() header = % x, length %d", data[:len(tt.wantHeader)], len(data))
+			}
+
+			records := getIPTC(bytes.NewReader(data))
+			if len(records) != 1 || records[0].recType != "2:202" || string(records[0].recData) != value {
+				t.Fatalf("getIPTC() returned %d records", len(records))
+			}
+		})
+	}
+}
+
+func TestGetIPTCExtendedDataSet(t *testing.T) {
+	next := []byte{0x1C, 2, 5, 0x00, 0x02, 'o', 'k'}
+	tests := []struct {
+		name      string
+		data      []byte
+		wantTypes []string
+	}{
+		{"length of length 1", []byte{0x1C, 2, 202, 0x80, 0x01, 0x03, 'a', 'b', 'c'}, []string{"2:202", "2:05"}},
+		{"length of length 2", []byte{0x1C, 2, 202, 0x80, 0x02, 0x00, 0x01, 'a'}, []string{"2:202", "2:05"}},
+		{"length of length 3", []byte{0x1C, 2, 202, 0x80, 0x03, 0x00, 0x00, 0x00}, []string{"2:202", "2:05"}},
+		{"length of length 0", []byte{0x1C, 2, 202, 0x80, 0x00}, []string{}},
+		{"length of length 5", []byte{0x1C, 2, 202, 0x80, 0x05, 0, 0, 0, 0, 1, 'a'}, []string{}},
+		{"truncated length", []byte{0x1C, 2, 202, 0x80, 0x04, 0x00, 0x00}, []string{}},
+		{"claims 4GB", []byte{0x1C, 2, 202, 0x80, 0x04, 0xFF, 0xFF, 0xFF, 0xFF, 'a'}, []string{}},
+		{"truncated header", []byte{0x1C, 2, 202, 0x80}, []string{}},
+		{"not a tag marker", []byte{0x1D, 2, 5, 0x00, 0x00}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := append(append([]byte{}, tt.data...), next...)
+			if len(tt.wantTypes) == 0 {
+				// Corrupt records end the data, whatever follows them
+				data = tt.data
+			}
+			types := []string{}
+			for _, record := range getIPTC(bytes.NewReader(data)) {
+				types = append(types, record.recType)
+			}
+			if strings.Join(types, " ") != strings.Join(tt.wantTypes, " ") {
+				t.Errorf("getIPTC() = %v, want %v", types, tt.wantTypes)
+			}
+		})
+	}
+}
@@ -0,0 +1,429 @@
+package EXIF
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+/******************************************************************************
+*
+* Type:         JFIFHeader
+*
+* Description:  The decoded contents of a JFIF APP0 segment, which must be
+*               the first segment after the SOI marker
+*
+******************************************************************************/
+
+type JFIFHeader struct {
+	MajorVersion byte
+	MinorVersion byte
+	Units        byte   // Density units, see DensityAspectRatio, DensityDPI and DensityDPCM
+	XDensity     uint16 // Horizontal pixel density
+	YDensity     uint16 // Vertical pixel density
+	ThumbWidth   byte
+	ThumbHeight  byte
+	Thumbnail    []byte // Uncompressed 24 bit RGB thumbnail, ThumbWidth * ThumbHeight * 3 bytes
+}
+
+// Density units of a JFIF header
+const (
+	DensityAspectRatio = 0 // No units, the densities only give the pixel aspect ratio
+	DensityDPI         = 1 // Dots per inch
+	DensityDPCM        = 2 // Dots per centimetre
+)
+
+/******************************************************************************
+*
+* Type:         JFXXExtension
+*
+* Description:  The decoded contents of a JFXX (JFIF extension) APP0 segment,
+*               which holds a thumbnail in one of three formats
+*
+******************************************************************************/
+
+type JFXXExtension struct {
+	Code        byte   // Extension code, see JFXXThumbnailJPEG, JFXXThumbnailPalette and JFXXThumbnailRGB
+	ThumbWidth  byte   // Not used for JPEG thumbnails
+	ThumbHeight byte   // Not used for JPEG thumbnails
+	Palette     []byte // 256 RGB palette entries, 768 bytes, for palette thumbnails
+	Thumbnail   []byte // JPEG data, palette indices or 24 bit RGB data
+}
+
+// Extension codes of a JFXX segment
+const (
+	JFXXThumbnailJPEG    = 0x10 // Thumbnail coded using JPEG
+	JFXXThumbnailPalette = 0x11 // Thumbnail stored using 1 byte per pixel and a palette
+	JFXXThumbnailRGB     = 0x13 // Thumbnail stored using 3 bytes per pixel
+)
+
+var jfifIdent = []byte("JFIF\x00")
+var jfxxIdent = []byte("JFXX\x00")
+
+/******************************************************************************
+*
+* Function:     getJFIFHeader
+*
+* Description:  Finds the JFIF APP0 segment in the JPEG header data and
+*               decodes it
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*
+* Returns:      jfifHeader - the decoded JFIF header
+*               error - if no JFIF segment was found or it could not be decoded
+*
+******************************************************************************/
+
+func getJFIFHeader(jpegHeader []segment) (*JFIFHeader, error) {
+	for _, seg := range jpegHeader {
+		if seg.segType == 0xE0 && bytes.HasPrefix(seg.segData, jfifIdent) {
+			return decodeJFIFHeader(seg.segData)
+		}
+	}
+	return nil, &jpegError{"Couldn't find JFIF segment"}
+}
+
+/******************************************************************************
+*
+* Function:     decodeJFIFHeader
+*
+* Description:  Decodes the data of a JFIF APP0 segment
+*
+* Parameters:   data - the data of the APP0 segment, starting with "JFIF\0"
+*
+* Returns:      jfifHeader - the decoded JFIF header
+*               error - if the data is not a valid JFIF header
+*
+******************************************************************************/
+
+func decodeJFIFHeader(data []byte) (*JFIFHeader, error) {
+	// JFIF layout after the identifier:
+	// Version (2), Units (1), Xdensity (2), Ydensity (2),
+	// Xthumbnail (1), Ythumbnail (1), thumbnail RGB data (3 * Xthumbnail * Ythumbnail)
+	if !bytes.HasPrefix(data, jfifIdent) {
+		return nil, &jpegError{"Segment is not a JFIF segment"}
+	}
+	data = data[len(jfifIdent):]
+	if len(data) < 9 {
+		return nil, &jpegError{"JFIF segment is too short"}
+	}
+
+	header := &JFIFHeader{
+		MajorVersion: data[0],
+		MinorVersion: data[1],
+		Units:        data[2],
+		XDensity:     binary.BigEndian.Uint16(data[3:5]),
+		YDensity:     binary.BigEndian.Uint16(data[5:7]),
+		ThumbWidth:   data[7],
+		ThumbHeight:  data[8],
+	}
+
+	thumbSize := 3 * int(header.ThumbWidth) * int(header.ThumbHeight)
+	if len(data) < 9+thumbSize {
+		return nil, &jpegError{"JFIF segment is too short for its thumbnail"}
+	}
+	if thumbSize > 0 {
+		header.Thumbnail = data[9 : 9+thumbSize]
+	}
+
+	return header, nil
+}
+
+/******************************************************************************
+*
+* Function:     encodeJFIFHeader
+*
+* Description:  Encodes a JFIF header into the data of an APP0 segment
+*
+* Parameters:   header - the JFIF header to encode
+*
+* Returns:      data - the APP0 segment data
+*               error - if the thumbnail does not match its dimensions
+*
+******************************************************************************/
+
+func encodeJFIFHeader(header *JFIFHeader) ([]byte, error) {
+	if len(header.Thumbnail) != 3*int(header.ThumbWidth)*int(header.ThumbHeight) {
+		return nil, &jpegError{"JFIF thumbnail size does not match its dimensions"}
+	}
+
+	var data bytes.Buffer
+	data.Write(jfifIdent)
+	data.Write([]byte{header.MajorVersion, header.MinorVersion, header.Units})
+	binary.Write(&data, binary.BigEndian, header.XDensity)
+	binary.Write(&data, binary.BigEndian, header.YDensity)
+	data.Write([]byte{header.ThumbWidth, header.ThumbHeight})
+	data.Write(header.Thumbnail)
+	return data.Bytes(), nil
+}
+
+/******************************************************************************
+*
+* Function:     putJFIFHeader
+*
+* Description:  Stores a JFIF header in the JPEG header data, replacing the
+*               existing JFIF segment or adding one at the start, where the
+*               JFIF standard requires it to be
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*               header - the JFIF header to store
+*
+* Returns:      jpegHeader - the JPEG header data with the JFIF segment
+*               error - if the header could not be encoded
+*
+******************************************************************************/
+
+func putJFIFHeader(jpegHeader []segment, header *JFIFHeader) ([]segment, error) {
+	data, err := encodeJFIFHeader(header)
+	if err != nil {
+		return jpegHeader, err
+	}
+	if len(data) > jpegSegmentMaxSize {
+		return jpegHeader, &jpegError{"JFIF thumbnail is too large to fit in JPEG segment"}
+	}
+
+	jfif := segment{
+		segType: 0xE0,
+		segName: aJPEGSegmentNames[0xE0],
+		segDesc: aJPEGSegmentDescriptions[0xE0],
+		segData: data,
+	}
+
+	for i, seg := range jpegHeader {
+		if seg.segType == 0xE0 && bytes.HasPrefix(seg.segData, jfifIdent) {
+			return spliceJPEGSegments(jpegHeader, i, 1, []segment{jfif}), nil
+		}
+	}
+	return spliceJPEGSegments(jpegHeader, 0, 0, []segment{jfif}), nil
+}
+
+/******************************************************************************
+*
+* Function:     newJFIFHeader
+*
+* Description:  Creates a JFIF 1.02 header without thumbnail
+*
+* Parameters:   units - the density units, e.g. DensityDPI
+*               xDensity, yDensity - the horizontal and vertical density
+*
+* Returns:      jfifHeader - the new JFIF header
+*
+******************************************************************************/
+
+func newJFIFHeader(units byte, xDensity uint16, yDensity uint16) *JFIFHeader {
+	return &JFIFHeader{MajorVersion: 1, MinorVersion: 2, Units: units, XDensity: xDensity, YDensity: yDensity}
+}
+
+/******************************************************************************
+*
+* Function:     stripJFIFHeader
+*
+* Description:  Removes the JFIF and JFXX APP0 segments from the JPEG header
+*               data
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*
+* Returns:      jpegHeader - the JPEG header data without JFIF segments
+*
+******************************************************************************/
+
+func stripJFIFHeader(jpegHeader []segment) []segment {
+	newHeader := []segment{}
+	for _, seg := range jpegHeader {
+		if seg.segType == 0xE0 && (bytes.HasPrefix(seg.segData, jfifIdent) || bytes.HasPrefix(seg.segData, jfxxIdent)) {
+			continue
+		}
+		newHeader = append(newHeader, seg)
+	}
+	return newHeader
+}
+
+/******************************************************************************
+*
+* Function:     getJFXXExtensions
+*
+* Description:  Finds and decodes all the JFXX APP0 segments in the JPEG
+*               header data
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*
+* Returns:      extensions - the decoded JFXX extensions, empty if there are none
+*               error - if a JFXX segment could not be decoded
+*
+******************************************************************************/
+
+func getJFXXExtensions(jpegHeader []segment) ([]JFXXExtension, error) {
+	extensions := []JFXXExtension{}
+	for _, seg := range jpegHeader {
+		if seg.segType == 0xE0 && bytes.HasPrefix(seg.segData, jfxxIdent) {
+			extension, err := decodeJFXXExtension(seg.segData)
+			if err != nil {
+				return extensions, err
+			}
+			extensions = append(extensions, *extension)
+		}
+	}
+	return extensions, nil
+}
+
+/******************************************************************************
+*
+* Function:     decodeJFXXExtension
+*
+* Description:  Decodes the data of a JFXX APP0 segment
+*
+* Parameters:   data - the data of the APP0 segment, starting with "JFXX\0"
+*
+* Returns:      extension - the decoded JFXX extension
+*               error - if the data is not a valid JFXX extension
+*
+******************************************************************************/
+
+func decodeJFXXExtension(data []byte) (*JFXXExtension, error) {
+	if !bytes.HasPrefix(data, jfxxIdent) {
+		return nil, &jpegError{"Segment is not a JFXX segment"}
+	}
+	data = data[len(jfxxIdent):]
+	if len(data) < 1 {
+		return nil, &jpegError{"JFXX segment is too short"}
+	}
+
+	extension := &JFXXExtension{Code: data[0]}
+	data = data[1:]
+
+	switch extension.Code {
+	case JFXXThumbnailJPEG:
+		// The rest of the segment is a complete JPEG image
+		extension.Thumbnail = data
+
+	case JFXXThumbnailPalette, JFXXThumbnailRGB:
+		if len(data) < 2 {
+			return nil, &jpegError{"JFXX segment is too short"}
+		}
+		extension.ThumbWidth = data[0]
+		extension.ThumbHeight = data[1]
+		data = data[2:]
+
+		numPixels := int(extension.ThumbWidth) * int(extension.ThumbHeight)
+		if extension.Code == JFXXThumbnailPalette {
+			if len(data) < 768+numPixels {
+				return nil, &jpegError{"JFXX segment is too short for its thumbnail"}
+			}
+			extension.Palette = data[:768]
+			extension.Thumbnail = data[768 : 768+numPixels]
+		} else {
+			if len(data) < 3*numPixels {
+				return nil, &jpegError{"JFXX segment is too short for its thumbnail"}
+			}
+			extension.Thumbnail = data[:3*numPixels]
+		}
+
+	default:
+		return nil, &jpegError{"Unknown JFXX extension code"}
+	}
+
+	return extension, nil
+}
+
+/******************************************************************************
+*
+* Function:     encodeJFXXExtension
+*
+* Description:  Encodes a JFXX extension into the data of an APP0 segment
+*
+* Parameters:   extension - the JFXX extension to encode
+*
+* Returns:      data - the APP0 segment data
+*               error - if the extension code is unknown
+*
+******************************************************************************/
+
+func encodeJFXXExtension(extension *JFXXExtension) ([]byte, error) {
+	var data bytes.Buffer
+	data.Write(jfxxIdent)
+	data.WriteByte(extension.Code)
+
+	switch extension.Code {
+	case JFXXThumbnailJPEG:
+		data.Write(extension.Thumbnail)
+	case JFXXThumbnailPalette:
+		data.Write([]byte{extension.ThumbWidth, extension.ThumbHeight})
+		data.Write(extension.Palette)
+		data.Write(extension.Thumbnail)
+	case JFXXThumbnailRGB:
+		data.Write([]byte{extension.ThumbWidth, extension.ThumbHeight})
+		data.Write(extension.Thumbnail)
+	default:
+		return nil, &jpegError{"Unknown JFXX extension code"}
+	}
+	return data.Bytes(), nil
+}
+
+/******************************************************************************
+*
+* Function:     putJFXXExtension
+*
+* Description:  Stores a JFXX extension in the JPEG header data, replacing the
+*               existing JFXX segment or adding one right after the JFIF
+*               segment
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*               extension - the JFXX extension to store
+*
+* Returns:      jpegHeader - the JPEG header data with the JFXX segment
+*               error - if the extension could not be encoded
+*
+******************************************************************************/
+
+func putJFXXExtension(jpegHeader []segment, extension *JFXXExtension) ([]segment, error) {
+	data, err := encodeJFXXExtension(extension)
+	if err != nil {
+		return jpegHeader, err
+	}
+	if len(data) > jpegSegmentMaxSize {
+		return jpegHeader, &jpegError{"JFXX thumbnail is too large to fit in JPEG segment"}
+	}
+
+	jfxx := segment{
+		segType: 0xE0,
+		segName: aJPEGSegmentNames[0xE0],
+		segDesc: aJPEGSegmentDescriptions[0xE0],
+		segData: data,
+	}
+
+	insertAt := 0
+	for i, seg := range jpegHeader {
+		if seg.segType == 0xE0 && bytes.HasPrefix(seg.segData, jfxxIdent) {
+			return spliceJPEGSegments(jpegHeader, i, 1, []segment{jfxx}), nil
+		}
+		if seg.segType == 0xE0 && bytes.HasPrefix(seg.segData, jfifIdent) {
+			insertAt = i + 1
+		}
+	}
+	return spliceJPEGSegments(jpegHeader, insertAt, 0, []segment{jfxx}), nil
+}
+
+/******************************************************************************
+*
+* Function:     DPI
+*
+* Description:  Gets the pixel density of a JFIF header in dots per inch
+*
+* Returns:      x, y - the horizontal and vertical density in dots per inch
+*               ok - false if the header only gives an aspect ratio
+*
+******************************************************************************/
+
+func (h *JFIFHeader) DPI() (x float64, y float64, ok bool) {
+	switch h.Units {
+	case DensityDPI:
+		return float64(h.XDensity), float64(h.YDensity), true
+	case DensityDPCM:
+		return float64(h.XDensity) * 2.54, float64(h.YDensity) * 2.54, true
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,134 @@
+package EXIF
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestJFIFHeaderRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  *JFIFHeader
+		wantErr bool
+	}{
+		{"no thumbnail", newJFIFHeader(DensityDPI, 300, 300), false},
+		{"aspect ratio", newJFIFHeader(DensityAspectRatio, 1, 2), false},
+		{"small thumbnail", &JFIFHeader{1, 1, DensityDPCM, 118, 118, 2, 1, []byte{1, 2, 3, 4, 5, 6}}, false},
+		{"largest thumbnail that fits", &JFIFHeader{1, 2, DensityDPI, 72, 72, 255, 85, make([]byte, 3*255*85)}, false},
+		{"thumbnail too large", &JFIFHeader{1, 2, DensityDPI, 72, 72, 255, 86, make([]byte, 3*255*86)}, true},
+		{"thumbnail size mismatch", &JFIFHeader{1, 2, DensityDPI, 72, 72, 2, 2, make([]byte, 5)}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jpegHeader := []segment{newTestSegment(0xE1, []byte("Exif\x00\x00")), newTestSegment(0xDA, testSOS)}
+			newHeader, err := putJFIFHeader(jpegHeader, test.header)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("error = %v", err)
+			}
+			if test.wantErr {
+				return
+			}
+			if !bytes.Equal(segmentTypes(newHeader), []byte{0xE0, 0xE1, 0xDA}) {
+				t.Errorf("segment types % X, want the JFIF segment first", segmentTypes(newHeader))
+			}
+			header, err := getJFIFHeader(newHeader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(header, test.header) {
+				t.Errorf("header = %+v, want %+v", header, test.header)
+			}
+
+			// Putting it again replaces it
+			if newHeader, err = putJFIFHeader(newHeader, newJFIFHeader(DensityDPI, 96, 96)); err != nil {
+				t.Fatal(err)
+			}
+			if header, _ := getJFIFHeader(newHeader); len(newHeader) != 3 || header.XDensity != 96 {
+				t.Errorf("JFIF header was not replaced")
+			}
+		})
+	}
+}
+
+func TestDecodeJFIFHeaderCorrupt(t *testing.T) {
+	valid, err := encodeJFIFHeader(&JFIFHeader{1, 2, DensityDPI, 72, 72, 1, 1, []byte{1, 2, 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{0, 4, 13, len(valid) - 1} {
+		if _, err := decodeJFIFHeader(valid[:size]); err == nil {
+			t.Errorf("no error for JFIF data truncated to %d bytes", size)
+		}
+	}
+	if _, err := getJFIFHeader([]segment{newTestSegment(0xDA, testSOS)}); err == nil {
+		t.Error("no error without a JFIF segment")
+	}
+}
+
+func TestJFXXExtensionRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		extension *JFXXExtension
+	}{
+		{"JPEG", &JFXXExtension{Code: JFXXThumbnailJPEG, Thumbnail: []byte{0xFF, 0xD8, 0xFF, 0xD9}}},
+		{"palette", &JFXXExtension{Code: JFXXThumbnailPalette, ThumbWidth: 2, ThumbHeight: 1, Palette: make([]byte, 768), Thumbnail: []byte{0, 1}}},
+		{"RGB", &JFXXExtension{Code: JFXXThumbnailRGB, ThumbWidth: 1, ThumbHeight: 1, Thumbnail: []byte{1, 2, 3}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jpegHeader, err := putJFIFHeader([]segment{newTestSegment(0xDA, testSOS)}, newJFIFHeader(DensityDPI, 72, 72))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if jpegHeader, err = putJFXXExtension(jpegHeader, test.extension); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(jpegHeader[1].segData, jfxxIdent) {
+				t.Errorf("the JFXX segment does not follow the JFIF segment")
+			}
+			extensions, err := getJFXXExtensions(jpegHeader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(extensions) != 1 || !reflect.DeepEqual(&extensions[0], test.extension) {
+				t.Errorf("extensions = %+v, want %+v", extensions, test.extension)
+			}
+
+			encoded, _ := encodeJFXXExtension(test.extension)
+			if test.extension.Code != JFXXThumbnailJPEG {
+				if _, err := decodeJFXXExtension(encoded[:len(encoded)-1]); err == nil {
+					t.Error("no error for a truncated thumbnail")
+				}
+			}
+
+			stripped := stripJFIFHeader(jpegHeader)
+			if !bytes.Equal(segmentTypes(stripped), []byte{0xDA}) {
+				t.Errorf("segment types after stripping % X", segmentTypes(stripped))
+			}
+		})
+	}
+
+	if _, err := decodeJFXXExtension(append(append([]byte{}, jfxxIdent...), 0x12)); err == nil {
+		t.Error("no error for an unknown extension code")
+	}
+}
+
+func TestJFIFHeaderDPI(t *testing.T) {
+	tests := []struct {
+		header *JFIFHeader
+		x, y   float64
+		ok     bool
+	}{
+		{newJFIFHeader(DensityDPI, 300, 150), 300, 150, true},
+		{newJFIFHeader(DensityDPCM, 100, 100), 254, 254, true},
+		{newJFIFHeader(DensityAspectRatio, 1, 1), 0, 0, false},
+	}
+	for _, test := range tests {
+		if x, y, ok := test.header.DPI(); x != test.x || y != test.y || ok != test.ok {
+			t.Errorf("units %d: DPI %v, %v, %v", test.header.Units, x, y, ok)
+		}
+	}
+}
@@ -2,9 +2,13 @@ package EXIF
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
-	"io/ioutil"
+	"io"
+	"io/fs"
+	"math"
 	"os"
+	"path/filepath"
 )
 
 type segment struct {
@@ -13,16 +17,29 @@ type segment struct {
 	segDesc      string
 	segDataStart uint64
 	segData      []byte
+
+	// For SOS segments read with readJPEGStructureAt, the byte range of the
+	// entropy-coded data that follows the scan header
+	scanDataStart uint64
+	scanDataEnd   uint64
 }
 
+// jpegSegmentMaxSize is the largest amount of data a single segment holds,
+// the segment size field also counts its own two bytes
+const jpegSegmentMaxSize = 0xFFFD
+
 // jpegError is a trivial implementation of error
 type jpegError struct {
 	descr string
 }
 
+func (e *jpegError) Error() string {
+	return e.descr
+}
+
 /******************************************************************************
 *
-* Function:     get_jpegHeader
+* Function:     getJPEGHeaderData
 *
 * Description:  Reads all the JPEG header segments from an JPEG image file into an
 *               array
@@ -30,123 +47,461 @@ type jpegError struct {
 * Parameters:   filename - the filename of the file to JPEG file to read
 *
 * Returns:      headerdata - Array of JPEG header segments
-*               FALSE - if headers could not be read
+*               error - if headers could not be read
 *
 ******************************************************************************/
 
 func getJPEGHeaderData(filename string) ([]segment, error) {
-
-	segments := make([]segment)
-
-	// Attempt to open the jpeg file - the at symbol supresses the error message about
-	// not being able to open files. The file_exists would have been used, but it
-	// does not work with files fetched over http or ftp.
+	// Attempt to open the jpeg file
 	fi, err := os.Open(filename)
 
 	// Check if the file opened successfully
-	if err != nill {
+	if err != nil {
 		// Could't open the file - exit
-		return segments, &jpegError{"Could not open file"}
+		return []segment{}, &jpegError{"Could not open file"}
 	}
+	defer fi.Close()
 
-	reader := bufio.NewReader(fi)
+	return readJPEGHeaderData(fi)
+}
 
-	// Read the first two characters
-	data = make([]byte, 2)
-	reader.Read(data)
+/******************************************************************************
+*
+* Function:     getJPEGHeaderDataFS
+*
+* Description:  Reads all the JPEG header segments from a JPEG image file that
+*               lives inside a file system such as an embed.FS, a zip archive
+*               or an os.DirFS
+*
+* Parameters:   fsys - the file system holding the JPEG file
+*               filename - the path of the JPEG file within fsys
+*
+* Returns:      headerdata - Array of JPEG header segments
+*               error - if headers could not be read
+*
+******************************************************************************/
 
-	// Check that the first two characters are 0xFF 0xDA  (SOI - Start of image)
-	if data[0] != 0xFF || data[1] != 0xD8 {
-		// No SOI (FF D8) at start of file - This probably isn't a JPEG file - close file and return;
-		fi.Close()
-		return segments, &jpegError{"This probably is not a JPEG file"}
+func getJPEGHeaderDataFS(fsys fs.FS, filename string) ([]segment, error) {
+	fi, err := fsys.Open(filename)
+	if err != nil {
+		return []segment{}, &jpegError{"Could not open file"}
 	}
+	defer fi.Close()
 
-	// Read the third character
-	reader.Read(data)
+	return readJPEGHeaderData(fi)
+}
 
-	// Check that the third character is 0xFF (Start of first segment header)
-	if data[0] != 0xFF {
-		// NO FF found - close file and return - JPEG is probably corrupted
-		fi.Close()
-		return segments, &jpegError{"No FF found, JPEG is probably corrupted"}
+/******************************************************************************
+*
+* Function:     readJPEGHeaderDataAt
+*
+* Description:  Reads all the JPEG header segments from a random access byte
+*               source, e.g. a bytes.Reader over an in-memory image or a
+*               blob in an object store
+*
+* Parameters:   reader - the byte source, the JPEG must start at offset 0
+*
+* Returns:      headerdata - Array of JPEG header segments
+*               error - if headers could not be read
+*
+******************************************************************************/
+
+func readJPEGHeaderDataAt(reader io.ReaderAt) ([]segment, error) {
+	segments := []segment{}
+
+	it := newSegmentIterator(reader, 0)
+	for it.Next() {
+		seg := it.Segment()
+		segData, err := it.Payload()
+		if err != nil {
+			return segments, err
+		}
+		seg.segData = segData
+		segments = append(segments, seg)
 	}
+	return segments, it.Err()
+}
 
-	// Flag that we havent yet hit the compressed image data
-	foundCompressedImageData := false
+/******************************************************************************
+*
+* Function:     readJPEGStructureAt
+*
+* Description:  Reads all the segments of a JPEG, not just the header
+*               segments in front of the first scan. Every SOS segment of a
+*               progressive or multi-scan JPEG is returned together with the
+*               byte range of its entropy-coded data, followed by any DHT,
+*               DQT or DRI segments between the scans, up to the EOI marker.
+*
+* Parameters:   reader - the byte source, the JPEG must start at offset 0
+*
+* Returns:      segments - Array of all the JPEG segments
+*               error - if the structure could not be read
+*
+******************************************************************************/
+
+func readJPEGStructureAt(reader io.ReaderAt) ([]segment, error) {
+	segments := []segment{}
+
+	it := newSegmentIterator(reader, 0)
+	it.followScans = true
+	for it.Next() {
+		seg := it.Segment()
+		segData, err := it.Payload()
+		if err != nil {
+			return segments, err
+		}
+		seg.segData = segData
+		segments = append(segments, seg)
+	}
+	return segments, it.Err()
+}
+
+/******************************************************************************
+*
+* Function:     readJPEGHeaderData
+*
+* Description:  Reads all the JPEG header segments from a stream. The stream
+*               must be positioned at the SOI marker, segDataStart offsets
+*               are relative to that position.
+*
+* Parameters:   reader - the stream to read the JPEG from
+*
+* Returns:      headerdata - Array of JPEG header segments
+*               error - if headers could not be read
+*
+******************************************************************************/
+
+func readJPEGHeaderData(reader io.Reader) ([]segment, error) {
+	in := &offsetReader{reader: bufio.NewReader(reader)}
+	segments, _, err := readJPEGSegments(in)
+	return segments, err
+}
+
+// offsetReader keeps track of the absolute position of a stream so that
+// segments can record where their data starts
+type offsetReader struct {
+	reader io.Reader
+	offset uint64
+}
+
+func (r *offsetReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.offset += uint64(n)
+	return n, err
+}
+
+// readJPEGSegments reads the SOI marker and all following segments up to
+// and including the first SOS segment or EOI marker. It returns true when
+// the SOS segment was found, meaning that the compressed image data follows.
+func readJPEGSegments(in *offsetReader) ([]segment, bool, error) {
+	segments := []segment{}
+
+	// Read the first two characters
+	data := make([]byte, 2)
+
+	// Check that the first two characters are 0xFF 0xD8  (SOI - Start of image)
+	if _, err := io.ReadFull(in, data); err != nil || data[0] != 0xFF || data[1] != 0xD8 {
+		// No SOI (FF D8) at start of file - This probably isn't a JPEG file
+		return segments, false, &jpegError{"This probably is not a JPEG file"}
+	}
+
+	// Read the marker of the first segment
+	if err := readJPEGMarker(in, data); err != nil {
+		return segments, false, err
+	}
 
 	// Cycle through the file until, one of: 1) an EOI (End of image) marker is hit,
 	//                                       2) we have hit the compressed image data (no more headers are allowed after data)
 	//                                       3) or end of file is hit
-
-	// while ( ( $data{1} != "\xD9" ) && (! $foundCompressedImageData) && ( ! feof( $filehnd ) ))
-	for data[1] != 0xD9 && !foundCompressedImageData {
+	for data[1] != 0xD9 {
 		// Found a segment to look at.
 		// Check that the segment marker is not a Restart marker - restart markers don't have size or data after them
-		if data[1] < 0xD0 || data[1] > 0xD7 {
-			// Segment isn't a Restart marker
-			// Read the next two bytes (size)
-			// sizestr = network_safe_fread( $filehnd, 2 );
-
-			// convert the size bytes to an integer
-			// $decodedsize = unpack ("nsize", $sizestr);
+		if (data[1] < 0xD0 || data[1] > 0xD7) && data[1] != 0x01 {
+			// Read the size, which is big endian and includes the two size bytes
 			var decodedSize uint16
-			binary.Read(reader, binary.LittleEndian, &decodedSize)
+			if err := binary.Read(in, binary.BigEndian, &decodedSize); err != nil || decodedSize < 2 {
+				return segments, false, &jpegError{"Segment size could not be read, JPEG is probably truncated"}
+			}
 
 			// Save the start position of the data
-			segdatastart, err := file.Seek(os.SEEK_CUR, 0)
+			segDataStart := in.offset
 
 			// Read the segment data with length indicated by the previously read size
-			segdata = make([]byte, decodedSize)
-			reader.Read(segdata)
+			segData := make([]byte, decodedSize-2)
+			if _, err := io.ReadFull(in, segData); err != nil {
+				return segments, false, &jpegError{"Segment data could not be read, JPEG is probably truncated"}
+			}
 
 			// Store the segment information in the output array
-			//$headerdata[] = array(  "SegType" : ord($data{1}),
-			//                        "SegName" : $GLOBALS[ "JPEG_Segment_Names" ][ ord($data{1}) ],
-			//                        "SegDesc" : $GLOBALS[ "JPEG_Segment_Descriptions" ][ ord($data{1}) ],
-			//                        "SegDataStart" : $segdatastart,
-			//                        "SegData" : $segdata );
-			headerdata := &segment{}
-			headerdata.segType = data[1]
-			headerdata.segName = aJPEGSegmentNames[data[1]]
-			headerdata.segDesc = aJPEGSegmentDescriptions[data[1]]
-			headerdata.segDataStart = segdatastart
-			headerdata.segData = segdata
-
-			segments = append(segments, headerdata)
+			segments = append(segments, segment{
+				segType:      data[1],
+				segName:      aJPEGSegmentNames[data[1]],
+				segDesc:      aJPEGSegmentDescriptions[data[1]],
+				segDataStart: segDataStart,
+				segData:      segData,
+			})
 		}
 
 		// If this is a SOS (Start Of Scan) segment, then there is no more header data - the compressed image data follows
 		if data[1] == 0xDA {
-			// Flag that we have hit the compressed image data - exit loop as no more headers available.
-			foundCompressedImageData = true
-		} else {
-			// Not an SOS - Read the next two bytes - should be the segment marker for the next segment
-			reader.Read(data)
-
-			// Check that the first byte of the two is 0xFF as it should be for a marker
-			if data[0] != 0xFF {
-				// NO FF found - close file and return - JPEG is probably corrupted
-				fi.Close()
-				return segments, &jpegError{"No FF found, JPEG is probably corrupted"}
+			return segments, true, nil
+		}
+
+		// Not an SOS - Read the next two bytes - should be the segment marker for the next segment
+		if err := readJPEGMarker(in, data); err != nil {
+			return segments, false, err
+		}
+	}
+
+	return segments, false, nil
+}
+
+// readJPEGMarker reads the next segment marker into data, skipping any 0xFF
+// fill bytes that may precede the marker code
+func readJPEGMarker(in io.Reader, data []byte) error {
+	if _, err := io.ReadFull(in, data); err != nil {
+		return &jpegError{"Unexpected end of file, JPEG is probably truncated"}
+	}
+
+	// Check that the first byte of the two is 0xFF as it should be for a marker
+	if data[0] != 0xFF {
+		// NO FF found - JPEG is probably corrupted
+		return &jpegError{"No FF found, JPEG is probably corrupted"}
+	}
+
+	for data[1] == 0xFF {
+		if _, err := io.ReadFull(in, data[1:]); err != nil {
+			return &jpegError{"Unexpected end of file, JPEG is probably truncated"}
+		}
+	}
+	return nil
+}
+
+/******************************************************************************
+*
+* Function:     newSegmentIterator
+*
+* Description:  Creates an iterator over the JPEG header segments of a random
+*               access byte source. Only the markers and segment sizes are
+*               read while iterating, a segment payload is read only when
+*               Payload is called. Iteration stops after the SOS segment or
+*               at the EOI marker.
+*
+* Parameters:   reader - the byte source, the JPEG must start at offset 0
+*               maxHeaderSize - the number of bytes at the start of the file
+*                               that the iterator may touch while looking
+*                               for markers, 0 means no limit
+*
+* Returns:      iterator - the segment iterator, call Next to advance it
+*
+******************************************************************************/
+
+func newSegmentIterator(reader io.ReaderAt, maxHeaderSize uint64) *segmentIterator {
+	return &segmentIterator{reader: reader, maxHeaderSize: maxHeaderSize}
+}
+
+// errHeaderBudgetExceeded is reported by a segmentIterator when the next
+// marker lies beyond the configured maximum header size
+var errHeaderBudgetExceeded = &jpegError{"JPEG header is larger than the maximum header size"}
+
+// segmentIterator walks the markers of a JPEG file, see newSegmentIterator
+type segmentIterator struct {
+	reader        io.ReaderAt
+	maxHeaderSize uint64
+	followScans   bool
+	offset        uint64
+	current       segment
+	length        int
+	started       bool
+	done          bool
+	eoiFound      bool
+	eoiOffset     uint64
+	err           error
+}
+
+// Next advances the iterator to the next segment, it returns false when there
+// are no more header segments or when an error occured
+func (it *segmentIterator) Next() bool {
+	if it.done {
+		return false
+	}
+
+	data := make([]byte, 2)
+
+	if !it.started {
+		it.started = true
+
+		// Check that the first two characters are 0xFF 0xD8  (SOI - Start of image)
+		if _, err := it.reader.ReadAt(data, 0); err != nil || data[0] != 0xFF || data[1] != 0xD8 {
+			return it.fail(&jpegError{"This probably is not a JPEG file"})
+		}
+		it.offset = 2
+	}
+
+	for {
+		// Read the marker, skipping any 0xFF fill bytes
+		if err := it.readAt(data, it.offset); err != nil {
+			return it.fail(err)
+		}
+		if data[0] != 0xFF {
+			return it.fail(&jpegError{"No FF found, JPEG is probably corrupted"})
+		}
+		it.offset += 2
+		for data[1] == 0xFF {
+			if err := it.readAt(data[1:], it.offset); err != nil {
+				return it.fail(err)
+			}
+			it.offset++
+		}
+
+		// EOI - End of image, there are no more segments
+		if data[1] == 0xD9 {
+			it.eoiFound = true
+			it.eoiOffset = it.offset - 2
+			it.done = true
+			return false
+		}
+
+		// Restart markers don't have size or data after them
+		if (data[1] >= 0xD0 && data[1] <= 0xD7) || data[1] == 0x01 {
+			continue
+		}
+
+		// Read the size, which is big endian and includes the two size bytes
+		size := make([]byte, 2)
+		if err := it.readAt(size, it.offset); err != nil {
+			return it.fail(err)
+		}
+		decodedSize := binary.BigEndian.Uint16(size)
+		if decodedSize < 2 {
+			return it.fail(&jpegError{"Invalid segment size, JPEG is probably corrupted"})
+		}
+
+		it.current = segment{
+			segType:      data[1],
+			segName:      aJPEGSegmentNames[data[1]],
+			segDesc:      aJPEGSegmentDescriptions[data[1]],
+			segDataStart: it.offset + 2,
+		}
+		it.length = int(decodedSize) - 2
+		it.offset += uint64(decodedSize)
+
+		// If this is a SOS (Start Of Scan) segment, then there is no more header data - the compressed image data follows
+		if data[1] == 0xDA {
+			if !it.followScans {
+				it.done = true
+				return true
+			}
+
+			// Skip over the entropy-coded data to the marker that follows the scan
+			scanDataEnd, err := it.findScanEnd(it.offset)
+			if err != nil {
+				return it.fail(err)
+			}
+			it.current.scanDataStart = it.offset
+			it.current.scanDataEnd = scanDataEnd
+			it.offset = scanDataEnd
+		}
+		return true
+	}
+}
+
+// findScanEnd returns the offset of the first marker after the entropy-coded
+// data starting at offset. Stuffed zero bytes (FF 00), restart markers and
+// fill bytes are part of the entropy-coded data.
+func (it *segmentIterator) findScanEnd(offset uint64) (uint64, error) {
+	buffer := make([]byte, 64*1024)
+	for {
+		if it.maxHeaderSize > 0 && offset >= it.maxHeaderSize {
+			return 0, errHeaderBudgetExceeded
+		}
+
+		n, err := it.reader.ReadAt(buffer, int64(offset))
+		if n < 2 {
+			if err == nil || err == io.EOF {
+				err = &jpegError{"Scan data is truncated, no marker found after it"}
 			}
+			return 0, err
 		}
+
+		// Look for a 0xFF followed by a byte that makes it a real marker. The
+		// last byte of the buffer is looked at again by the next read.
+		for i := 0; i < n-1; i++ {
+			if buffer[i] != 0xFF {
+				continue
+			}
+			b := buffer[i+1]
+			if b != 0x00 && b != 0xFF && (b < 0xD0 || b > 0xD7) {
+				return offset + uint64(i), nil
+			}
+		}
+		offset += uint64(n - 1)
 	}
+}
 
-	// Close File
-	fi.Close()
+// Segment returns the current segment, its segData is not filled in
+func (it *segmentIterator) Segment() segment {
+	return it.current
+}
+
+// Length returns the size of the payload of the current segment
+func (it *segmentIterator) Length() int {
+	return it.length
+}
 
-	// Return the header data retrieved
-	return segments, nil
+// Offset returns the absolute offset of the marker of the current segment
+func (it *segmentIterator) Offset() uint64 {
+	return it.current.segDataStart - 4
+}
+
+// Payload reads the data of the current segment
+func (it *segmentIterator) Payload() ([]byte, error) {
+	segData := make([]byte, it.length)
+	n, err := it.reader.ReadAt(segData, int64(it.current.segDataStart))
+	if n < len(segData) {
+		if err == nil || err == io.EOF {
+			err = &jpegError{"Segment data could not be read, JPEG is probably truncated"}
+		}
+		return nil, err
+	}
+	return segData, nil
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *segmentIterator) Err() error {
+	return it.err
+}
+
+func (it *segmentIterator) fail(err error) bool {
+	it.err = err
+	it.done = true
+	return false
+}
+
+// readAt reads marker and size bytes, honouring the maximum header size
+func (it *segmentIterator) readAt(data []byte, offset uint64) error {
+	if it.maxHeaderSize > 0 && offset+uint64(len(data)) > it.maxHeaderSize {
+		return errHeaderBudgetExceeded
+	}
+	if n, _ := it.reader.ReadAt(data, int64(offset)); n < len(data) {
+		return &jpegError{"Unexpected end of file, JPEG is probably truncated"}
+	}
+	return nil
 }
 
 /******************************************************************************
 *
-* Function:     put_jpegHeader
+* Function:     putJPEGHeaderData
 *
 * Description:  Writes JPEG header data into a JPEG file. Takes an array in the
-*               same format as from get_jpegHeader, and combines it with
+*               same format as from getJPEGHeaderData, and combines it with
 *               the image data of an existing JPEG file, to create a new JPEG file
+*               Any data following the EOI marker of the old file (trailers,
+*               Motion Photo videos, MPF secondary images) is copied byte for
+*               byte to the new file. The new file replaces newFilename
+*               atomically, see putJPEGHeaderDataSafe. When the header has no
+*               padding yet, the default padding is reserved so that later
+*               metadata edits can be patched in place.
 *               WARNING: As this function will replace all JPEG headers,
 *                        including SOF etc, it is best to read the jpeg headers
 *                        from a file, alter them, then put them back on the same
@@ -158,167 +513,307 @@ func getJPEGHeaderData(filename string) ([]segment, error) {
 * Parameters:   oldFilename - the JPEG file from which the image data will be retrieved
 *               newFilename - the name of the new JPEG to create (can be same as oldFilename)
 *               jpegHeader - a JPEG header data array in the same format
-*                                  as from get_jpegHeader
+*                                  as from getJPEGHeaderData
 *
-* Returns:      TRUE - on Success
-*               FALSE - on Failure
+* Returns:      nil - on Success
+*               error - on Failure
 *
 ******************************************************************************/
 
 func putJPEGHeaderData(oldFilename string, newFilename string, jpegHeader []segment) error {
-	// Change: added check to ensure data exists, as of revision 1.10
-	// Check if the data to be written exists
+	return putJPEGHeaderDataSafe(oldFilename, newFilename, jpegHeader, jpegWriteOptions{preserveMode: true, padding: jpegDefaultPadding})
+}
 
-	// extract the compressed image data from the old file
-	compressedImageData := get_jpeg_image_data(oldFilename)
+/******************************************************************************
+*
+* Function:     writeJPEG
+*
+* Description:  Writes a complete JPEG image to a stream: SOI, the header
+*               segments, the compressed image data, EOI and the trailer
+*
+* Parameters:   writer - the stream to write the JPEG to
+*               jpegHeader - a JPEG header data array in the same format
+*                            as from getJPEGHeaderData, ending with the SOS
+*                            segment which the compressed image data follows
+*               compressedImageData - the image data, as from getJPEGImageData
+*               trailer - the data to write after the EOI marker, can be nil
+*
+* Returns:      nil - on Success
+*               error - on Failure
+*
+******************************************************************************/
 
-	// Check if the extraction worked
-	if compressedImageData == false || len(compressedImageData) == 0 {
-		return &jpegError{"Couldn't get image data from old file"}
+func writeJPEG(writer io.Writer, jpegHeader []segment, compressedImageData []byte, trailer []byte) error {
+	headerData, err := encodeJPEGSegments(jpegHeader)
+	if err != nil {
+		return err
 	}
 
+	w := bufio.NewWriter(writer)
+
+	// Write SOI
+	w.Write([]byte{0xFF, 0xD8})
+
+	// Write the header segments
+	w.Write(headerData)
+
+	// Write the compressed image data
+	w.Write(compressedImageData)
+
+	// Write EOI
+	w.Write([]byte{0xFF, 0xD9})
+
+	// Write anything that followed the image
+	w.Write(trailer)
+
+	return w.Flush()
+}
+
+// encodeJPEGSegments returns the segments as they are stored in a JPEG file,
+// each with its marker and size
+func encodeJPEGSegments(jpegHeader []segment) ([]byte, error) {
+	var headerData bytes.Buffer
+
 	// Cycle through new headers
-	// foreach ($jpegHeader as $segno : $segment)
-	for _, segment := range jpegHeader {
+	for _, seg := range jpegHeader {
 		// Check that this header is smaller than the maximum size
-		if len(segment.segData) > 0xfffd {
-			return &jpegError{"A Header is too large to fit in JPEG segment"}
+		if len(seg.segData) > jpegSegmentMaxSize {
+			return nil, &jpegError{"A Header is too large to fit in JPEG segment"}
 		}
-	}
 
-	// Attempt to open the new jpeg file
-	fi, err := os.Open(newFilename)
+		// Write segment marker and size, the size includes the two size bytes
+		headerData.Write([]byte{0xFF, seg.segType})
+		binary.Write(&headerData, binary.BigEndian, uint16(len(seg.segData)+2))
 
-	// Check if the file opened successfully
-	if err != nill {
-		return &jpegError{"Could not open file $newFilename"}
+		// Write segment data
+		headerData.Write(seg.segData)
 	}
+	return headerData.Bytes(), nil
+}
 
-	// Write SOI
-	writer := bufio.NewWriter(fi)
-	writer.Write(0xFF)
-	writer.Write(0xD8)
+// jpegWriteOptions controls how putJPEGHeaderDataSafe replaces a file
+type jpegWriteOptions struct {
+	preserveMode    bool // Give the new file the permissions of the file it replaces (or of the old file)
+	preserveModTime bool // Give the new file the modification time of the old file
+	keepBackup      bool // Keep the replaced file with a .bak extension added
+	padding         int  // Number of bytes of padding to reserve for later in place edits when the header has none, see putJPEGHeaderDataInPlace
+}
 
-	// Cycle through new headers, writing them to the new file
-	// foreach ($jpegHeader as $segno : $segment)
-	for _, seg := range jpegHeader {
-		// Write segment marker
-		// fwrite( $newfilehnd, sprintf( "\xFF%c", $segment['SegType'] ) );
-		writer.Write(seg.segType)
+/******************************************************************************
+*
+* Function:     putJPEGHeaderDataSafe
+*
+* Description:  Same as putJPEGHeaderData, but the new JPEG is first written
+*               to a temporary file in the same directory, flushed to disk and
+*               then renamed over newFilename. A crash part way through never
+*               leaves a half written file behind, even when the old and new
+*               filenames are the same.
+*
+* Parameters:   oldFilename - the JPEG file from which the image data will be retrieved
+*               newFilename - the name of the new JPEG to create (can be same as oldFilename)
+*               jpegHeader - a JPEG header data array in the same format
+*                            as from getJPEGHeaderData
+*               options - permissions, modification time and backup handling
+*
+* Returns:      nil - on Success
+*               error - on Failure
+*
+******************************************************************************/
 
-		// Write segment size
-		// fwrite( $newfilehnd, pack( "n", strlen($segment['SegData']) + 2 ) );
-		writer.Write(uint16(len(seg.segData)))
+func putJPEGHeaderDataSafe(oldFilename string, newFilename string, jpegHeader []segment, options jpegWriteOptions) error {
+	// extract the compressed image data and the trailer from the old file
+	fi, err := os.Open(oldFilename)
+	if err != nil {
+		return &jpegError{"Could not open file " + oldFilename}
+	}
+	oldInfo, err := fi.Stat()
+	if err != nil {
+		fi.Close()
+		return &jpegError{"Could not stat file " + oldFilename}
+	}
+	oldHeader, _ := readJPEGHeaderDataAt(fi)
+	compressedImageData, trailer, err := readJPEGImageAndTrailer(fi)
+	fi.Close()
 
-		// Write segment data
-		// fwrite( $newfilehnd, $segment['SegData'] );
-		writer.Write(seg.segData)
+	// Check if the extraction worked
+	if err != nil || len(compressedImageData) == 0 {
+		return &jpegError{"Couldn't get image data from old file"}
 	}
 
-	// Write the compressed image data
-	// fwrite( $newfilehnd, $compressedImageData );
-	writer.Write(compressedImageData)
+	// Padding from an earlier write is kept as it is
+	if options.padding > 0 && !hasJPEGPadding(jpegHeader) {
+		jpegHeader = reserveJPEGPadding(jpegHeader, options.padding)
+	}
 
-	// Write EOI
-	// fwrite( $newfilehnd, "\xFF\xD9" );
-	writer.Write(0xFF)
-	writer.Write(0xD9)
+	// Keep the Multi-Picture index pointing at the images in the trailer
+	if oldIndex, err := getMPFIndex(oldHeader); err == nil {
+		headerData, err := encodeJPEGSegments(jpegHeader)
+		if err != nil {
+			return err
+		}
+		newPrimarySize := uint64(2 + len(headerData) + len(compressedImageData) + 2)
+		jpegHeader, err = fixMPFOffsets(jpegHeader, oldIndex, trailer.start, newPrimarySize)
+		if err != nil {
+			return err
+		}
+	}
 
-	// Close File
-	// fclose($newfilehnd);
-	fi.Close()
+	// The permissions of a file that is replaced take precedence over those of the old file
+	mode := fs.FileMode(0644)
+	if options.preserveMode {
+		mode = oldInfo.Mode().Perm()
+		if newInfo, err := os.Stat(newFilename); err == nil {
+			mode = newInfo.Mode().Perm()
+		}
+	}
+
+	// Write the new JPEG to a temporary file next to the destination, so that
+	// the rename below stays within one file system
+	dir, base := filepath.Split(newFilename)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+base+".*.tmp")
+	if err != nil {
+		return &jpegError{"Could not create temporary file for " + newFilename}
+	}
+	tmpName := tmp.Name()
+
+	err = writeJPEG(tmp, jpegHeader, compressedImageData, trailer.data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmpName, mode)
+	}
+	if err == nil && options.preserveModTime {
+		err = os.Chtimes(tmpName, oldInfo.ModTime(), oldInfo.ModTime())
+	}
+	if err == nil && options.keepBackup {
+		err = backupFile(newFilename)
+	}
+	if err == nil {
+		err = os.Rename(tmpName, newFilename)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
 
+	// Make the rename itself durable
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
 	return nil
 }
 
+// backupFile keeps the current contents of filename as filename.bak, if the
+// file exists. A hard link is used where possible to avoid copying.
+func backupFile(filename string) error {
+	if _, err := os.Stat(filename); err != nil {
+		return nil
+	}
+
+	backupName := filename + ".bak"
+	os.Remove(backupName)
+	if os.Link(filename, backupName) == nil {
+		return nil
+	}
+
+	src, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(backupName)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
 /******************************************************************************
 *
-* Function:     get_jpeg_Comment
+* Function:     getJPEGComment
 *
-* Description:  Retreives the contents of the JPEG Comment (COM = 0xFFFE) segment if one
-*               exists
+* Description:  Retreives the first JPEG Comment (COM = 0xFFFE) segment if one
+*               exists. Use getJPEGComments to get all of the comments.
 *
 * Parameters:   jpegHeader - the JPEG header data, as retrieved
-*                                  from the get_jpegHeader function
+*                                  from the getJPEGHeaderData function
 *
-* Returns:      string - Contents of the Comment segement
-*               FALSE - if the comment segment couldnt be found
+* Returns:      segment - the Comment segment
+*               error - if the comment segment couldnt be found
 *
 ******************************************************************************/
 
 func getJPEGComment(jpegHeader []segment) (segment, error) {
 	//Cycle through the header segments until COM is found or we run out of segments
 	for _, seg := range jpegHeader {
-		if seg.segName == "COM" {
+		if seg.segType == 0xFE {
 			return seg, nil
 		}
 	}
-	return nil, &jpegError{"Couldn't find comment segment"}
+	return segment{}, &jpegError{"Couldn't find comment segment"}
 }
 
 /******************************************************************************
 *
-* Function:     put_jpeg_Comment
+* Function:     putJPEGComment
 *
 * Description:  Creates a new JPEG Comment segment from a string, and inserts
-*               this segment into the supplied JPEG header array
+*               this segment into the supplied JPEG header array. If there
+*               already is a Comment segment, the first one is replaced.
+*               The comment is stored as UTF-8.
 *
 * Parameters:   jpegHeader - a JPEG header data array in the same format
-*                                  as from get_jpegHeader, into which the
+*                                  as from getJPEGHeaderData, into which the
 *                                  new Comment segment will be put
-*               $new_Comment - a string containing the new Comment
+*               newComment - a string containing the new Comment
 *
 * Returns:      jpegHeader - the JPEG header data array with the new
 *                                  JPEG Comment segment added
+*               true - if the comment could be stored
 *
 ******************************************************************************/
 
 func putJPEGComment(jpegHeader []segment, newComment string) ([]segment, bool) {
-	//Cycle through the header segments
-	// for( $i = 0; $i < count( $jpegHeader ); $i++ )
-	for i, seg := range jpegHeader {
-		// If we find an COM header,
-		if seg == "COM" {
-			// Found a preexisting Comment block - Replace it with the new one and return.
-			jpegHeader[i].segData = new_Comment.segData
-			return jpegHeader, true
-		}
+	var err error
+	if len(getJPEGComments(jpegHeader)) > 0 {
+		// Found a preexisting Comment block - Replace it with the new one
+		jpegHeader, err = replaceJPEGComment(jpegHeader, 0, newComment, charsetUTF8)
+	} else {
+		jpegHeader, err = addJPEGComment(jpegHeader, newComment, charsetUTF8)
 	}
-
-	// No preexisting Comment block found, find where to put it by searching for the highest app segment
-	for i, seg := range jpegHeader {
-		if seg.segType < 0xE0 {
-
-			comment := &segment{}
-			comment.segType = 0xFE
-			comment.segName = aJPEGSegmentNames[0xFE]
-			comment.segDesc = JPEG_Segment_Descriptions[0xFE]
-			comment.segData = []byte(newComment)
-
-			jpegHeader = append(s, 0)
-			copy(jpegHeader[i+1:], jpegHeader[i:])
-			jpegHeader[i] = comment
-			return jpegHeader, true
-		}
-	}
-	return jpegHeader, false
+	return jpegHeader, err == nil
 }
 
 /******************************************************************************
 *
-* Function:     get_jpeg_image_data
+* Function:     getJPEGImageData
 *
 * Description:  Retrieves the compressed image data part of the JPEG file
 *
 * Parameters:   filename - the filename of the JPEG file to read
 *
-* Returns:      compressedData - A string containing the compressed data
-*               FALSE - if retrieval failed
+* Returns:      compressedData - A byte array containing the compressed data
+*               error - if retrieval failed
 *
 ******************************************************************************/
 
 func getJPEGImageData(filename string) ([]byte, error) {
-
 	// Attempt to open the jpeg file
 	fi, err := os.Open(filename)
 
@@ -327,96 +822,198 @@ func getJPEGImageData(filename string) ([]byte, error) {
 		// Could't open the file - exit
 		return nil, &jpegError{"Could not open the file"}
 	}
+	defer fi.Close()
 
-	reader := bufio.NewReader(fi)
+	return readJPEGImageData(fi)
+}
 
-	// Read the first two characters
-	data := make([]byte, 2)
-	reader.Read(data)
+/******************************************************************************
+*
+* Function:     getJPEGImageDataFS
+*
+* Description:  Retrieves the compressed image data part of a JPEG file that
+*               lives inside a file system
+*
+* Parameters:   fsys - the file system holding the JPEG file
+*               filename - the path of the JPEG file within fsys
+*
+* Returns:      compressedData - A byte array containing the compressed data
+*               error - if retrieval failed
+*
+******************************************************************************/
 
-	// Check that the first two characters are 0xFF 0xDA  (SOI - Start of image)
-	if data[0] != 0xFF || data[1] != 0xD8 {
-		// No SOI (FF D8) at start of file - close file and return;
-		fi.Close()
-		return nil, &jpegError{"No SOI (FF D8) at start of file"}
+func getJPEGImageDataFS(fsys fs.FS, filename string) ([]byte, error) {
+	fi, err := fsys.Open(filename)
+	if err != nil {
+		return nil, &jpegError{"Could not open the file"}
 	}
+	defer fi.Close()
 
-	// Read the third character
-	reader.Read(data)
+	return readJPEGImageData(fi)
+}
 
-	// Check that the third character is 0xFF (Start of first segment header)
-	if data[0] != 0xFF {
-		// NO FF found - close file and return
-		fi.Close()
-		return nil, &jpegError{"No FF found"}
-	}
+/******************************************************************************
+*
+* Function:     readJPEGImageDataAt
+*
+* Description:  Retrieves the compressed image data part of a JPEG held in a
+*               random access byte source
+*
+* Parameters:   reader - the byte source, the JPEG must start at offset 0
+*
+* Returns:      compressedData - A byte array containing the compressed data
+*               error - if retrieval failed
+*
+******************************************************************************/
 
-	// Flag that we havent yet hit the compressed image data
-	foundCompressedImageData := false
+func readJPEGImageDataAt(reader io.ReaderAt) ([]byte, error) {
+	return readJPEGImageData(io.NewSectionReader(reader, 0, math.MaxInt64))
+}
 
-	// Cycle through the file until, one of: 1) an EOI (End of image) marker is hit,
-	//                                       2) we have hit the compressed image data (no more headers are allowed after data)
-	//                                       3) or end of file is hit
+/******************************************************************************
+*
+* Function:     readJPEGImageData
+*
+* Description:  Retrieves the compressed image data part of a JPEG stream
+*
+* Parameters:   reader - the stream to read the JPEG from
+*
+* Returns:      compressedData - A byte array containing the compressed data
+*               error - if retrieval failed
+*
+******************************************************************************/
 
-	// while ( ( $data{1} != "\xD9" ) && (! $foundCompressedImageData) && ( ! feof( $filehnd ) ))
-	for data[1] != 0xD9 && !foundCompressedImageData {
-		// Found a segment to look at.
-		// Check that the segment marker is not a Restart marker - restart markers don't have size or data after them
-		if data[1] < 0xD0 || data[1] > 0xD7 {
-			// convert the size bytes (2) to an integer
-			var decodedSize uint16
-			binary.Read(reader, binary.LittleEndian, &decodedSize)
+func readJPEGImageData(reader io.Reader) ([]byte, error) {
+	compressedData, _, err := readJPEGImageAndTrailer(reader)
+	return compressedData, err
+}
 
-			// Read the segment data with length indicated by the previously read size
-			segdata := make([]byte, decodedsize-2)
-			reader.Read(segdata)
-		}
+/******************************************************************************
+* End of Function:     getJPEGImageData
+******************************************************************************/
 
-		// If this is a SOS (Start Of Scan) segment, then there is no more header data - the compressed image data follows
-		if data[1] == 0xDA {
-			// Flag that we have hit the compressed image data - exit loop after reading the data
-			foundCompressedImageData = true
+// jpegTrailer is the data following the EOI marker of the primary image, such
+// as Samsung trailer blocks, Google Motion Photo videos or MPF secondary images
+type jpegTrailer struct {
+	start uint64 // Offset of the first byte after the EOI marker
+	data  []byte
+}
+
+/******************************************************************************
+*
+* Function:     getJPEGTrailer
+*
+* Description:  Retrieves the data following the EOI marker of a JPEG file
+*
+* Parameters:   filename - the filename of the JPEG file to read
+*
+* Returns:      trailer - the trailing data, empty if there is none
+*               error - if the JPEG could not be read
+*
+******************************************************************************/
 
-			// read the rest of the file in
-			// Can't use the filesize function to work out
-			// how much to read, as it won't work for files being read by http or ftp
-			// So instead read 1Mb at a time till EOF
+func getJPEGTrailer(filename string) (jpegTrailer, error) {
+	fi, err := os.Open(filename)
+	if err != nil {
+		return jpegTrailer{}, &jpegError{"Could not open the file"}
+	}
+	defer fi.Close()
 
-			compressedData, err := ioutil.ReadAll(reader)
+	_, trailer, err := readJPEGImageAndTrailer(fi)
+	return trailer, err
+}
 
-			// Strip off EOI and anything after
-			s := len(compressedData)
-			// $EOI_pos = strpos( $compressedData, "\xFF\xD9" );
-			if compressedData[s-2] == 0xFF && compressedData[s-1] == 0xD9 {
-				compressedData = compressedData[0 : s-2]
-			}
-		} else {
-			// Not an SOS - Read the next two bytes - should be the segment marker for the next segment
-			reader.Read(data)
-
-			// Check that the first byte of the two is 0xFF as it should be for a marker
-			if data[0] != 0xFF {
-				// Problem - NO FF found, close file and return";
-				fi.Close()
-				return nil, &jpegError{"No FF found"}
-			}
-		}
+/******************************************************************************
+*
+* Function:     readJPEGTrailerAt
+*
+* Description:  Retrieves the data following the EOI marker of a JPEG held in
+*               a random access byte source
+*
+* Parameters:   reader - the byte source, the JPEG must start at offset 0
+*
+* Returns:      trailer - the trailing data, empty if there is none
+*               error - if the JPEG could not be read
+*
+******************************************************************************/
+
+func readJPEGTrailerAt(reader io.ReaderAt) (jpegTrailer, error) {
+	it := newSegmentIterator(reader, 0)
+	it.followScans = true
+	for it.Next() {
+	}
+	if it.Err() != nil {
+		return jpegTrailer{}, it.Err()
+	}
+	if !it.eoiFound {
+		return jpegTrailer{}, &jpegError{"No EOI found, JPEG is probably truncated"}
 	}
 
-	// Close File
-	fi.Close()
+	start := it.eoiOffset + 2
+	data, err := io.ReadAll(io.NewSectionReader(reader, int64(start), math.MaxInt64-int64(start)))
+	if err != nil {
+		return jpegTrailer{}, err
+	}
+	return jpegTrailer{start: start, data: data}, nil
+}
 
-	// Return the compressed data if it was found
-	if foundCompressedImageData {
-		return compressedData, nil
+// readJPEGImageAndTrailer reads a JPEG stream and splits what follows the
+// header segments into the compressed image data and the trailing data after
+// the EOI marker. When no EOI marker can be found everything is returned as
+// compressed image data.
+func readJPEGImageAndTrailer(reader io.Reader) ([]byte, jpegTrailer, error) {
+	in := &offsetReader{reader: bufio.NewReader(reader)}
+
+	// Skip over the header segments, they end at the SOS segment
+	_, foundCompressedImageData, err := readJPEGSegments(in)
+	if err != nil {
+		return nil, jpegTrailer{}, err
+	}
+	if !foundCompressedImageData {
+		return nil, jpegTrailer{}, &jpegError{"No compressed data found"}
 	}
+	imageStart := in.offset
 
-	return nil, &jpegError{"No compressed data found"}
+	// read the rest of the stream in
+	compressedData, err := io.ReadAll(in)
+	if err != nil {
+		return nil, jpegTrailer{}, &jpegError{"Could not read the compressed data"}
+	}
+
+	// Walk the scans to the real EOI, a trailer may itself end in FF D9
+	eoi, err := findJPEGEOI(bytes.NewReader(compressedData))
+	if err != nil {
+		// Strip off a final EOI, if any
+		s := len(compressedData)
+		if s >= 2 && compressedData[s-2] == 0xFF && compressedData[s-1] == 0xD9 {
+			compressedData = compressedData[0 : s-2]
+		}
+		return compressedData, jpegTrailer{start: in.offset}, nil
+	}
+
+	trailer := jpegTrailer{start: imageStart + eoi + 2, data: compressedData[eoi+2:]}
+	return compressedData[:eoi], trailer, nil
 }
 
-/******************************************************************************
-* End of Function:     get_jpeg_image_data
-******************************************************************************/
+// findJPEGEOI walks the entropy-coded data following a SOS segment, and any
+// further segments and scans, and returns the offset of the EOI marker
+func findJPEGEOI(reader io.ReaderAt) (uint64, error) {
+	it := &segmentIterator{reader: reader, followScans: true, started: true}
+	scanDataEnd, err := it.findScanEnd(0)
+	if err != nil {
+		return 0, err
+	}
+	it.offset = scanDataEnd
+	for it.Next() {
+	}
+	if it.Err() != nil {
+		return 0, it.Err()
+	}
+	if !it.eoiFound {
+		return 0, &jpegError{"No EOI found, JPEG is probably truncated"}
+	}
+	return it.eoiOffset, nil
+}
 
 /******************************************************************************
 * Global Variable:      JPEG_Segment_Names
@@ -426,7 +1023,7 @@ func getJPEGImageData(filename string) ([]byte, error) {
 ******************************************************************************/
 
 var aJPEGSegmentNames = map[byte]string{
-	0xC0: "SOF0", 0xC1: "SOF1", 0xC2: "SOF2", 0xC3: "SOF4",
+	0xC0: "SOF0", 0xC1: "SOF1", 0xC2: "SOF2", 0xC3: "SOF3",
 	0xC5: "SOF5", 0xC6: "SOF6", 0xC7: "SOF7", 0xC8: "JPG",
 	0xC9: "SOF9", 0xCA: "SOF10", 0xCB: "SOF11", 0xCD: "SOF13",
 	0xCE: "SOF14", 0xCF: "SOF15",
@@ -0,0 +1,335 @@
+package EXIF
+
+import (
+	"unicode/utf8"
+)
+
+// jpegCharset is the character set of the text in a COM segment. The JPEG
+// standard leaves it undefined, in practice it is either Latin-1 or UTF-8.
+type jpegCharset int
+
+const (
+	charsetAuto   jpegCharset = iota // Read as UTF-8 when the data is valid UTF-8, Latin-1 otherwise; written as UTF-8
+	charsetLatin1                    // ISO 8859-1
+	charsetUTF8                      // UTF-8
+)
+
+/******************************************************************************
+*
+* Function:     getJPEGComments
+*
+* Description:  Retrieves the text of all the comments in the JPEG Comment
+*               (COM) segments, in the order they appear in the header. A
+*               comment that was split over several segments is joined
+*               again. The JPEG standard has no way to continue a comment,
+*               so this library uses its own convention: a COM segment that
+*               is completely full (65533 bytes) is continued by the COM
+*               segment that directly follows it, see newJPEGCommentSegments.
+*               A full comment written by another tool is therefore joined
+*               with a comment that directly follows it. The character set of
+*               each comment is detected, see decodeJPEGComment.
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*
+* Returns:      comments - the comment texts, empty if there are none
+*
+******************************************************************************/
+
+func getJPEGComments(jpegHeader []segment) []string {
+	comments := []string{}
+	for _, group := range getJPEGCommentGroups(jpegHeader) {
+		data := []byte{}
+		for _, seg := range jpegHeader[group.start : group.start+group.count] {
+			data = append(data, seg.segData...)
+		}
+		comments = append(comments, decodeJPEGComment(data, charsetAuto))
+	}
+	return comments
+}
+
+/******************************************************************************
+*
+* Function:     addJPEGComment
+*
+* Description:  Adds a comment to the JPEG header data, after any existing
+*               comments or else after the APP segments. A comment that is
+*               too long for one segment is split over several segments,
+*               every one of them full but the last, which is how
+*               getJPEGComments knows to join them again. This is a
+*               convention of this library, not of the JPEG standard. An
+*               empty segment is put in between when the comment would
+*               directly follow a full COM segment.
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*               comment - the text of the comment
+*               charset - the character set to store the comment in,
+*                         charsetAuto stores UTF-8, Latin-1 has to be asked
+*                         for with charsetLatin1
+*
+* Returns:      jpegHeader - the JPEG header data with the comment added
+*               error - if the comment can't be stored in the character set
+*
+******************************************************************************/
+
+func addJPEGComment(jpegHeader []segment, comment string, charset jpegCharset) ([]segment, error) {
+	segments, err := newJPEGCommentSegments(comment, charset)
+	if err != nil {
+		return jpegHeader, err
+	}
+
+	// Find where to put it, after the last comment or the leading APP segments
+	insertAt := -1
+	for i, seg := range jpegHeader {
+		if seg.segType == 0xFE {
+			insertAt = i + 1
+		}
+	}
+	if insertAt < 0 {
+		insertAt = len(jpegHeader)
+		for i, seg := range jpegHeader {
+			if seg.segType < 0xE0 {
+				insertAt = i
+				break
+			}
+		}
+	}
+
+	// A full comment segment in front would be taken to continue into the new comment
+	if insertAt > 0 && jpegHeader[insertAt-1].segType == 0xFE && len(jpegHeader[insertAt-1].segData) == jpegSegmentMaxSize {
+		segments = append([]segment{{
+			segType: 0xFE,
+			segName: aJPEGSegmentNames[0xFE],
+			segDesc: aJPEGSegmentDescriptions[0xFE],
+			segData: []byte{},
+		}}, segments...)
+	}
+
+	return spliceJPEGSegments(jpegHeader, insertAt, 0, segments), nil
+}
+
+/******************************************************************************
+*
+* Function:     replaceJPEGComment
+*
+* Description:  Replaces the text of one of the comments in the JPEG header
+*               data, with all the segments the comment was split over
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*               index - which comment to replace, as in getJPEGComments
+*               comment - the new text of the comment
+*               charset - the character set to store the comment in
+*
+* Returns:      jpegHeader - the JPEG header data with the comment replaced
+*               error - if there is no such comment or the comment can't be
+*                       stored in the character set
+*
+******************************************************************************/
+
+func replaceJPEGComment(jpegHeader []segment, index int, comment string, charset jpegCharset) ([]segment, error) {
+	group, ok := findJPEGComment(jpegHeader, index)
+	if !ok {
+		return jpegHeader, &jpegError{"Couldn't find comment segment"}
+	}
+
+	segments, err := newJPEGCommentSegments(comment, charset)
+	if err != nil {
+		return jpegHeader, err
+	}
+
+	return spliceJPEGSegments(jpegHeader, group.start, group.count, segments), nil
+}
+
+/******************************************************************************
+*
+* Function:     deleteJPEGComment
+*
+* Description:  Removes one of the comments from the JPEG header data, with
+*               all the segments the comment was split over
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*               index - which comment to remove, as in getJPEGComments
+*
+* Returns:      jpegHeader - the JPEG header data without the comment
+*               error - if there is no such comment
+*
+******************************************************************************/
+
+func deleteJPEGComment(jpegHeader []segment, index int) ([]segment, error) {
+	group, ok := findJPEGComment(jpegHeader, index)
+	if !ok {
+		return jpegHeader, &jpegError{"Couldn't find comment segment"}
+	}
+	return spliceJPEGSegments(jpegHeader, group.start, group.count, nil), nil
+}
+
+/******************************************************************************
+*
+* Function:     deleteJPEGComments
+*
+* Description:  Removes all the comments from the JPEG header data
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*
+* Returns:      jpegHeader - the JPEG header data without comments
+*
+******************************************************************************/
+
+func deleteJPEGComments(jpegHeader []segment) []segment {
+	newHeader := []segment{}
+	for _, seg := range jpegHeader {
+		if seg.segType != 0xFE {
+			newHeader = append(newHeader, seg)
+		}
+	}
+	return newHeader
+}
+
+/******************************************************************************
+*
+* Function:     decodeJPEGComment
+*
+* Description:  Converts the data of a COM segment to a string
+*
+* Parameters:   data - the data of the COM segment
+*               charset - the character set of the data, with charsetAuto
+*                         the data is taken as UTF-8 when it is valid UTF-8
+*                         and as Latin-1 otherwise
+*
+* Returns:      comment - the text of the comment
+*
+******************************************************************************/
+
+func decodeJPEGComment(data []byte, charset jpegCharset) string {
+	if charset == charsetUTF8 || (charset == charsetAuto && utf8.Valid(data)) {
+		return string(data)
+	}
+
+	// Latin-1 maps every byte straight onto the first 256 code points
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+/******************************************************************************
+*
+* Function:     encodeJPEGComment
+*
+* Description:  Converts a string to the data of a COM segment
+*
+* Parameters:   comment - the text of the comment
+*               charset - the character set to use, charsetLatin1 stores
+*                         the text as Latin-1, charsetAuto and charsetUTF8
+*                         store it as UTF-8
+*
+* Returns:      data - the encoded comment
+*               error - if the text can't be stored in Latin-1
+*
+******************************************************************************/
+
+func encodeJPEGComment(comment string, charset jpegCharset) ([]byte, error) {
+	if charset != charsetLatin1 {
+		return []byte(comment), nil
+	}
+
+	data := make([]byte, 0, len(comment))
+	for _, r := range comment {
+		if r > 0xFF {
+			return nil, &jpegError{"Comment contains characters that are not in Latin-1"}
+		}
+		data = append(data, byte(r))
+	}
+	return data, nil
+}
+
+// newJPEGCommentSegments encodes a comment into as many COM segments as
+// needed. Every segment but the last of a split comment is completely full,
+// which is what marks it as continued by the next segment. The segments are
+// split on the byte limit, as they are joined again before being decoded.
+// When the last segment is full as well an empty segment ends the comment.
+func newJPEGCommentSegments(comment string, charset jpegCharset) ([]segment, error) {
+	data, err := encodeJPEGComment(comment, charset)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := []segment{}
+	for {
+		size := len(data)
+		if size > jpegSegmentMaxSize {
+			size = jpegSegmentMaxSize
+		}
+
+		segments = append(segments, segment{
+			segType: 0xFE,
+			segName: aJPEGSegmentNames[0xFE],
+			segDesc: aJPEGSegmentDescriptions[0xFE],
+			segData: data[:size],
+		})
+
+		data = data[size:]
+		if len(data) == 0 && size < jpegSegmentMaxSize {
+			return segments, nil
+		}
+	}
+}
+
+// jpegCommentGroup is the position in the header of the COM segments that
+// one comment is stored in
+type jpegCommentGroup struct {
+	start int
+	count int
+}
+
+// getJPEGCommentGroups finds the segments of each comment. A full COM
+// segment is continued by the COM segment that directly follows it.
+func getJPEGCommentGroups(jpegHeader []segment) []jpegCommentGroup {
+	groups := []jpegCommentGroup{}
+	continued := false
+	for i, seg := range jpegHeader {
+		if seg.segType != 0xFE {
+			continued = false
+			continue
+		}
+		if continued {
+			groups[len(groups)-1].count++
+		} else {
+			groups = append(groups, jpegCommentGroup{start: i, count: 1})
+		}
+		continued = len(seg.segData) == jpegSegmentMaxSize
+	}
+	return groups
+}
+
+// findJPEGComment returns the segments of the comment with the given index
+func findJPEGComment(jpegHeader []segment, index int) (jpegCommentGroup, bool) {
+	groups := getJPEGCommentGroups(jpegHeader)
+	if index < 0 || index >= len(groups) {
+		return jpegCommentGroup{}, false
+	}
+	return groups[index], true
+}
+
+// spliceJPEGSegments replaces count segments at position i by the new
+// segments, without modifying the original header array
+func spliceJPEGSegments(jpegHeader []segment, i int, count int, segments []segment) []segment {
+	newHeader := make([]segment, 0, len(jpegHeader)-count+len(segments))
+	newHeader = append(newHeader, jpegHeader[:i]...)
+	newHeader = append(newHeader, segments...)
+	return append(newHeader, jpegHeader[i+count:]...)
+}
+
+func isASCII(data []byte) bool {
+	for _, b := range data {
+		if b >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,198 @@
+package EXIF
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestJPEGCommentRoundTrip(t *testing.T) {
+	tests := []struct {
+		name         string
+		comment      string
+		wantSegments int
+	}{
+		{"empty", "", 1},
+		{"short", "a comment", 1},
+		{"one byte short of a segment", strings.Repeat("a", jpegSegmentMaxSize-1), 1},
+		{"exactly one segment", strings.Repeat("a", jpegSegmentMaxSize), 2},
+		{"one byte over a segment", strings.Repeat("a", jpegSegmentMaxSize+1), 2},
+		{"70000 bytes", strings.Repeat("0123456789", 7000), 2},
+		{"exactly two segments", strings.Repeat("a", 2*jpegSegmentMaxSize), 3},
+		{"70000 bytes of UTF-8", strings.Repeat("€", 70000/3), 2},
+		{"UTF-8 split inside a character", "x" + strings.Repeat("€", 30000), 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jpegHeader := []segment{
+				newTestSegment(0xE0, []byte("JFIF\x00")),
+				newTestSegment(0xFE, []byte("encoder banner")),
+				newTestSegment(0xDB, make([]byte, 65)),
+				newTestSegment(0xDA, testSOS),
+			}
+			jpegHeader, err := addJPEGComment(jpegHeader, test.comment, charsetAuto)
+			if err != nil {
+				t.Fatal(err)
+			}
+			jpegHeader, err = addJPEGComment(jpegHeader, "last", charsetAuto)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count := bytes.Count(segmentTypes(jpegHeader), []byte{0xFE}); count != 2+test.wantSegments {
+				t.Errorf("%d COM segments, want %d", count, 2+test.wantSegments)
+			}
+
+			// Write the file and read it back
+			filename := writeTestFile(t, "test.jpg", newTestJPEG(t, jpegHeader[:len(jpegHeader)-1]...))
+			jpegHeader, err = getJPEGHeaderData(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			comments := getJPEGComments(jpegHeader)
+			if len(comments) != 3 || comments[0] != "encoder banner" || comments[1] != test.comment || comments[2] != "last" {
+				t.Fatalf("%d comments read back, want the banner, the comment and last", len(comments))
+			}
+
+			// Replacing and deleting act on all the segments of the comment
+			replaced, err := replaceJPEGComment(jpegHeader, 1, "short", charsetAuto)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := getJPEGComments(replaced); len(got) != 3 || got[1] != "short" || got[2] != "last" {
+				t.Errorf("comments after replacing = %q", got)
+			}
+			if count := bytes.Count(segmentTypes(replaced), []byte{0xFE}); count != 3 {
+				t.Errorf("%d COM segments after replacing, want 3", count)
+			}
+
+			deleted, err := deleteJPEGComment(jpegHeader, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := getJPEGComments(deleted); len(got) != 2 || got[0] != "encoder banner" || got[1] != "last" {
+				t.Errorf("comments after deleting = %q", got)
+			}
+		})
+	}
+}
+
+func TestAddJPEGCommentAfterFullForeignComment(t *testing.T) {
+	// A comment of exactly one full segment written by another tool
+	full := strings.Repeat("a", jpegSegmentMaxSize)
+	jpegHeader := []segment{newTestSegment(0xFE, []byte(full)), newTestSegment(0xDA, testSOS)}
+
+	jpegHeader, err := addJPEGComment(jpegHeader, "new", charsetAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := getJPEGComments(jpegHeader); len(got) != 2 || got[0] != full || got[1] != "new" {
+		t.Errorf("%d comments, want the full comment and new", len(got))
+	}
+}
+
+func TestJPEGCommentContinuation(t *testing.T) {
+	full := strings.Repeat("a", jpegSegmentMaxSize)
+	tests := []struct {
+		name       string
+		jpegHeader []segment
+		want       []string
+	}{
+		{"full comment followed by a comment",
+			[]segment{newTestSegment(0xFE, []byte(full)), newTestSegment(0xFE, []byte("more")), newTestSegment(0xDA, testSOS)},
+			[]string{full + "more"}},
+		{"full comment followed by an empty comment",
+			[]segment{newTestSegment(0xFE, []byte(full)), newTestSegment(0xFE, nil), newTestSegment(0xFE, []byte("next")), newTestSegment(0xDA, testSOS)},
+			[]string{full, "next"}},
+		{"full comment followed by another segment",
+			[]segment{newTestSegment(0xFE, []byte(full)), newTestSegment(0xDB, make([]byte, 65)), newTestSegment(0xFE, []byte("next")), newTestSegment(0xDA, testSOS)},
+			[]string{full, "next"}},
+		{"short comment followed by a comment",
+			[]segment{newTestSegment(0xFE, []byte("one")), newTestSegment(0xFE, []byte("two")), newTestSegment(0xDA, testSOS)},
+			[]string{"one", "two"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := getJPEGComments(test.jpegHeader)
+			if len(got) != len(test.want) {
+				t.Fatalf("%d comments, want %d", len(got), len(test.want))
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("comment %d is %d bytes, want %d", i, len(got[i]), len(test.want[i]))
+				}
+			}
+		})
+	}
+}
+
+func TestPutJPEGCommentUTF8(t *testing.T) {
+	jpegHeader := []segment{newTestSegment(0xDA, testSOS)}
+	for _, comment := range []string{"café", "crème brûlée"} {
+		var ok bool
+		jpegHeader, ok = putJPEGComment(jpegHeader, comment)
+		if !ok {
+			t.Fatalf("putJPEGComment(%q) failed", comment)
+		}
+		seg, err := getJPEGComment(jpegHeader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(seg.segData, []byte(comment)) {
+			t.Errorf("stored % X, want the UTF-8 of %q", seg.segData, comment)
+		}
+	}
+	if got := getJPEGComments(jpegHeader); len(got) != 1 || got[0] != "crème brûlée" {
+		t.Errorf("comments = %q, want the replaced comment only", got)
+	}
+}
+
+func TestJPEGCommentCharsets(t *testing.T) {
+	tests := []struct {
+		name    string
+		comment string
+		charset jpegCharset
+		want    []byte
+		wantErr bool
+	}{
+		{"ASCII", "plain", charsetAuto, []byte("plain"), false},
+		{"UTF-8 by default", "café", charsetAuto, []byte("café"), false},
+		{"UTF-8 asked for", "€uro", charsetUTF8, []byte("€uro"), false},
+		{"Latin-1 asked for", "café", charsetLatin1, []byte("caf\xE9"), false},
+		{"not Latin-1", "€uro", charsetLatin1, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := encodeJPEGComment(test.comment, test.charset)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("error = %v", err)
+			}
+			if test.wantErr {
+				return
+			}
+			if !bytes.Equal(data, test.want) {
+				t.Errorf("encoded = % X, want % X", data, test.want)
+			}
+			if got := decodeJPEGComment(data, charsetAuto); got != test.comment {
+				t.Errorf("decoded = %q, want %q", got, test.comment)
+			}
+		})
+	}
+}
+
+func TestJPEGCommentNotFound(t *testing.T) {
+	jpegHeader := []segment{newTestSegment(0xFE, []byte("only")), newTestSegment(0xDA, testSOS)}
+	for _, index := range []int{-1, 1} {
+		if _, err := replaceJPEGComment(jpegHeader, index, "x", charsetAuto); err == nil {
+			t.Errorf("replaceJPEGComment(%d): no error", index)
+		}
+		if _, err := deleteJPEGComment(jpegHeader, index); err == nil {
+			t.Errorf("deleteJPEGComment(%d): no error", index)
+		}
+	}
+	if got := getJPEGComments(deleteJPEGComments(jpegHeader)); len(got) != 0 {
+		t.Errorf("comments after deleting all = %q", got)
+	}
+}
@@ -0,0 +1,220 @@
+package EXIF
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/******************************************************************************
+*
+* Type:         FrameHeader
+*
+* Description:  The decoded contents of a SOFn (Start Of Frame) segment. It
+*               describes the geometry of the image and how it was coded,
+*               without having to decode the image itself.
+*
+******************************************************************************/
+
+type FrameHeader struct {
+	Marker       byte   // SOFn marker, 0xC0 to 0xCF
+	Precision    byte   // Sample precision in bits
+	Height       uint16 // Number of lines, 0 when defined by a later DNL segment
+	Width        uint16 // Number of samples per line
+	Components   []FrameComponent
+	Process      CodingProcess // Baseline, extended sequential, progressive or lossless
+	Differential bool          // Hierarchical (differential) frame
+	Arithmetic   bool          // Arithmetic instead of Huffman entropy coding
+}
+
+// FrameComponent is one image component as specified in a frame header
+type FrameComponent struct {
+	ID         byte // Component identifier
+	H          byte // Horizontal sampling factor
+	V          byte // Vertical sampling factor
+	QuantTable byte // Quantization table destination selector
+}
+
+// CodingProcess identifies the JPEG coding process of a frame
+type CodingProcess byte
+
+const (
+	CodingBaseline CodingProcess = iota
+	CodingExtendedSequential
+	CodingProgressive
+	CodingLossless
+)
+
+func (p CodingProcess) String() string {
+	switch p {
+	case CodingBaseline:
+		return "Baseline DCT"
+	case CodingExtendedSequential:
+		return "Extended sequential DCT"
+	case CodingProgressive:
+		return "Progressive DCT"
+	case CodingLossless:
+		return "Lossless"
+	}
+	return fmt.Sprintf("Unknown (%d)", byte(p))
+}
+
+/******************************************************************************
+*
+* Function:     isSOFMarker
+*
+* Description:  Checks if a marker is one of the Start Of Frame markers.
+*               0xC4 (DHT), 0xC8 (JPG) and 0xCC (DAC) fall in the same range
+*               but are not frame headers.
+*
+* Parameters:   segType - the marker to check
+*
+* Returns:      true - if the marker is a SOFn marker
+*
+******************************************************************************/
+
+func isSOFMarker(segType byte) bool {
+	return segType >= 0xC0 && segType <= 0xCF && segType != 0xC4 && segType != 0xC8 && segType != 0xCC
+}
+
+/******************************************************************************
+*
+* Function:     getJPEGFrameHeader
+*
+* Description:  Finds the SOFn segment in the JPEG header data and decodes it
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*
+* Returns:      frameHeader - the decoded frame header
+*               error - if no SOF segment was found or it could not be decoded
+*
+******************************************************************************/
+
+func getJPEGFrameHeader(jpegHeader []segment) (*FrameHeader, error) {
+	for _, seg := range jpegHeader {
+		if isSOFMarker(seg.segType) {
+			return decodeFrameHeader(seg)
+		}
+	}
+	return nil, &jpegError{"Couldn't find SOF segment"}
+}
+
+/******************************************************************************
+*
+* Function:     decodeFrameHeader
+*
+* Description:  Decodes the data of a SOFn segment into a FrameHeader
+*
+* Parameters:   seg - the SOFn segment
+*
+* Returns:      frameHeader - the decoded frame header
+*               error - if the segment is not a valid frame header
+*
+******************************************************************************/
+
+func decodeFrameHeader(seg segment) (*FrameHeader, error) {
+	if !isSOFMarker(seg.segType) {
+		return nil, &jpegError{"Segment is not a SOF segment"}
+	}
+
+	// Frame header layout:
+	// P (1) - Sample precision
+	// Y (2) - Number of lines
+	// X (2) - Number of samples per line
+	// Nf (1) - Number of image components in frame
+	// followed by Nf times: C (1), H/V (1), Tq (1)
+	data := seg.segData
+	if len(data) < 6 {
+		return nil, &jpegError{"SOF segment is too short"}
+	}
+
+	numComponents := int(data[5])
+	if len(data) < 6+3*numComponents {
+		return nil, &jpegError{"SOF segment is too short for its number of components"}
+	}
+
+	frame := &FrameHeader{
+		Marker:       seg.segType,
+		Precision:    data[0],
+		Height:       binary.BigEndian.Uint16(data[1:3]),
+		Width:        binary.BigEndian.Uint16(data[3:5]),
+		Components:   make([]FrameComponent, numComponents),
+		Differential: seg.segType&0x04 != 0,
+		Arithmetic:   seg.segType&0x08 != 0,
+	}
+
+	// The two low bits of the marker select the coding process
+	switch seg.segType & 0x03 {
+	case 0:
+		if seg.segType == 0xC0 {
+			frame.Process = CodingBaseline
+		} else {
+			frame.Process = CodingExtendedSequential
+		}
+	case 1:
+		frame.Process = CodingExtendedSequential
+	case 2:
+		frame.Process = CodingProgressive
+	case 3:
+		frame.Process = CodingLossless
+	}
+
+	for i := range frame.Components {
+		c := data[6+3*i:]
+		frame.Components[i] = FrameComponent{
+			ID:         c[0],
+			H:          c[1] >> 4,
+			V:          c[1] & 0x0F,
+			QuantTable: c[2],
+		}
+	}
+
+	return frame, nil
+}
+
+/******************************************************************************
+*
+* Function:     Subsampling
+*
+* Description:  Describes the chroma subsampling of a frame in J:a:b notation,
+*               based on the sampling factors of the luminance component
+*               relative to those of the chrominance components
+*
+* Returns:      subsampling - e.g. "4:2:0", "4:4:4", "4:0:0" for greyscale,
+*                             or an empty string if it is not a known scheme
+*
+******************************************************************************/
+
+func (f *FrameHeader) Subsampling() string {
+	if len(f.Components) == 1 {
+		return "4:0:0"
+	}
+	if len(f.Components) < 3 {
+		return ""
+	}
+
+	// All chroma components must be sampled in the same way
+	y, cb, cr := f.Components[0], f.Components[1], f.Components[2]
+	if cb.H != cr.H || cb.V != cr.V || cb.H == 0 || cb.V == 0 {
+		return ""
+	}
+	if y.H%cb.H != 0 || y.V%cb.V != 0 {
+		return ""
+	}
+
+	switch [2]byte{y.H / cb.H, y.V / cb.V} {
+	case [2]byte{1, 1}:
+		return "4:4:4"
+	case [2]byte{2, 1}:
+		return "4:2:2"
+	case [2]byte{2, 2}:
+		return "4:2:0"
+	case [2]byte{1, 2}:
+		return "4:4:0"
+	case [2]byte{4, 1}:
+		return "4:1:1"
+	case [2]byte{4, 2}:
+		return "4:1:0"
+	}
+	return ""
+}
@@ -0,0 +1,116 @@
+package EXIF
+
+import "testing"
+
+// newTestSOF returns the data of a frame header with three components
+func newTestSOF(height uint16, width uint16, luma byte, chroma byte) []byte {
+	return []byte{
+		8, byte(height >> 8), byte(height), byte(width >> 8), byte(width), 3,
+		1, luma, 0,
+		2, chroma, 1,
+		3, chroma, 1,
+	}
+}
+
+func TestDecodeFrameHeader(t *testing.T) {
+	tests := []struct {
+		marker       byte
+		process      CodingProcess
+		differential bool
+		arithmetic   bool
+	}{
+		{0xC0, CodingBaseline, false, false},
+		{0xC1, CodingExtendedSequential, false, false},
+		{0xC2, CodingProgressive, false, false},
+		{0xC3, CodingLossless, false, false},
+		{0xC5, CodingExtendedSequential, true, false},
+		{0xC7, CodingLossless, true, false},
+		{0xC9, CodingExtendedSequential, false, true},
+		{0xCA, CodingProgressive, false, true},
+		{0xCF, CodingLossless, true, true},
+	}
+
+	for _, test := range tests {
+		frame, err := decodeFrameHeader(newTestSegment(test.marker, newTestSOF(480, 640, 0x22, 0x11)))
+		if err != nil {
+			t.Errorf("%02X: %v", test.marker, err)
+			continue
+		}
+		if frame.Process != test.process || frame.Differential != test.differential || frame.Arithmetic != test.arithmetic {
+			t.Errorf("%02X: process %v, differential %v, arithmetic %v, want %v, %v, %v", test.marker,
+				frame.Process, frame.Differential, frame.Arithmetic, test.process, test.differential, test.arithmetic)
+		}
+		if frame.Height != 480 || frame.Width != 640 || frame.Precision != 8 || len(frame.Components) != 3 {
+			t.Errorf("%02X: geometry %dx%d, %d bits, %d components", test.marker, frame.Width, frame.Height, frame.Precision, len(frame.Components))
+		}
+		if c := frame.Components[1]; c.ID != 2 || c.H != 1 || c.V != 1 || c.QuantTable != 1 {
+			t.Errorf("%02X: second component = %+v", test.marker, c)
+		}
+	}
+}
+
+func TestDecodeFrameHeaderInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		seg  segment
+	}{
+		{"DHT is not a frame", newTestSegment(0xC4, newTestSOF(1, 1, 0x11, 0x11))},
+		{"DAC is not a frame", newTestSegment(0xCC, newTestSOF(1, 1, 0x11, 0x11))},
+		{"too short", newTestSegment(0xC0, []byte{8, 0, 1, 0, 1})},
+		{"missing components", newTestSegment(0xC0, newTestSOF(1, 1, 0x11, 0x11)[:12])},
+	}
+	for _, test := range tests {
+		if _, err := decodeFrameHeader(test.seg); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}
+
+func TestGetJPEGFrameHeader(t *testing.T) {
+	jpegHeader := []segment{
+		newTestSegment(0xE0, []byte("JFIF\x00")),
+		newTestSegment(0xC4, []byte{0x00}),
+		newTestSegment(0xC2, newTestSOF(100, 200, 0x22, 0x11)),
+	}
+	frame, err := getJPEGFrameHeader(jpegHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.Marker != 0xC2 || frame.Process.String() != "Progressive DCT" {
+		t.Errorf("frame marker %02X, process %v", frame.Marker, frame.Process)
+	}
+	if _, err := getJPEGFrameHeader(jpegHeader[:2]); err == nil {
+		t.Error("no error without a SOF segment")
+	}
+}
+
+func TestSubsampling(t *testing.T) {
+	tests := []struct {
+		luma   byte
+		chroma byte
+		want   string
+	}{
+		{0x11, 0x11, "4:4:4"},
+		{0x21, 0x11, "4:2:2"},
+		{0x22, 0x11, "4:2:0"},
+		{0x12, 0x11, "4:4:0"},
+		{0x41, 0x11, "4:1:1"},
+		{0x42, 0x11, "4:1:0"},
+		{0x31, 0x11, ""},
+		{0x22, 0x00, ""},
+	}
+	for _, test := range tests {
+		frame, err := decodeFrameHeader(newTestSegment(0xC0, newTestSOF(16, 16, test.luma, test.chroma)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := frame.Subsampling(); got != test.want {
+			t.Errorf("luma %02X, chroma %02X: subsampling %q, want %q", test.luma, test.chroma, got, test.want)
+		}
+	}
+
+	grey := &FrameHeader{Components: []FrameComponent{{ID: 1, H: 1, V: 1}}}
+	if got := grey.Subsampling(); got != "4:0:0" {
+		t.Errorf("greyscale subsampling %q, want 4:0:0", got)
+	}
+}
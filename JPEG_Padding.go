@@ -0,0 +1,218 @@
+package EXIF
+
+import (
+	"bytes"
+	"os"
+)
+
+// jpegPaddingIdent starts the data of the APP15 segments that reserve room
+// in the JPEG header for later in place edits
+const jpegPaddingIdent = "PADDING\x00"
+
+// jpegPaddingMinSize is the smallest padding segment, as stored in the file:
+// marker, size and identifier
+const jpegPaddingMinSize = 4 + len(jpegPaddingIdent)
+
+// jpegPaddingMaxSize is the largest padding segment, as stored in the file
+const jpegPaddingMaxSize = 2 + 0xFFFF
+
+// jpegDefaultPadding is the padding putJPEGHeaderData reserves, which is
+// enough for a typical later IPTC or XMP edit to be patched in place
+const jpegDefaultPadding = 4096
+
+/******************************************************************************
+*
+* Function:     isJPEGPadding
+*
+* Description:  Checks if a segment only reserves room in the JPEG header
+*
+* Parameters:   seg - the segment to check
+*
+* Returns:      true - if the segment is a padding segment
+*
+******************************************************************************/
+
+func isJPEGPadding(seg segment) bool {
+	return seg.segType == 0xEF && bytes.HasPrefix(seg.segData, []byte(jpegPaddingIdent))
+}
+
+/******************************************************************************
+*
+* Function:     reserveJPEGPadding
+*
+* Description:  Replaces any padding segments in the JPEG header data by
+*               padding segments taking up exactly size bytes in the file.
+*               The padding is placed after the last APP segment, so that
+*               metadata segments that grow can take it over.
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*               size - the number of bytes to reserve, the padding is
+*                      removed when it is below the minimum segment size
+*
+* Returns:      jpegHeader - the JPEG header data with the padding
+*
+******************************************************************************/
+
+func reserveJPEGPadding(jpegHeader []segment, size int) []segment {
+	// Remove the old padding, and find where to put the new one
+	newHeader := []segment{}
+	insertAt := 0
+	for _, seg := range jpegHeader {
+		if isJPEGPadding(seg) {
+			continue
+		}
+		newHeader = append(newHeader, seg)
+		if (seg.segType >= 0xE0 && seg.segType <= 0xEF) || seg.segType == 0xFE {
+			insertAt = len(newHeader)
+		}
+	}
+
+	padding := []segment{}
+	for size >= jpegPaddingMinSize {
+		// Don't leave a remainder that is too small for a segment of its own
+		chunk := size
+		if chunk > jpegPaddingMaxSize {
+			chunk = jpegPaddingMaxSize
+			if size-chunk < jpegPaddingMinSize {
+				chunk = size - jpegPaddingMinSize
+			}
+		}
+
+		segData := make([]byte, chunk-4)
+		copy(segData, jpegPaddingIdent)
+		padding = append(padding, segment{
+			segType: 0xEF,
+			segName: aJPEGSegmentNames[0xEF],
+			segDesc: aJPEGSegmentDescriptions[0xEF],
+			segData: segData,
+		})
+		size -= chunk
+	}
+
+	return append(newHeader[:insertAt], append(padding, newHeader[insertAt:]...)...)
+}
+
+// hasJPEGPadding checks if the JPEG header data already reserves padding
+func hasJPEGPadding(jpegHeader []segment) bool {
+	for _, seg := range jpegHeader {
+		if isJPEGPadding(seg) {
+			return true
+		}
+	}
+	return false
+}
+
+/******************************************************************************
+*
+* Function:     putJPEGHeaderDataInPlace
+*
+* Description:  Writes JPEG header data into an existing JPEG file without
+*               rewriting the image data. This works when the new header
+*               segments fit in the space taken by the current ones, with
+*               the padding reserved by an earlier write absorbing any
+*               difference in size. Otherwise, or when a backup is asked
+*               for, the file is rewritten with putJPEGHeaderDataSafe.
+*               WARNING: Unlike a full rewrite, an in place edit is not
+*                        atomic, a crash during the write can leave the
+*                        header damaged.
+*
+* Parameters:   filename - the JPEG file to update
+*               jpegHeader - a JPEG header data array in the same format
+*                            as from getJPEGHeaderData, its SOS segment must
+*                            be the same as the one in the file
+*               options - used for the full rewrite, their padding is
+*                         reserved when the file has to be rewritten and the
+*                         header has no padding yet
+*
+* Returns:      nil - on Success
+*               error - on Failure
+*
+******************************************************************************/
+
+func putJPEGHeaderDataInPlace(filename string, jpegHeader []segment, options jpegWriteOptions) error {
+	if !options.keepBackup {
+		done, err := patchJPEGHeaderData(filename, jpegHeader, options)
+		if done || err != nil {
+			return err
+		}
+	}
+	return putJPEGHeaderDataSafe(filename, filename, jpegHeader, options)
+}
+
+// patchJPEGHeaderData overwrites the header segments of a JPEG file, it
+// returns false when the new header does not fit
+func patchJPEGHeaderData(filename string, jpegHeader []segment, options jpegWriteOptions) (bool, error) {
+	if len(jpegHeader) == 0 || jpegHeader[len(jpegHeader)-1].segType != 0xDA {
+		return false, nil
+	}
+
+	fi, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if err != nil {
+		return false, &jpegError{"Could not open file " + filename}
+	}
+	defer fi.Close()
+
+	info, err := fi.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	// Find the SOS segment in the file, everything in front of it can be overwritten
+	oldHeader, err := readJPEGHeaderDataAt(fi)
+	if err != nil {
+		return false, err
+	}
+	if len(oldHeader) == 0 || oldHeader[len(oldHeader)-1].segType != 0xDA {
+		return false, &jpegError{"No compressed data found"}
+	}
+	sos := oldHeader[len(oldHeader)-1]
+	sosOffset := sos.segDataStart - 4
+
+	// The scan header is followed by the image data, so it can't change
+	newSOS := jpegHeader[len(jpegHeader)-1]
+	if !bytes.Equal(sos.segData, newSOS.segData) {
+		return false, nil
+	}
+
+	// Work out how much padding is needed to fill the space exactly
+	newHeader := reserveJPEGPadding(jpegHeader[:len(jpegHeader)-1], 0)
+	headerData, err := encodeJPEGSegments(newHeader)
+	if err != nil {
+		return false, err
+	}
+	gap := int(sosOffset) - 2 - len(headerData)
+	if gap < 0 || (gap > 0 && gap < jpegPaddingMinSize) {
+		return false, nil
+	}
+	if gap > 0 {
+		newHeader = reserveJPEGPadding(jpegHeader[:len(jpegHeader)-1], gap)
+	}
+
+	// The primary image keeps its size, but the MP header that the
+	// Multi-Picture offsets are relative to can move
+	if oldIndex, err := getMPFIndex(oldHeader); err == nil && len(oldIndex.Entries) > 0 {
+		primarySize := uint64(oldIndex.Entries[0].Size)
+		newHeader, err = fixMPFOffsets(newHeader, oldIndex, primarySize, primarySize)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	headerData, err = encodeJPEGSegments(newHeader)
+	if err != nil {
+		return false, err
+	}
+
+	// Overwrite the header segments, just after the SOI marker
+	if _, err := fi.WriteAt(headerData, 2); err != nil {
+		return true, err
+	}
+	if err := fi.Sync(); err != nil {
+		return true, err
+	}
+	if options.preserveModTime {
+		return true, os.Chtimes(filename, info.ModTime(), info.ModTime())
+	}
+	return true, nil
+}
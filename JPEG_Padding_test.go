@@ -0,0 +1,173 @@
+package EXIF
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// paddingSize returns the size in the file of the padding segments
+func paddingSize(jpegHeader []segment) (int, int) {
+	size, count := 0, 0
+	for _, seg := range jpegHeader {
+		if isJPEGPadding(seg) {
+			size += 4 + len(seg.segData)
+			count++
+		}
+	}
+	return size, count
+}
+
+func TestReserveJPEGPadding(t *testing.T) {
+	tests := []struct {
+		size      int
+		wantSize  int
+		wantCount int
+	}{
+		{0, 0, 0},
+		{jpegPaddingMinSize - 1, 0, 0},
+		{jpegPaddingMinSize, jpegPaddingMinSize, 1},
+		{4096, 4096, 1},
+		{jpegPaddingMaxSize, jpegPaddingMaxSize, 1},
+		{jpegPaddingMaxSize + 1, jpegPaddingMaxSize + 1, 2},
+		{jpegPaddingMaxSize + jpegPaddingMinSize, jpegPaddingMaxSize + jpegPaddingMinSize, 2},
+		{3 * jpegPaddingMaxSize, 3 * jpegPaddingMaxSize, 3},
+	}
+
+	jpegHeader := []segment{
+		newTestSegment(0xE0, []byte("JFIF\x00")),
+		newTestSegment(0xEF, append([]byte(jpegPaddingIdent), make([]byte, 100)...)),
+		newTestSegment(0xE1, []byte("Exif\x00\x00")),
+		newTestSegment(0xDB, make([]byte, 65)),
+		newTestSegment(0xDA, testSOS),
+	}
+
+	for _, test := range tests {
+		newHeader := reserveJPEGPadding(jpegHeader, test.size)
+		if size, count := paddingSize(newHeader); size != test.wantSize || count != test.wantCount {
+			t.Errorf("size %d: %d bytes in %d segments, want %d in %d", test.size, size, count, test.wantSize, test.wantCount)
+		}
+		if _, err := encodeJPEGSegments(newHeader); err != nil {
+			t.Errorf("size %d: %v", test.size, err)
+		}
+		// The padding follows the last APP segment
+		types := segmentTypes(newHeader)
+		if want := append(append([]byte{0xE0, 0xE1}, bytes.Repeat([]byte{0xEF}, test.wantCount)...), 0xDB, 0xDA); !bytes.Equal(types, want) {
+			t.Errorf("size %d: segment types % X, want % X", test.size, types, want)
+		}
+	}
+}
+
+func TestPutJPEGHeaderDataReservesPadding(t *testing.T) {
+	jpegHeader := []segment{newTestSegment(0xDB, make([]byte, 65)), newTestSegment(0xDA, testSOS)}
+
+	// The metadata writers only change the header data in memory
+	withIPTC, err := putJPEGIPTC(jpegHeader, []iptcRecord{newTestIPTCRecord(2, 5, "title")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	withXMP, err := putJPEGXMP(withIPTC, []byte("<x:xmpmeta/>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, count := paddingSize(withXMP); count != 0 {
+		t.Errorf("putJPEGIPTC and putJPEGXMP reserved %d bytes of padding", size)
+	}
+
+	tests := []struct {
+		name       string
+		jpegHeader []segment
+		wantSize   int
+	}{
+		{"no padding yet", withXMP, jpegDefaultPadding},
+		{"smaller padding kept", reserveJPEGPadding(withXMP, 100), 100},
+		{"larger padding kept", reserveJPEGPadding(withXMP, 3*jpegDefaultPadding), 3 * jpegDefaultPadding},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filename := writeTestFile(t, "test.jpg", newTestJPEG(t, newTestSegment(0xDB, make([]byte, 65))))
+			if err := putJPEGHeaderData(filename, filename, test.jpegHeader); err != nil {
+				t.Fatal(err)
+			}
+			jpegHeader, err := getJPEGHeaderData(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size, _ := paddingSize(jpegHeader); size != test.wantSize {
+				t.Errorf("%d bytes of padding in the file, want %d", size, test.wantSize)
+			}
+		})
+	}
+}
+
+func TestPutJPEGHeaderDataInPlace(t *testing.T) {
+	data := newTestJPEG(t, newTestSegment(0xDB, make([]byte, 65)))
+	filename := writeTestFile(t, "test.jpg", data)
+
+	// The first metadata write rewrites the file and reserves padding
+	jpegHeader, err := getJPEGHeaderData(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jpegHeader, err = putJPEGIPTC(jpegHeader, []iptcRecord{newTestIPTCRecord(2, 5, "title")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := putJPEGHeaderDataInPlace(filename, jpegHeader, jpegWriteOptions{padding: jpegDefaultPadding}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		caption int
+		inPlace bool
+	}{
+		{"grows into the padding", 1000, true},
+		{"shrinks", 10, true},
+		{"uses most of the padding", jpegDefaultPadding - 100, true},
+		{"larger than the padding", 2 * jpegDefaultPadding, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jpegHeader, err := getJPEGHeaderData(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			records := []iptcRecord{newTestIPTCRecord(2, 5, "title"), newTestIPTCRecord(2, 120, strings.Repeat("c", test.caption))}
+			newHeader, err := putJPEGIPTC(jpegHeader, records)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := putJPEGHeaderDataInPlace(filename, newHeader, jpegWriteOptions{}); err != nil {
+				t.Fatal(err)
+			}
+
+			after, err := os.Stat(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inPlace := os.SameFile(before, after) && after.Size() == before.Size(); inPlace != test.inPlace {
+				t.Errorf("patched in place: %v, want %v", inPlace, test.inPlace)
+			}
+			before = after
+
+			jpegHeader, err = getJPEGHeaderData(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			iptcRecords, err := getJPEGIPTC(jpegHeader)
+			if err != nil || len(iptcRecords) != 2 || len(iptcRecords[1].recData) != test.caption {
+				t.Errorf("IPTC records after the edit = %v, %v", iptcRecords, err)
+			}
+			if compressedData, err := getJPEGImageData(filename); err != nil || !bytes.Equal(compressedData, testScanData) {
+				t.Errorf("compressed data = % X, %v", compressedData, err)
+			}
+		})
+	}
+}
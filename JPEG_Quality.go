@@ -0,0 +1,251 @@
+package EXIF
+
+import (
+	"encoding/binary"
+)
+
+/******************************************************************************
+*
+* Type:         QuantTable
+*
+* Description:  One quantization table as defined in a DQT (Define Quantization
+*               Table) segment. The values are stored in natural (row major)
+*               order, not in the zig-zag order used in the segment.
+*
+******************************************************************************/
+
+type QuantTable struct {
+	ID        byte // Table destination identifier, 0 to 3
+	Precision byte // Precision of the values in bits, 8 or 16
+	Values    [64]uint16
+}
+
+// QualityEstimate is the result of comparing the quantization tables of a
+// JPEG against the IJG standard tables
+type QualityEstimate struct {
+	Quality  int  // Estimated quality, 1 to 100
+	Standard bool // True when the tables are exactly scaled IJG tables, false for custom (camera/Photoshop) tables
+}
+
+/******************************************************************************
+*
+* Function:     getJPEGQuantTables
+*
+* Description:  Decodes all the quantization tables from the DQT segments in
+*               the JPEG header data, in the order in which they appear
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*
+* Returns:      tables - the quantization tables
+*               error - if a DQT segment could not be decoded
+*
+******************************************************************************/
+
+func getJPEGQuantTables(jpegHeader []segment) ([]QuantTable, error) {
+	tables := []QuantTable{}
+	for _, seg := range jpegHeader {
+		if seg.segType == 0xDB {
+			segTables, err := decodeQuantTables(seg)
+			if err != nil {
+				return tables, err
+			}
+			tables = append(tables, segTables...)
+		}
+	}
+	return tables, nil
+}
+
+/******************************************************************************
+*
+* Function:     decodeQuantTables
+*
+* Description:  Decodes the data of a DQT segment, which can hold several
+*               quantization tables
+*
+* Parameters:   seg - the DQT segment
+*
+* Returns:      tables - the quantization tables in the segment
+*               error - if the segment data is invalid
+*
+******************************************************************************/
+
+func decodeQuantTables(seg segment) ([]QuantTable, error) {
+	tables := []QuantTable{}
+	data := seg.segData
+	for len(data) > 0 {
+		// First byte - Pq (precision, high nibble) and Tq (destination, low nibble)
+		table := QuantTable{ID: data[0] & 0x0F, Precision: 8}
+		size := 64
+		if data[0]>>4 != 0 {
+			table.Precision = 16
+			size = 128
+		}
+		data = data[1:]
+		if len(data) < size {
+			return tables, &jpegError{"DQT segment is too short"}
+		}
+
+		// Values are stored in zig-zag order
+		for i := 0; i < 64; i++ {
+			if table.Precision == 16 {
+				table.Values[aZigZag[i]] = binary.BigEndian.Uint16(data[2*i:])
+			} else {
+				table.Values[aZigZag[i]] = uint16(data[i])
+			}
+		}
+		data = data[size:]
+
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+/******************************************************************************
+*
+* Function:     estimateJPEGQuality
+*
+* Description:  Estimates the quality setting that a JPEG was saved with, by
+*               comparing its luminance (table 0) and chrominance (table 1)
+*               quantization tables against the IJG standard tables scaled
+*               for every quality from 1 to 100
+*
+* Parameters:   tables - the quantization tables, as from getJPEGQuantTables
+*
+* Returns:      estimate - the quality which is closest to the tables, and if
+*                          the tables are exactly the standard scaled tables
+*               error - if there is no luminance table
+*
+******************************************************************************/
+
+func estimateJPEGQuality(tables []QuantTable) (QualityEstimate, error) {
+	// Use the last definition of each table, as they may be redefined
+	var luminance, chrominance *QuantTable
+	for i := range tables {
+		switch tables[i].ID {
+		case 0:
+			luminance = &tables[i]
+		case 1:
+			chrominance = &tables[i]
+		}
+	}
+	if luminance == nil {
+		return QualityEstimate{}, &jpegError{"Couldn't find luminance quantization table"}
+	}
+
+	best := QualityEstimate{Quality: 1}
+	bestDiff := -1
+	for quality := 1; quality <= 100; quality++ {
+		diff := quantTableDiff(luminance, &aStdLuminanceQuantTable, quality)
+		if chrominance != nil {
+			diff += quantTableDiff(chrominance, &aStdChrominanceQuantTable, quality)
+		}
+
+		// On a tie prefer the higher quality
+		if bestDiff < 0 || diff <= bestDiff {
+			bestDiff = diff
+			best.Quality = quality
+		}
+	}
+	best.Standard = bestDiff == 0
+
+	return best, nil
+}
+
+// quantTableDiff returns the sum of absolute differences between a table and
+// a standard table scaled to the given quality
+func quantTableDiff(table *QuantTable, standard *[64]uint16, quality int) int {
+	scaled := scaleQuantTable(standard, quality, table.Precision)
+	diff := 0
+	for i := range table.Values {
+		d := int(table.Values[i]) - int(scaled[i])
+		if d < 0 {
+			d = -d
+		}
+		diff += d
+	}
+	return diff
+}
+
+// scaleQuantTable scales a standard table the way the IJG library does for
+// a given quality setting
+func scaleQuantTable(standard *[64]uint16, quality int, precision byte) [64]uint16 {
+	scale := 200 - 2*quality
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+
+	limit := 255
+	if precision == 16 {
+		limit = 32767
+	}
+
+	var scaled [64]uint16
+	for i, v := range standard {
+		x := (int(v)*scale + 50) / 100
+		if x < 1 {
+			x = 1
+		} else if x > limit {
+			x = limit
+		}
+		scaled[i] = uint16(x)
+	}
+	return scaled
+}
+
+/******************************************************************************
+* Global Variable:      ZigZag
+*
+* Contents:     Maps the position of a coefficient in zig-zag order to its
+*               position in natural (row major) order
+*
+******************************************************************************/
+
+var aZigZag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+/******************************************************************************
+* Global Variable:      Std_Luminance_Quant_Table
+*
+* Contents:     The IJG standard luminance quantization table (quality 50),
+*               in natural order, from Annex K of the JPEG standard
+*
+******************************************************************************/
+
+var aStdLuminanceQuantTable = [64]uint16{
+	16, 11, 10, 16, 24, 40, 51, 61,
+	12, 12, 14, 19, 26, 58, 60, 55,
+	14, 13, 16, 24, 40, 57, 69, 56,
+	14, 17, 22, 29, 51, 87, 80, 62,
+	18, 22, 37, 56, 68, 109, 103, 77,
+	24, 35, 55, 64, 81, 104, 113, 92,
+	49, 64, 78, 87, 103, 121, 120, 101,
+	72, 92, 95, 98, 112, 100, 103, 99,
+}
+
+/******************************************************************************
+* Global Variable:      Std_Chrominance_Quant_Table
+*
+* Contents:     The IJG standard chrominance quantization table (quality 50),
+*               in natural order, from Annex K of the JPEG standard
+*
+******************************************************************************/
+
+var aStdChrominanceQuantTable = [64]uint16{
+	17, 18, 24, 47, 99, 99, 99, 99,
+	18, 21, 26, 66, 99, 99, 99, 99,
+	24, 26, 56, 99, 99, 99, 99, 99,
+	47, 66, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+}
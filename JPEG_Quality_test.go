@@ -0,0 +1,90 @@
+package EXIF
+
+import "testing"
+
+// newTestDQT encodes quantization tables given in natural order as the data
+// of a DQT segment
+func newTestDQT(tables ...QuantTable) []byte {
+	data := []byte{}
+	for _, table := range tables {
+		if table.Precision == 16 {
+			data = append(data, 0x10|table.ID)
+			for i := 0; i < 64; i++ {
+				v := table.Values[aZigZag[i]]
+				data = append(data, byte(v>>8), byte(v))
+			}
+		} else {
+			data = append(data, table.ID)
+			for i := 0; i < 64; i++ {
+				data = append(data, byte(table.Values[aZigZag[i]]))
+			}
+		}
+	}
+	return data
+}
+
+func TestDecodeQuantTables(t *testing.T) {
+	var values [64]uint16
+	for i := range values {
+		values[i] = uint16(i*300 + 1)
+	}
+	tables := []QuantTable{
+		{ID: 0, Precision: 8, Values: scaleQuantTable(&aStdLuminanceQuantTable, 80, 8)},
+		{ID: 1, Precision: 16, Values: values},
+	}
+
+	decoded, err := decodeQuantTables(newTestSegment(0xDB, newTestDQT(tables...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 2 || decoded[0] != tables[0] || decoded[1] != tables[1] {
+		t.Errorf("decoded tables = %+v, want %+v", decoded, tables)
+	}
+
+	for _, size := range []int{1, 64, 65 + 128} {
+		if _, err := decodeQuantTables(newTestSegment(0xDB, newTestDQT(tables...)[:size])); err == nil {
+			t.Errorf("no error for DQT data truncated to %d bytes", size)
+		}
+	}
+}
+
+func TestEstimateJPEGQuality(t *testing.T) {
+	for _, quality := range []int{1, 10, 50, 75, 90, 95, 100} {
+		dqt := newTestDQT(
+			QuantTable{ID: 0, Precision: 8, Values: scaleQuantTable(&aStdLuminanceQuantTable, quality, 8)},
+			QuantTable{ID: 1, Precision: 8, Values: scaleQuantTable(&aStdChrominanceQuantTable, quality, 8)},
+		)
+		// Each table in its own DQT segment, as some encoders write them
+		jpegHeader := []segment{newTestSegment(0xDB, dqt[:65]), newTestSegment(0xDB, dqt[65:])}
+
+		tables, err := getJPEGQuantTables(jpegHeader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		estimate, err := estimateJPEGQuality(tables)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if estimate.Quality != quality || !estimate.Standard {
+			t.Errorf("quality %d: estimate %+v", quality, estimate)
+		}
+	}
+}
+
+func TestEstimateJPEGQualityCustomTables(t *testing.T) {
+	luminance := QuantTable{ID: 0, Precision: 8, Values: scaleQuantTable(&aStdLuminanceQuantTable, 85, 8)}
+	luminance.Values[0]++
+	luminance.Values[63]--
+
+	estimate, err := estimateJPEGQuality([]QuantTable{luminance})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if estimate.Quality != 85 || estimate.Standard {
+		t.Errorf("estimate %+v, want quality 85 with custom tables", estimate)
+	}
+
+	if _, err := estimateJPEGQuality([]QuantTable{{ID: 1, Precision: 8}}); err == nil {
+		t.Error("no error without a luminance table")
+	}
+}
@@ -0,0 +1,196 @@
+package EXIF
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+/******************************************************************************
+*
+* Function:     getJPEGHeaderDataTolerant
+*
+* Description:  Reads the JPEG header segments from a JPEG file that may be
+*               truncated or have garbage between its segments, such as
+*               photos recovered from a damaged memory card
+*
+* Parameters:   filename - the filename of the JPEG file to read
+*
+* Returns:      headerdata - Array of the intact JPEG header segments
+*               diagnostics - what had to be skipped or dropped, and where
+*               error - if the file could not be opened
+*
+******************************************************************************/
+
+func getJPEGHeaderDataTolerant(filename string) ([]segment, []JPEGDiagnostic, error) {
+	fi, err := os.Open(filename)
+	if err != nil {
+		return []segment{}, nil, &jpegError{"Could not open file"}
+	}
+	defer fi.Close()
+
+	info, err := fi.Stat()
+	if err != nil {
+		return []segment{}, nil, err
+	}
+
+	segments, diagnostics := readJPEGHeaderDataTolerant(fi, info.Size())
+	return segments, diagnostics, nil
+}
+
+/******************************************************************************
+*
+* Function:     readJPEGHeaderDataTolerant
+*
+* Description:  Reads the JPEG header segments like readJPEGHeaderDataAt, but
+*               doesn't give up on damaged data. After garbage it carries on
+*               at the next FF xx marker, and a segment cut off by the end of
+*               the data is dropped. Every intact segment is returned, so the
+*               EXIF and IPTC data of the APP segments can still be decoded.
+*
+* Parameters:   reader - the byte source
+*               size - the size of the data
+*
+* Returns:      headerdata - Array of the intact JPEG header segments
+*               diagnostics - what had to be skipped or dropped, and where
+*
+******************************************************************************/
+
+func readJPEGHeaderDataTolerant(reader io.ReaderAt, size int64) ([]segment, []JPEGDiagnostic) {
+	r := &jpegValidator{reader: reader, size: uint64(size)}
+	segments := []segment{}
+
+	data := make([]byte, 2)
+	offset := uint64(0)
+	if err := r.readAt(data, 0); err == nil && data[0] == 0xFF && data[1] == 0xD8 {
+		offset = 2
+	} else {
+		r.report(0, SeverityWarning, "Data doesn't start with a SOI marker")
+	}
+
+	for offset < r.size {
+		// Anything but a marker here is garbage, skip to the next marker
+		if err := r.readAt(data[:1], offset); err != nil || data[0] != 0xFF {
+			next, ok := r.resync(offset)
+			if !ok {
+				r.report(offset, SeverityError, "No marker found in the remaining %d bytes", r.size-offset)
+				return segments, r.diagnostics
+			}
+			r.report(offset, SeverityWarning, "Skipped %d bytes of garbage", next-offset)
+			offset = next
+			continue
+		}
+
+		// Read the marker, skipping any 0xFF fill bytes
+		markerOffset := offset
+		offset++
+		for {
+			if err := r.readAt(data[1:], offset); err != nil {
+				r.report(offset, SeverityError, "Data ends in the middle of a marker")
+				return segments, r.diagnostics
+			}
+			offset++
+			if data[1] != 0xFF {
+				break
+			}
+			markerOffset = offset - 1
+		}
+		marker := data[1]
+
+		switch {
+		case marker == 0xD9:
+			r.report(markerOffset, SeverityError, "EOI marker before any SOS segment, there is no image data")
+			return segments, r.diagnostics
+
+		case marker == 0xD8:
+			r.report(markerOffset, SeverityWarning, "Skipped duplicate SOI marker")
+			continue
+
+		case marker < 0xC0 || (marker >= 0xD0 && marker <= 0xD7):
+			r.report(markerOffset, SeverityWarning, "Skipped stray 0x%02X marker", marker)
+			continue
+		}
+
+		// Read the size, which is big endian and includes the two size bytes
+		if err := r.readAt(data, offset); err != nil {
+			r.report(markerOffset, SeverityError, "Data ends in the length of a %s segment", aJPEGSegmentNames[marker])
+			return segments, r.diagnostics
+		}
+		length := uint64(binary.BigEndian.Uint16(data))
+
+		if length < 2 {
+			r.report(markerOffset, SeverityWarning, "Skipped %s segment with invalid length %d", aJPEGSegmentNames[marker], length)
+			offset = markerOffset + 1
+			continue
+		}
+		if offset+length > r.size {
+			r.report(markerOffset, SeverityError, "Dropped %s segment cut off by the end of the data, %d of its %d bytes are missing",
+				aJPEGSegmentNames[marker], offset+length-r.size, length+2)
+			return segments, r.diagnostics
+		}
+
+		seg := segment{
+			segType:      marker,
+			segName:      aJPEGSegmentNames[marker],
+			segDesc:      aJPEGSegmentDescriptions[marker],
+			segDataStart: offset + 2,
+			segData:      make([]byte, length-2),
+		}
+		if err := r.readAt(seg.segData, seg.segDataStart); err != nil {
+			r.report(markerOffset, SeverityError, "%s segment data could not be read", seg.segName)
+			return segments, r.diagnostics
+		}
+		segments = append(segments, seg)
+		offset += length
+
+		// If this is a SOS (Start Of Scan) segment, then there is no more header data - the compressed image data follows
+		if marker == 0xDA {
+			return segments, r.diagnostics
+		}
+	}
+
+	r.report(r.size, SeverityError, "Data ends before any SOS segment, there is no image data")
+	return segments, r.diagnostics
+}
+
+// resync looks for the next marker after garbage. A marker found there is
+// only believed if its segment is followed by another marker or the end of
+// the data, as random FF xx pairs are common in damaged data. SOI and EOI
+// have no segment, they must be followed by a marker or the end directly.
+func (v *jpegValidator) resync(offset uint64) (uint64, bool) {
+	marker := make([]byte, 2)
+	size := make([]byte, 2)
+	for {
+		next, ok := findNextJPEGMarker(v.reader, offset, v.size)
+		if !ok || v.readAt(marker, next) != nil {
+			return 0, false
+		}
+
+		end := next + 2
+		if marker[1] != 0xD8 && marker[1] != 0xD9 {
+			if v.readAt(size, end) != nil {
+				// Too close to the end of the data for a segment
+				return 0, false
+			}
+			length := uint64(binary.BigEndian.Uint16(size))
+			if length < 2 {
+				offset = next + 1
+				continue
+			}
+			end += length
+		}
+		if end <= v.size && v.markerAt(end) {
+			return next, true
+		}
+		offset = next + 1
+	}
+}
+
+// markerAt checks that a marker, or the end of the data, is at offset
+func (v *jpegValidator) markerAt(offset uint64) bool {
+	if offset == v.size {
+		return true
+	}
+	data := make([]byte, 1)
+	return v.readAt(data, offset) == nil && data[0] == 0xFF
+}
@@ -0,0 +1,83 @@
+package EXIF
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReadJPEGHeaderDataTolerant(t *testing.T) {
+	app1 := newTestSegment(0xE1, []byte("Exif\x00\x00MM"))
+	com := newTestSegment(0xFE, []byte("comment"))
+	encoded := func(segments ...segment) []byte {
+		data, err := encodeJPEGSegments(segments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	soi := []byte{0xFF, 0xD8}
+	sos := encoded(newTestSegment(0xDA, testSOS))
+
+	tests := []struct {
+		name      string
+		data      []byte
+		wantTypes []byte
+		messages  []string
+	}{
+		{"intact", newTestJPEG(t, app1, com), []byte{0xE1, 0xFE, 0xDA}, nil},
+		{"garbage between segments", join(soi, encoded(app1), []byte("garbage"), encoded(com), sos),
+			[]byte{0xE1, 0xFE, 0xDA}, []string{"Skipped 7 bytes of garbage"}},
+		{"EOI in garbage", join(soi, encoded(app1), []byte{0x00, 0xFF, 0xD9, 0x12}, encoded(com), sos),
+			[]byte{0xE1, 0xFE, 0xDA}, []string{"Skipped 4 bytes of garbage"}},
+		{"segment marker in garbage", join(soi, encoded(app1), []byte{0x00, 0xFF, 0xE2, 0x00, 0x03, 0x00, 0x00}, encoded(com), sos),
+			[]byte{0xE1, 0xFE, 0xDA}, []string{"Skipped 7 bytes of garbage"}},
+		{"invalid length in garbage", join(soi, encoded(app1), []byte{0x00, 0xFF, 0xE2, 0x00, 0x01, 0xFF}, encoded(com), sos),
+			[]byte{0xE1, 0xFE, 0xDA}, []string{"Skipped 6 bytes of garbage"}},
+		{"real EOI after garbage", join(soi, encoded(app1), []byte{0x00}, []byte{0xFF, 0xD9}),
+			[]byte{0xE1}, []string{"Skipped 1 bytes of garbage", "EOI marker before any SOS segment"}},
+		{"no SOI", join(encoded(app1), sos), []byte{0xE1, 0xDA}, []string{"doesn't start with a SOI"}},
+		{"cut off segment", join(soi, encoded(app1), encoded(com)[:5]), []byte{0xE1}, []string{"Dropped COM segment cut off"}},
+		{"no marker after garbage", join(soi, encoded(app1), []byte("garbage")), []byte{0xE1}, []string{"No marker found in the remaining 7 bytes"}},
+		{"no SOS", join(soi, encoded(app1)), []byte{0xE1}, []string{"Data ends before any SOS segment"}},
+		{"stray markers", join(soi, soi, []byte{0xFF, 0xD3}, encoded(app1), sos), []byte{0xE1, 0xDA}, []string{"duplicate SOI", "stray 0xD3"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			segments, diagnostics := readJPEGHeaderDataTolerant(bytes.NewReader(test.data), int64(len(test.data)))
+			if !bytes.Equal(segmentTypes(segments), test.wantTypes) {
+				t.Errorf("segment types % X, want % X", segmentTypes(segments), test.wantTypes)
+			}
+			for i, seg := range segments {
+				if seg.segType == 0xE1 && !bytes.Equal(seg.segData, app1.segData) || seg.segType == 0xFE && !bytes.Equal(seg.segData, com.segData) {
+					t.Errorf("segment %d data %q", i, seg.segData)
+				}
+			}
+			if len(diagnostics) != len(test.messages) {
+				t.Fatalf("diagnostics %v, want %q", diagnostics, test.messages)
+			}
+			for i, message := range test.messages {
+				if !strings.Contains(diagnostics[i].Message, message) {
+					t.Errorf("diagnostic %d = %q, want %q", i, diagnostics[i].Message, message)
+				}
+			}
+		})
+	}
+}
+
+func TestGetJPEGHeaderDataTolerantFile(t *testing.T) {
+	data := newTestJPEG(t, newTestSegment(0xFE, []byte("comment")))
+	filename := writeTestFile(t, "test.jpg", data[:len(data)/2])
+	segments, _, err := getJPEGHeaderDataTolerant(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 1 || string(segments[0].segData) != "comment" {
+		t.Errorf("segments = %v", segments)
+	}
+	if _, _, err := getJPEGHeaderDataTolerant(filename + ".missing"); err == nil {
+		t.Error("no error for a missing file")
+	}
+}
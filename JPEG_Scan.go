@@ -0,0 +1,258 @@
+package EXIF
+
+import (
+	"encoding/binary"
+)
+
+/******************************************************************************
+*
+* Type:         HuffmanTable
+*
+* Description:  One Huffman table as defined in a DHT (Define Huffman Table)
+*               segment
+*
+******************************************************************************/
+
+type HuffmanTable struct {
+	Class   byte     // Table class, 0 = DC or lossless, 1 = AC
+	ID      byte     // Table destination identifier, 0 to 3
+	Counts  [16]byte // Number of codes of each length, 1 to 16 bits
+	Symbols []byte   // The symbol values, in order of increasing code length
+}
+
+/******************************************************************************
+*
+* Type:         ScanHeader
+*
+* Description:  The decoded contents of a SOS (Start Of Scan) segment, along
+*               with the byte range of the entropy-coded data of the scan
+*
+******************************************************************************/
+
+type ScanHeader struct {
+	Components []ScanComponent
+	Ss         byte   // Start of spectral selection, or predictor selector for lossless
+	Se         byte   // End of spectral selection
+	Ah         byte   // Successive approximation bit position high
+	Al         byte   // Successive approximation bit position low, or point transform for lossless
+	DataStart  uint64 // Offset of the first byte of entropy-coded data
+	DataEnd    uint64 // Offset of the marker following the entropy-coded data
+
+	RestartInterval uint16 // MCUs between restart markers in this scan, 0 when restart markers are not used
+}
+
+// ScanComponent is one component taking part in a scan
+type ScanComponent struct {
+	Selector byte // Component selector, matches a FrameComponent ID
+	DCTable  byte // DC entropy coding table destination selector
+	ACTable  byte // AC entropy coding table destination selector
+}
+
+/******************************************************************************
+*
+* Function:     getJPEGHuffmanTables
+*
+* Description:  Decodes all the Huffman tables from the DHT segments in the
+*               JPEG segments, in the order in which they appear
+*
+* Parameters:   jpegHeader - the JPEG segments, as retrieved from the
+*                            getJPEGHeaderData or readJPEGStructureAt function
+*
+* Returns:      tables - the Huffman tables
+*               error - if a DHT segment could not be decoded
+*
+******************************************************************************/
+
+func getJPEGHuffmanTables(jpegHeader []segment) ([]HuffmanTable, error) {
+	tables := []HuffmanTable{}
+	for _, seg := range jpegHeader {
+		if seg.segType == 0xC4 {
+			segTables, err := decodeHuffmanTables(seg)
+			if err != nil {
+				return tables, err
+			}
+			tables = append(tables, segTables...)
+		}
+	}
+	return tables, nil
+}
+
+/******************************************************************************
+*
+* Function:     decodeHuffmanTables
+*
+* Description:  Decodes the data of a DHT segment, which can hold several
+*               Huffman tables
+*
+* Parameters:   seg - the DHT segment
+*
+* Returns:      tables - the Huffman tables in the segment
+*               error - if the segment data is invalid
+*
+******************************************************************************/
+
+func decodeHuffmanTables(seg segment) ([]HuffmanTable, error) {
+	tables := []HuffmanTable{}
+	data := seg.segData
+	for len(data) > 0 {
+		// First byte - Tc (class, high nibble) and Th (destination, low nibble)
+		// followed by 16 bytes with the number of codes of each length
+		if len(data) < 17 {
+			return tables, &jpegError{"DHT segment is too short"}
+		}
+		table := HuffmanTable{Class: data[0] >> 4, ID: data[0] & 0x0F}
+		copy(table.Counts[:], data[1:17])
+		data = data[17:]
+
+		numSymbols := 0
+		for _, count := range table.Counts {
+			numSymbols += int(count)
+		}
+		if len(data) < numSymbols {
+			return tables, &jpegError{"DHT segment is too short for its number of symbols"}
+		}
+		table.Symbols = data[:numSymbols]
+		data = data[numSymbols:]
+
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+/******************************************************************************
+*
+* Function:     getJPEGRestartInterval
+*
+* Description:  Retrieves the restart interval of the first scan, which is
+*               set by the last DRI (Define Restart Interval) segment in
+*               front of it. A DRI segment between the scans of a
+*               progressive JPEG changes the interval of the scans after it,
+*               use getJPEGScans for the interval of each scan.
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*
+* Returns:      interval - the number of MCUs between restart markers,
+*                          0 when there is no DRI segment
+*               error - if the DRI segment is invalid
+*
+******************************************************************************/
+
+func getJPEGRestartInterval(jpegHeader []segment) (uint16, error) {
+	interval := uint16(0)
+	for _, seg := range jpegHeader {
+		if seg.segType == 0xDA {
+			break
+		}
+		if seg.segType == 0xDD {
+			var err error
+			if interval, err = decodeRestartInterval(seg); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return interval, nil
+}
+
+// decodeRestartInterval decodes the data of a DRI segment
+func decodeRestartInterval(seg segment) (uint16, error) {
+	if len(seg.segData) < 2 {
+		return 0, &jpegError{"DRI segment is too short"}
+	}
+	return binary.BigEndian.Uint16(seg.segData), nil
+}
+
+/******************************************************************************
+*
+* Function:     getJPEGScans
+*
+* Description:  Decodes every SOS segment in the JPEG segments. Use the
+*               segments from readJPEGStructureAt to get all the scans of a
+*               progressive JPEG together with their data byte ranges. Each
+*               scan gets the restart interval of the last DRI segment in
+*               front of it.
+*
+* Parameters:   jpegHeader - the JPEG segments, as retrieved from the
+*                            getJPEGHeaderData or readJPEGStructureAt function
+*
+* Returns:      scans - the scan headers
+*               error - if a SOS segment could not be decoded
+*
+******************************************************************************/
+
+func getJPEGScans(jpegHeader []segment) ([]ScanHeader, error) {
+	scans := []ScanHeader{}
+	interval := uint16(0)
+	for _, seg := range jpegHeader {
+		switch seg.segType {
+		case 0xDD:
+			var err error
+			if interval, err = decodeRestartInterval(seg); err != nil {
+				return scans, err
+			}
+		case 0xDA:
+			scan, err := decodeScanHeader(seg)
+			if err != nil {
+				return scans, err
+			}
+			scan.RestartInterval = interval
+			scans = append(scans, *scan)
+		}
+	}
+	return scans, nil
+}
+
+/******************************************************************************
+*
+* Function:     decodeScanHeader
+*
+* Description:  Decodes the data of a SOS segment into a ScanHeader
+*
+* Parameters:   seg - the SOS segment
+*
+* Returns:      scanHeader - the decoded scan header
+*               error - if the segment is not a valid scan header
+*
+******************************************************************************/
+
+func decodeScanHeader(seg segment) (*ScanHeader, error) {
+	if seg.segType != 0xDA {
+		return nil, &jpegError{"Segment is not a SOS segment"}
+	}
+
+	// Scan header layout:
+	// Ns (1) - Number of image components in scan
+	// followed by Ns times: Cs (1), Td/Ta (1)
+	// Ss (1), Se (1), Ah/Al (1)
+	data := seg.segData
+	if len(data) < 1 {
+		return nil, &jpegError{"SOS segment is too short"}
+	}
+
+	numComponents := int(data[0])
+	if len(data) < 1+2*numComponents+3 {
+		return nil, &jpegError{"SOS segment is too short for its number of components"}
+	}
+
+	scan := &ScanHeader{
+		Components: make([]ScanComponent, numComponents),
+		DataStart:  seg.scanDataStart,
+		DataEnd:    seg.scanDataEnd,
+	}
+	for i := range scan.Components {
+		c := data[1+2*i:]
+		scan.Components[i] = ScanComponent{
+			Selector: c[0],
+			DCTable:  c[1] >> 4,
+			ACTable:  c[1] & 0x0F,
+		}
+	}
+
+	p := data[1+2*numComponents:]
+	scan.Ss = p[0]
+	scan.Se = p[1]
+	scan.Ah = p[2] >> 4
+	scan.Al = p[2] & 0x0F
+
+	return scan, nil
+}
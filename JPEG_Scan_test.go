@@ -0,0 +1,128 @@
+package EXIF
+
+import (
+	"bytes"
+	"testing"
+)
+
+// newTestProgressiveJPEG builds a JPEG with two scans. The first scan uses a
+// restart interval of 4, a DRI segment between the scans sets it to 0.
+func newTestProgressiveJPEG(t *testing.T) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	buffer.Write(newTestJPEG(t,
+		newTestSegment(0xC2, newTestSOF(16, 16, 0x11, 0x11)),
+		newTestSegment(0xDD, []byte{0x00, 0x08}),
+		newTestSegment(0xDD, []byte{0x00, 0x04}),
+	))
+	buffer.Truncate(buffer.Len() - 2)
+	for _, seg := range []segment{newTestSegment(0xDD, []byte{0x00, 0x00}), newTestSegment(0xDA, []byte{0x01, 0x02, 0x11, 0x01, 0x3F, 0x10})} {
+		size := len(seg.segData) + 2
+		buffer.Write([]byte{0xFF, seg.segType, byte(size >> 8), byte(size)})
+		buffer.Write(seg.segData)
+	}
+	buffer.Write(testScanData)
+	buffer.Write([]byte{0xFF, 0xD9})
+	return buffer.Bytes()
+}
+
+func TestGetJPEGScans(t *testing.T) {
+	data := newTestProgressiveJPEG(t)
+	segments, err := readJPEGStructureAt(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scans, err := getJPEGScans(segments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scans) != 2 {
+		t.Fatalf("%d scans, want 2", len(scans))
+	}
+
+	tests := []struct {
+		scan     ScanHeader
+		selector byte
+		interval uint16
+		se       byte
+		ah       byte
+	}{
+		{scans[0], 1, 4, 0x3F, 0},
+		{scans[1], 2, 0, 0x3F, 1},
+	}
+	for i, test := range tests {
+		if len(test.scan.Components) != 1 || test.scan.Components[0].Selector != test.selector {
+			t.Errorf("scan %d: components %+v", i, test.scan.Components)
+		}
+		if test.scan.RestartInterval != test.interval {
+			t.Errorf("scan %d: restart interval %d, want %d", i, test.scan.RestartInterval, test.interval)
+		}
+		if test.scan.Se != test.se || test.scan.Ah != test.ah {
+			t.Errorf("scan %d: Se %d, Ah %d", i, test.scan.Se, test.scan.Ah)
+		}
+		if got := data[test.scan.DataStart:test.scan.DataEnd]; !bytes.Equal(got, testScanData) {
+			t.Errorf("scan %d: data % X, want % X", i, got, testScanData)
+		}
+	}
+	if scans[1].Components[0].DCTable != 1 || scans[1].Components[0].ACTable != 1 {
+		t.Errorf("scan 1: tables %+v", scans[1].Components[0])
+	}
+
+	interval, err := getJPEGRestartInterval(segments)
+	if err != nil || interval != 4 {
+		t.Errorf("restart interval of the first scan = %d, %v, want 4", interval, err)
+	}
+}
+
+func TestGetJPEGRestartIntervalInvalid(t *testing.T) {
+	tests := []struct {
+		name       string
+		jpegHeader []segment
+		want       uint16
+		wantErr    bool
+	}{
+		{"no DRI", []segment{newTestSegment(0xDA, testSOS)}, 0, false},
+		{"DRI", []segment{newTestSegment(0xDD, []byte{0x01, 0x00}), newTestSegment(0xDA, testSOS)}, 256, false},
+		{"DRI too short", []segment{newTestSegment(0xDD, []byte{0x01})}, 0, true},
+	}
+	for _, test := range tests {
+		interval, err := getJPEGRestartInterval(test.jpegHeader)
+		if interval != test.want || (err != nil) != test.wantErr {
+			t.Errorf("%s: interval %d, error %v", test.name, interval, err)
+		}
+		if _, err := getJPEGScans(test.jpegHeader); (err != nil) != test.wantErr {
+			t.Errorf("%s: getJPEGScans error %v", test.name, err)
+		}
+	}
+}
+
+func TestDecodeHuffmanTables(t *testing.T) {
+	data := []byte{0x10, 0, 2, 1}
+	data = append(data, make([]byte, 13)...)
+	data = append(data, 0x01, 0x02, 0x03)
+	tables, err := decodeHuffmanTables(newTestSegment(0xC4, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 1 || tables[0].Class != 1 || tables[0].ID != 0 || !bytes.Equal(tables[0].Symbols, []byte{1, 2, 3}) {
+		t.Errorf("tables = %+v", tables)
+	}
+	for _, size := range []int{10, 19} {
+		if _, err := decodeHuffmanTables(newTestSegment(0xC4, data[:size])); err == nil {
+			t.Errorf("no error for DHT data truncated to %d bytes", size)
+		}
+	}
+}
+
+func TestDecodeScanHeaderInvalid(t *testing.T) {
+	for name, seg := range map[string]segment{
+		"not SOS":            newTestSegment(0xDB, testSOS),
+		"empty":              newTestSegment(0xDA, nil),
+		"missing components": newTestSegment(0xDA, []byte{0x03, 0x01, 0x00, 0x00, 0x3F, 0x00}),
+	} {
+		if _, err := decodeScanHeader(seg); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
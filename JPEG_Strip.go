@@ -0,0 +1,436 @@
+package EXIF
+
+import (
+	"bytes"
+	"encoding/binary"
+	"regexp"
+)
+
+// stripAction says what happens to one kind of metadata when stripping
+type stripAction int
+
+const (
+	stripKeep   stripAction = iota // Keep the metadata as it is
+	stripDrop                      // Remove the metadata completely
+	stripFilter                    // Keep the metadata, but remove the parts the policy asks for
+)
+
+/******************************************************************************
+*
+* Type:         stripPolicy
+*
+* Description:  Declares which metadata stripJPEGMetadata keeps and which it
+*               removes. JFIF, JFXX, Adobe APP14 and MPF segments are always
+*               kept, as they affect how the image is decoded, and so are
+*               the non-APP segments.
+*
+******************************************************************************/
+
+type stripPolicy struct {
+	exif     stripAction // APP1 Exif, filtering applies dropGPS, dropMakerNote and dropSerialNumbers
+	xmp      stripAction // APP1 XMP, filtering applies dropXMPHistory, dropGPS and dropSerialNumbers
+	iptc     stripAction // APP13 Photoshop IRB, filtering keeps only the iptcKeep datasets
+	icc      stripAction // APP2 ICC profile, filtering keeps it
+	comments stripAction // COM segments, filtering keeps them
+	other    stripAction // Any other APP segment, filtering keeps them
+
+	dropGPS           bool // Remove the Exif GPS IFD and the XMP exif:GPS properties
+	dropMakerNote     bool // Remove the Exif MakerNote
+	dropSerialNumbers bool // Remove camera and lens serial numbers from Exif and XMP
+	dropXMPHistory    bool // Remove the XMP editing history and document ancestry
+
+	iptcKeep []uint16 // IPTC datasets kept when filtering, as record * 256 + dataset
+}
+
+// publicWebStripPolicy removes everything that should not be published with
+// an image, while keeping its colours and its copyright
+var publicWebStripPolicy = stripPolicy{
+	exif:              stripFilter,
+	xmp:               stripFilter,
+	iptc:              stripFilter,
+	icc:               stripKeep,
+	comments:          stripDrop,
+	other:             stripDrop,
+	dropGPS:           true,
+	dropMakerNote:     true,
+	dropSerialNumbers: true,
+	dropXMPHistory:    true,
+	iptcKeep: []uint16{
+		1*256 + 90,  // Coded Character Set
+		2*256 + 0,   // Record Version
+		2*256 + 80,  // By-Line (Author)
+		2*256 + 110, // Credit
+		2*256 + 115, // Source
+		2*256 + 116, // Copyright Notice
+	},
+}
+
+// aTIFFTypeSizes holds the size in bytes of one value of each TIFF data type,
+// indexed by the type number
+var aTIFFTypeSizes = []byte{
+	0, // None
+	1, // Unsigned Byte
+	1, // ASCII String
+	2, // Unsigned Short
+	4, // Unsigned Long
+	8, // Unsigned Rational
+	1, // Signed Byte
+	1, // Undefined
+	2, // Signed Short
+	4, // Signed Long
+	8, // Signed Rational
+	4, // Float
+	8, // Double
+}
+
+var xmpIdent = []byte("http://ns.adobe.com/xap/1.0/\x00")
+var xmpExtensionIdent = []byte("http://ns.adobe.com/xmp/extension/\x00")
+
+/******************************************************************************
+*
+* Function:     stripJPEGFile
+*
+* Description:  Removes metadata from a JPEG file according to a policy. The
+*               header segments are filtered and written with the image data
+*               of the old file, the entropy-coded data is not touched.
+*
+* Parameters:   oldFilename - the JPEG file to strip
+*               newFilename - the name of the new JPEG to create (can be same as oldFilename)
+*               policy - which metadata to keep and which to remove
+*
+* Returns:      nil - on Success
+*               error - on Failure
+*
+******************************************************************************/
+
+func stripJPEGFile(oldFilename string, newFilename string, policy stripPolicy) error {
+	jpegHeader, err := getJPEGHeaderData(oldFilename)
+	if err != nil {
+		return err
+	}
+	jpegHeader, err = stripJPEGMetadata(jpegHeader, policy)
+	if err != nil {
+		return err
+	}
+	// Stripping should not add anything, so no padding is reserved
+	return putJPEGHeaderDataSafe(oldFilename, newFilename, jpegHeader, jpegWriteOptions{preserveMode: true})
+}
+
+/******************************************************************************
+*
+* Function:     stripJPEGMetadata
+*
+* Description:  Removes metadata from JPEG header data according to a policy
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*               policy - which metadata to keep and which to remove
+*
+* Returns:      jpegHeader - the JPEG header data with the metadata removed
+*               error - if a segment that had to be filtered was invalid
+*
+******************************************************************************/
+
+func stripJPEGMetadata(jpegHeader []segment, policy stripPolicy) ([]segment, error) {
+	// The Photoshop IRB can be spread over several APP13 segments, so it is
+	// filtered as a whole and put where its first segment was
+	var irbSegments []segment
+	if policy.iptc == stripFilter {
+		if resources, err := getPhotoshopIRB(jpegHeader); err == nil {
+			resources, err = filterPhotoshopIRB(resources, policy)
+			if err != nil {
+				return jpegHeader, err
+			}
+			irbSegments = newPhotoshopIRBSegments(resources)
+		}
+	}
+	irbDone := false
+
+	newHeader := []segment{}
+	for _, seg := range jpegHeader {
+		var err error
+		action := stripKeep
+
+		switch {
+		case seg.segType == 0xE1 && isEXIFSegment(seg.segData):
+			action = policy.exif
+			if action == stripFilter {
+				seg.segData, err = filterEXIF(seg.segData, policy)
+			}
+
+		case seg.segType == 0xE1 && bytes.HasPrefix(seg.segData, xmpIdent):
+			action = policy.xmp
+			if action == stripFilter {
+				seg.segData = filterXMP(seg.segData, policy)
+			}
+
+		case seg.segType == 0xE1 && bytes.HasPrefix(seg.segData, xmpExtensionIdent):
+			// Extended XMP can't be filtered on its own, its parts are tied to
+			// the main packet by a digest
+			action = policy.xmp
+			if action == stripFilter {
+				action = stripDrop
+			}
+
+		case isPhotoshopIRBSegment(seg):
+			action = policy.iptc
+			if action == stripFilter {
+				if !irbDone {
+					newHeader = append(newHeader, irbSegments...)
+					irbDone = true
+				}
+				action = stripDrop
+			}
+
+		case seg.segType == 0xE2 && bytes.HasPrefix(seg.segData, iccIdent):
+			action = policy.icc
+
+		case seg.segType == 0xFE:
+			action = policy.comments
+
+		case seg.segType == 0xE0 && (bytes.HasPrefix(seg.segData, jfifIdent) || bytes.HasPrefix(seg.segData, jfxxIdent)),
+			seg.segType == 0xEE && bytes.HasPrefix(seg.segData, adobeIdent),
+			seg.segType == 0xE2 && bytes.HasPrefix(seg.segData, mpfIdent),
+			isJPEGPadding(seg):
+			action = stripKeep
+
+		case seg.segType >= 0xE0 && seg.segType <= 0xEF:
+			action = policy.other
+		}
+
+		if err != nil {
+			return jpegHeader, err
+		}
+		if action != stripDrop {
+			newHeader = append(newHeader, seg)
+		}
+	}
+	return newHeader, nil
+}
+
+// isEXIFSegment checks for the EXIF label of an APP1 segment. For some
+// reason, some files have a faulty EXIF name which has a 0xFF in it.
+func isEXIFSegment(segData []byte) bool {
+	return bytes.HasPrefix(segData, []byte("Exif\x00\x00")) || bytes.HasPrefix(segData, []byte("Exif\x00\xFF"))
+}
+
+// filterEXIF removes the GPS IFD, the MakerNote and serial numbers from the
+// data of an APP1 Exif segment. Removed values are overwritten with zeros and
+// their IFD entries are taken out, so no offsets in the TIFF data move.
+func filterEXIF(segData []byte, policy stripPolicy) ([]byte, error) {
+	segData = append([]byte{}, segData...)
+	tiff := segData[6:]
+	if len(tiff) < 8 {
+		return nil, &jpegError{"Exif segment is too short"}
+	}
+
+	var byteOrder binary.ByteOrder
+	switch string(tiff[0:2]) {
+	case "II":
+		byteOrder = binary.LittleEndian
+	case "MM":
+		byteOrder = binary.BigEndian
+	default:
+		return nil, &jpegError{"Exif segment has an invalid TIFF header"}
+	}
+	ifd0 := byteOrder.Uint32(tiff[4:8])
+
+	if policy.dropGPS {
+		if entry, ok := tiffFindTag(tiff, byteOrder, ifd0, 0x8825); ok {
+			tiffZeroIFD(tiff, byteOrder, byteOrder.Uint32(tiff[entry+8:]))
+			tiffRemoveTag(tiff, byteOrder, ifd0, 0x8825)
+		}
+	}
+
+	if policy.dropSerialNumbers {
+		tiffRemoveTag(tiff, byteOrder, ifd0, 0xC62F) // CameraSerialNumber
+	}
+
+	if entry, ok := tiffFindTag(tiff, byteOrder, ifd0, 0x8769); ok {
+		exifIFD := byteOrder.Uint32(tiff[entry+8:])
+		if policy.dropMakerNote {
+			tiffRemoveTag(tiff, byteOrder, exifIFD, 0x927C)
+		}
+		if policy.dropSerialNumbers {
+			tiffRemoveTag(tiff, byteOrder, exifIFD, 0xA431) // BodySerialNumber
+			tiffRemoveTag(tiff, byteOrder, exifIFD, 0xA435) // LensSerialNumber
+		}
+	}
+
+	return segData, nil
+}
+
+// tiffFindTag returns the offset of the IFD entry with the given tag
+func tiffFindTag(tiff []byte, byteOrder binary.ByteOrder, ifdOffset uint32, tag uint16) (int, bool) {
+	numEntries, ok := tiffIFDSize(tiff, byteOrder, ifdOffset)
+	if !ok {
+		return 0, false
+	}
+	for i := 0; i < numEntries; i++ {
+		entry := int(ifdOffset) + 2 + 12*i
+		if byteOrder.Uint16(tiff[entry:]) == tag {
+			return entry, true
+		}
+	}
+	return 0, false
+}
+
+// tiffRemoveTag overwrites the value of a tag with zeros, and takes its
+// entry out of the IFD by moving the following entries and the next IFD
+// offset up
+func tiffRemoveTag(tiff []byte, byteOrder binary.ByteOrder, ifdOffset uint32, tag uint16) bool {
+	entry, ok := tiffFindTag(tiff, byteOrder, ifdOffset, tag)
+	if !ok {
+		return false
+	}
+	tiffZeroValue(tiff, byteOrder, entry)
+
+	numEntries, _ := tiffIFDSize(tiff, byteOrder, ifdOffset)
+	end := int(ifdOffset) + 2 + 12*numEntries + 4
+	copy(tiff[entry:end-12], tiff[entry+12:end])
+	for i := end - 12; i < end; i++ {
+		tiff[i] = 0
+	}
+	byteOrder.PutUint16(tiff[ifdOffset:], uint16(numEntries-1))
+	return true
+}
+
+// tiffZeroIFD overwrites an IFD and all the values it points to with zeros
+func tiffZeroIFD(tiff []byte, byteOrder binary.ByteOrder, ifdOffset uint32) {
+	numEntries, ok := tiffIFDSize(tiff, byteOrder, ifdOffset)
+	if !ok {
+		return
+	}
+	for i := 0; i < numEntries; i++ {
+		tiffZeroValue(tiff, byteOrder, int(ifdOffset)+2+12*i)
+	}
+	end := int(ifdOffset) + 2 + 12*numEntries + 4
+	for i := int(ifdOffset); i < end; i++ {
+		tiff[i] = 0
+	}
+}
+
+// tiffZeroValue overwrites the value of an IFD entry with zeros, whether it
+// is stored in the entry or elsewhere
+func tiffZeroValue(tiff []byte, byteOrder binary.ByteOrder, entry int) {
+	dataType := byteOrder.Uint16(tiff[entry+2:])
+	count := uint64(byteOrder.Uint32(tiff[entry+4:]))
+
+	size := count
+	if int(dataType) < len(aTIFFTypeSizes) {
+		size *= uint64(aTIFFTypeSizes[dataType])
+	}
+
+	if size > 4 {
+		offset := uint64(byteOrder.Uint32(tiff[entry+8:]))
+		if offset+size <= uint64(len(tiff)) {
+			for i := offset; i < offset+size; i++ {
+				tiff[i] = 0
+			}
+		}
+	}
+	for i := entry + 8; i < entry+12; i++ {
+		tiff[i] = 0
+	}
+}
+
+// tiffIFDSize returns the number of entries of an IFD, checking that the
+// IFD and its next IFD offset lie within the TIFF data
+func tiffIFDSize(tiff []byte, byteOrder binary.ByteOrder, ifdOffset uint32) (int, bool) {
+	if ifdOffset == 0 || uint64(ifdOffset)+2 > uint64(len(tiff)) {
+		return 0, false
+	}
+	numEntries := int(byteOrder.Uint16(tiff[ifdOffset:]))
+	if uint64(ifdOffset)+2+12*uint64(numEntries)+4 > uint64(len(tiff)) {
+		return 0, false
+	}
+	return numEntries, true
+}
+
+// filterXMP removes editing history, GPS and serial number properties from
+// the data of an APP1 XMP segment. Properties are recognised by their usual
+// namespace prefixes.
+func filterXMP(segData []byte, policy stripPolicy) []byte {
+	names := []string{}
+	if policy.dropXMPHistory {
+		names = append(names, `xmpMM:History`, `xmpMM:DerivedFrom`, `xmpMM:Ingredients`, `xmpMM:Pantry`,
+			`photoshop:History`, `photoshop:DocumentAncestors`)
+	}
+	if policy.dropGPS {
+		names = append(names, `exif:GPS\w*`)
+	}
+	if policy.dropSerialNumbers {
+		names = append(names, `aux:SerialNumber`, `aux:LensSerialNumber`, `exifEX:BodySerialNumber`, `exifEX:LensSerialNumber`)
+	}
+
+	packet := append([]byte{}, segData[len(xmpIdent):]...)
+	for _, name := range names {
+		packet = removeXMPProperty(packet, name)
+	}
+	return append(append([]byte{}, xmpIdent...), packet...)
+}
+
+// removeXMPProperty removes all the properties whose name matches the
+// pattern, both in element form and in attribute form
+func removeXMPProperty(packet []byte, name string) []byte {
+	attribute := regexp.MustCompile(`\s(?:` + name + `)\s*=\s*(?:"[^"]*"|'[^']*')`)
+	packet = attribute.ReplaceAll(packet, nil)
+
+	element := regexp.MustCompile(`<(` + name + `)(?:\s[^>]*?)?(/?)>`)
+	for {
+		m := element.FindSubmatchIndex(packet)
+		if m == nil {
+			return packet
+		}
+
+		end := m[1]
+		if m[4] == m[5] {
+			// Not self-closing, remove up to the end tag
+			closeTag := []byte("</" + string(packet[m[2]:m[3]]) + ">")
+			i := bytes.Index(packet[end:], closeTag)
+			if i < 0 {
+				return packet
+			}
+			end += i + len(closeTag)
+		}
+		packet = append(packet[:m[0]], packet[end:]...)
+	}
+}
+
+// filterPhotoshopIRB keeps only the policy's IPTC datasets in the IPTC-NAA
+// resource and its digest, along with the resolution information
+func filterPhotoshopIRB(resources []irbResource, policy stripPolicy) ([]irbResource, error) {
+	keep := map[uint16]bool{}
+	for _, dataset := range policy.iptcKeep {
+		keep[dataset] = true
+	}
+
+	newResources := []irbResource{}
+	for _, resource := range resources {
+		switch resource.resID {
+		case 0x03ED: // ResolutionInfo
+			newResources = append(newResources, resource)
+
+		case 0x0404: // IPTC-NAA record
+			records := []iptcRecord{}
+			hasContent := false
+			for _, record := range getIPTC(bytes.NewReader(resource.resData)) {
+				if keep[uint16(record.recRecordNumber)*256+uint16(record.recDataSetNumber)] {
+					records = append(records, record)
+					hasContent = hasContent || record.recDataSetNumber != 0
+				}
+			}
+
+			// A record version on its own is not worth keeping
+			if !hasContent {
+				continue
+			}
+			iptcData, ok := putIPTC(records)
+			if !ok {
+				return nil, &jpegError{"Couldn't encode IPTC records"}
+			}
+			newResources = setIPTCResources(newResources, iptcData)
+		}
+	}
+	return newResources, nil
+}
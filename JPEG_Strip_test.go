@@ -0,0 +1,224 @@
+package EXIF
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestExifSegment returns an APP1 Exif segment with a Model in IFD0, an
+// Exif IFD with a MakerNote and a BodySerialNumber, and a GPS IFD
+func newTestExifSegment() segment {
+	be := binary.BigEndian
+	tiff := []byte("MM\x00*\x00\x00\x00\x08")
+	entry := func(tag uint16, dataType uint16, count uint32, value []byte) {
+		tiff = be.AppendUint16(tiff, tag)
+		tiff = be.AppendUint16(tiff, dataType)
+		tiff = be.AppendUint32(tiff, count)
+		tiff = append(tiff, value...)
+	}
+
+	// IFD0 at 8
+	tiff = be.AppendUint16(tiff, 3)
+	entry(0x0110, 2, 4, []byte("Cam\x00"))
+	entry(0x8769, 4, 1, be.AppendUint32(nil, 50))
+	entry(0x8825, 4, 1, be.AppendUint32(nil, 80))
+	tiff = be.AppendUint32(tiff, 0)
+
+	// Exif IFD at 50
+	tiff = be.AppendUint16(tiff, 2)
+	entry(0x927C, 7, 8, be.AppendUint32(nil, 98))
+	entry(0xA431, 2, 8, be.AppendUint32(nil, 106))
+	tiff = be.AppendUint32(tiff, 0)
+
+	// GPS IFD at 80
+	tiff = be.AppendUint16(tiff, 1)
+	entry(0x0001, 2, 2, []byte("N\x00\x00\x00"))
+	tiff = be.AppendUint32(tiff, 0)
+
+	// Values at 98 and 106
+	tiff = append(tiff, "MAKERNTESN12345\x00"...)
+
+	return newTestSegment(0xE1, append([]byte("Exif\x00\x00"), tiff...))
+}
+
+// newTestXMPSegment returns an APP1 XMP segment holding properties
+func newTestXMPSegment(properties string) segment {
+	packet := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF><rdf:Description rdf:about=""` + properties + `</rdf:Description></rdf:RDF></x:xmpmeta>`
+	return newTestSegment(0xE1, append(append([]byte{}, xmpIdent...), packet...))
+}
+
+// newTestStripHeader returns header data with every kind of metadata
+func newTestStripHeader(t *testing.T) []segment {
+	t.Helper()
+	iptcData, ok := putIPTC([]iptcRecord{
+		newTestIPTCRecord(1, 90, "\x1b%G"),
+		newTestIPTCRecord(2, 0, "\x00\x04"),
+		newTestIPTCRecord(2, 25, "keyword"),
+		newTestIPTCRecord(2, 80, "Photographer"),
+		newTestIPTCRecord(2, 116, "© Photographer"),
+		newTestIPTCRecord(2, 120, "Caption"),
+	})
+	if !ok {
+		t.Fatal("couldn't encode IPTC records")
+	}
+	resources := setIPTCResources([]irbResource{
+		{resID: 0x03ED, resData: make([]byte, 16)},
+		{resID: 0x040C, resData: []byte("thumbnail")},
+	}, iptcData)
+
+	jpegHeader := []segment{
+		newTestSegment(0xE0, []byte("JFIF\x00\x01\x02\x00\x00\x01\x00\x01\x00\x00")),
+		newTestExifSegment(),
+		newTestXMPSegment(` exif:GPSLatitude="52,1N" dc:format="image/jpeg">` +
+			`<xmpMM:History><rdf:Seq><rdf:li>saved</rdf:li></rdf:Seq></xmpMM:History><aux:SerialNumber>123</aux:SerialNumber>`),
+		newTestSegment(0xE2, append(append([]byte{}, iccIdent...), 1, 1, 'p')),
+		newTestSegment(0xE3, []byte("vendor")),
+	}
+	jpegHeader = append(jpegHeader, newPhotoshopIRBSegments(resources)...)
+	jpegHeader = append(jpegHeader, newTestSegment(0xFE, []byte("comment")), newTestSegment(0xDB, make([]byte, 65)), newTestSegment(0xDA, testSOS))
+	return reserveJPEGPadding(jpegHeader, 100)
+}
+
+func TestStripJPEGMetadataPublicWeb(t *testing.T) {
+	jpegHeader := newTestStripHeader(t)
+	stripped, err := stripJPEGMetadata(jpegHeader, publicWebStripPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// COM and the unknown APP3 segment are dropped, the rest is kept or filtered
+	if want := []byte{0xE0, 0xE1, 0xE1, 0xE2, 0xED, 0xEF, 0xDB, 0xDA}; !bytes.Equal(segmentTypes(stripped), want) {
+		t.Fatalf("segment types % X, want % X", segmentTypes(stripped), want)
+	}
+
+	// Exif: nothing moves, the GPS IFD, the MakerNote and the serial number are gone
+	exif := stripped[1].segData
+	if len(exif) != len(jpegHeader[1].segData) {
+		t.Errorf("Exif segment changed size from %d to %d", len(jpegHeader[1].segData), len(exif))
+	}
+	tiff := exif[6:]
+	be := binary.BigEndian
+	if _, ok := tiffFindTag(tiff, be, 8, 0x8825); ok {
+		t.Error("the GPS IFD pointer is still there")
+	}
+	if _, ok := tiffFindTag(tiff, be, 8, 0x0110); !ok {
+		t.Error("the Model was removed")
+	}
+	if n, _ := tiffIFDSize(tiff, be, 50); n != 0 {
+		t.Errorf("%d entries left in the Exif IFD", n)
+	}
+	if !bytes.Equal(tiff[80:], make([]byte, len(tiff)-80)) {
+		t.Errorf("GPS, MakerNote and serial number data not zeroed: % X", tiff[80:])
+	}
+
+	// XMP
+	xmp := string(stripped[2].segData)
+	for _, removed := range []string{"GPSLatitude", "xmpMM:History", "SerialNumber"} {
+		if strings.Contains(xmp, removed) {
+			t.Errorf("XMP still holds %s", removed)
+		}
+	}
+	if !strings.Contains(xmp, `dc:format="image/jpeg"`) {
+		t.Error("XMP lost dc:format")
+	}
+
+	// IPTC: only the policy's datasets, with the resolution information
+	resources, err := getPhotoshopIRB(stripped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resIDs := []uint16{}
+	for _, resource := range resources {
+		resIDs = append(resIDs, resource.resID)
+	}
+	if !reflect.DeepEqual(resIDs, []uint16{0x03ED, 0x0404, 0x0425}) {
+		t.Errorf("resources %04X", resIDs)
+	}
+	iptcRecords, err := getJPEGIPTC(stripped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	datasets := []string{}
+	for _, record := range iptcRecords {
+		datasets = append(datasets, record.recType)
+	}
+	if want := []string{"1:90", "2:00", "2:80", "2:116"}; !reflect.DeepEqual(datasets, want) {
+		t.Errorf("IPTC datasets %v, want %v", datasets, want)
+	}
+
+	// The kept Coded Character Set still says how to read the copyright
+	if got := iptcRecords[len(iptcRecords)-1].recText; got != "© Photographer" {
+		t.Errorf("copyright %q, want %q", got, "© Photographer")
+	}
+}
+
+func TestStripJPEGMetadataActions(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy stripPolicy
+		want   []byte
+	}{
+		{"keep everything", stripPolicy{}, []byte{0xE0, 0xE1, 0xE1, 0xE2, 0xE3, 0xED, 0xFE, 0xEF, 0xDB, 0xDA}},
+		{"drop everything", stripPolicy{exif: stripDrop, xmp: stripDrop, iptc: stripDrop, icc: stripDrop, comments: stripDrop, other: stripDrop},
+			[]byte{0xE0, 0xEF, 0xDB, 0xDA}},
+		{"filter without IPTC datasets to keep", stripPolicy{iptc: stripFilter}, []byte{0xE0, 0xE1, 0xE1, 0xE2, 0xE3, 0xED, 0xFE, 0xEF, 0xDB, 0xDA}},
+	}
+	for _, test := range tests {
+		stripped, err := stripJPEGMetadata(newTestStripHeader(t), test.policy)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !bytes.Equal(segmentTypes(stripped), test.want) {
+			t.Errorf("%s: segment types % X, want % X", test.name, segmentTypes(stripped), test.want)
+		}
+	}
+
+	// Filtering keeps the resolution information even when there are no IPTC datasets left
+	stripped, _ := stripJPEGMetadata(newTestStripHeader(t), stripPolicy{iptc: stripFilter})
+	if resources, err := getPhotoshopIRB(stripped); err != nil || len(resources) != 1 || resources[0].resID != 0x03ED {
+		t.Errorf("resources %v, %v, want only the resolution information", resources, err)
+	}
+
+	corrupt := []segment{newTestSegment(0xE1, []byte("Exif\x00\x00XX\x00*\x00\x00\x00\x08")), newTestSegment(0xDA, testSOS)}
+	if _, err := stripJPEGMetadata(corrupt, publicWebStripPolicy); err == nil {
+		t.Error("no error for a corrupt Exif segment")
+	}
+}
+
+func TestStripJPEGFile(t *testing.T) {
+	trailer := []byte("trailer")
+	jpegHeader := newTestStripHeader(t)
+	data := append(newTestJPEG(t, jpegHeader[:len(jpegHeader)-1]...), trailer...)
+	oldFilename := writeTestFile(t, "old.jpg", data)
+	newFilename := oldFilename + ".stripped.jpg"
+
+	if err := stripJPEGFile(oldFilename, newFilename, publicWebStripPolicy); err != nil {
+		t.Fatal(err)
+	}
+	jpegHeader, err := getJPEGHeaderData(newFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(getJPEGComments(jpegHeader)) != 0 {
+		t.Error("the comment was not stripped")
+	}
+	if got, err := getJPEGTrailer(newFilename); err != nil || !bytes.Equal(got.data, trailer) {
+		t.Errorf("trailer = %q, %v", got.data, err)
+	}
+
+	// Stripping doesn't reserve padding in a file that had none
+	jpegHeader = reserveJPEGPadding(newTestStripHeader(t), 0)
+	oldFilename = writeTestFile(t, "unpadded.jpg", newTestJPEG(t, jpegHeader[:len(jpegHeader)-1]...))
+	if err := stripJPEGFile(oldFilename, newFilename, publicWebStripPolicy); err != nil {
+		t.Fatal(err)
+	}
+	if jpegHeader, err = getJPEGHeaderData(newFilename); err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := paddingSize(jpegHeader); size != 0 {
+		t.Errorf("%d bytes of padding after stripping", size)
+	}
+}
@@ -0,0 +1,432 @@
+package EXIF
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DiagnosticSeverity tells how serious a problem found by the validator is
+type DiagnosticSeverity int
+
+const (
+	SeverityInfo    DiagnosticSeverity = iota // Not a problem, but worth knowing
+	SeverityWarning                           // Most readers cope with it
+	SeverityError                             // The file is damaged or can't be decoded correctly
+)
+
+func (s DiagnosticSeverity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return fmt.Sprintf("severity %d", int(s))
+}
+
+/******************************************************************************
+*
+* Type:         JPEGDiagnostic
+*
+* Description:  One problem found by validateJPEG, with the absolute offset
+*               in the file where it was found
+*
+******************************************************************************/
+
+type JPEGDiagnostic struct {
+	Offset   uint64
+	Severity DiagnosticSeverity
+	Message  string
+}
+
+func (d JPEGDiagnostic) String() string {
+	return fmt.Sprintf("offset %d (0x%X): %s: %s", d.Offset, d.Offset, d.Severity, d.Message)
+}
+
+/******************************************************************************
+*
+* Function:     validateJPEGFile
+*
+* Description:  Walks the whole structure of a JPEG file, including the scan
+*               data, and reports every problem found
+*
+* Parameters:   filename - the name of the JPEG file to validate
+*
+* Returns:      diagnostics - the problems found, in file order
+*               error - if the file could not be opened
+*
+******************************************************************************/
+
+func validateJPEGFile(filename string) ([]JPEGDiagnostic, error) {
+	fi, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+
+	info, err := fi.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return validateJPEG(fi, info.Size()), nil
+}
+
+/******************************************************************************
+*
+* Function:     validateJPEG
+*
+* Description:  Walks the whole structure of a JPEG, including the scan data,
+*               and reports every problem found. Unlike getJPEGHeaderData it
+*               doesn't stop at the first problem, it resynchronises on the
+*               next marker and carries on where it can.
+*
+* Parameters:   reader - the JPEG data
+*               size - the size of the JPEG data
+*
+* Returns:      diagnostics - the problems found, in file order
+*
+******************************************************************************/
+
+func validateJPEG(reader io.ReaderAt, size int64) []JPEGDiagnostic {
+	v := &jpegValidator{reader: reader, size: uint64(size), reported: map[string]bool{}}
+	v.run()
+	return v.diagnostics
+}
+
+// hasJPEGErrors checks if any of the diagnostics is an error
+func hasJPEGErrors(diagnostics []JPEGDiagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// jpegValidator holds the state of validateJPEG while it walks a file
+type jpegValidator struct {
+	reader      io.ReaderAt
+	size        uint64
+	diagnostics []JPEGDiagnostic
+
+	segments   int // Number of segments seen so far
+	frame      *FrameHeader
+	scans      int
+	quant      [4]bool    // Quantization tables defined so far
+	huffman    [2][4]bool // Huffman tables defined so far, by class and ID
+	dhtSeen    bool
+	lastMarker byte
+	reported   map[string]bool // Messages that are only reported once
+}
+
+func (v *jpegValidator) report(offset uint64, severity DiagnosticSeverity, format string, args ...interface{}) {
+	v.diagnostics = append(v.diagnostics, JPEGDiagnostic{offset, severity, fmt.Sprintf(format, args...)})
+}
+
+// reportOnce reports a problem only the first time it is seen
+func (v *jpegValidator) reportOnce(offset uint64, severity DiagnosticSeverity, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if !v.reported[message] {
+		v.reported[message] = true
+		v.report(offset, severity, "%s", message)
+	}
+}
+
+func (v *jpegValidator) run() {
+	data := make([]byte, 2)
+	if v.size < 2 {
+		v.report(0, SeverityError, "File is too short to be a JPEG")
+		return
+	}
+	if _, err := v.reader.ReadAt(data, 0); err != nil || data[0] != 0xFF || data[1] != 0xD8 {
+		v.report(0, SeverityError, "File doesn't start with a SOI marker")
+		return
+	}
+
+	offset := uint64(2)
+	for {
+		if offset >= v.size {
+			v.report(v.size, SeverityError, "Missing EOI marker, the file ends after %s", v.markerName(v.lastMarker))
+			return
+		}
+
+		// Read the marker, skipping any 0xFF fill bytes
+		if err := v.readAt(data[:1], offset); err != nil || data[0] != 0xFF {
+			next, ok := findNextJPEGMarker(v.reader, offset, v.size)
+			if !ok {
+				v.report(offset, SeverityError, "Expected a marker, found 0x%02X and no further markers", data[0])
+				return
+			}
+			v.report(offset, SeverityError, "Expected a marker, found 0x%02X, skipped %d bytes to the next marker", data[0], next-offset)
+			offset = next
+			continue
+		}
+		markerOffset := offset
+		offset++
+		for {
+			if err := v.readAt(data[1:], offset); err != nil {
+				v.report(offset, SeverityError, "File ends in the middle of a marker")
+				return
+			}
+			offset++
+			if data[1] != 0xFF {
+				break
+			}
+			markerOffset = offset - 1
+		}
+		marker := data[1]
+
+		switch {
+		case marker == 0xD9:
+			v.checkEOI(markerOffset, offset)
+			return
+
+		case marker == 0xD8:
+			v.report(markerOffset, SeverityError, "Duplicate SOI marker")
+			continue
+
+		case marker >= 0xD0 && marker <= 0xD7:
+			v.report(markerOffset, SeverityWarning, "%s marker outside of scan data", v.markerName(marker))
+			continue
+
+		case marker == 0x00:
+			v.report(markerOffset, SeverityError, "Stuffed zero byte outside of scan data")
+			continue
+
+		case marker == 0x01:
+			continue
+
+		case marker < 0xC0 || marker == 0xC8 || (marker >= 0xF0 && marker <= 0xFD):
+			v.report(markerOffset, SeverityWarning, "Reserved marker 0x%02X", marker)
+		}
+
+		// Read the size, which is big endian and includes the two size bytes
+		if err := v.readAt(data, offset); err != nil {
+			v.report(offset, SeverityError, "File ends in the length of a %s segment", v.markerName(marker))
+			return
+		}
+		length := uint64(binary.BigEndian.Uint16(data))
+		if length < 2 {
+			v.report(offset, SeverityError, "%s segment has an invalid length of %d", v.markerName(marker), length)
+			next, ok := findNextJPEGMarker(v.reader, offset, v.size)
+			if !ok {
+				return
+			}
+			offset = next
+			continue
+		}
+		if offset+length > v.size {
+			v.report(offset, SeverityError, "%s segment length of %d exceeds the file size by %d bytes",
+				v.markerName(marker), length, offset+length-v.size)
+			return
+		}
+
+		seg := segment{
+			segType:      marker,
+			segName:      aJPEGSegmentNames[marker],
+			segDesc:      aJPEGSegmentDescriptions[marker],
+			segDataStart: offset + 2,
+			segData:      make([]byte, length-2),
+		}
+		if err := v.readAt(seg.segData, seg.segDataStart); err != nil {
+			v.report(seg.segDataStart, SeverityError, "%s segment data could not be read", v.markerName(marker))
+			return
+		}
+		offset += length
+
+		if marker == 0xDA {
+			scanDataEnd, err := (&segmentIterator{reader: v.reader}).findScanEnd(offset)
+			if err != nil {
+				v.report(v.size, SeverityError, "Scan data is truncated, the file ends without EOI marker")
+				return
+			}
+			seg.scanDataStart = offset
+			seg.scanDataEnd = scanDataEnd
+			if scanDataEnd == offset {
+				v.report(offset, SeverityWarning, "Scan has no entropy-coded data")
+			}
+			offset = scanDataEnd
+		}
+
+		v.checkSegment(markerOffset, seg)
+		v.segments++
+		v.lastMarker = marker
+	}
+}
+
+// checkSegment checks one segment against what came before it
+func (v *jpegValidator) checkSegment(offset uint64, seg segment) {
+	switch {
+	case seg.segType >= 0xE0 && seg.segType <= 0xEF:
+		if v.scans > 0 {
+			v.report(offset, SeverityWarning, "%s segment after the first scan, most readers will ignore it", seg.segName)
+		} else if v.frame != nil {
+			v.report(offset, SeverityWarning, "%s segment after the frame header, some readers will ignore it", seg.segName)
+		}
+		if seg.segType == 0xE0 && bytes.HasPrefix(seg.segData, jfifIdent) && v.segments > 0 {
+			v.report(offset, SeverityWarning, "JFIF APP0 segment is not the first segment after SOI")
+		}
+		if seg.segType == 0xE1 && isEXIFSegment(seg.segData) && v.segments > 0 &&
+			!(v.segments == 1 && v.lastMarker == 0xE0) {
+			v.report(offset, SeverityWarning, "Exif APP1 segment doesn't directly follow SOI or the JFIF APP0 segment")
+		}
+
+	case isSOFMarker(seg.segType):
+		frame, err := decodeFrameHeader(seg)
+		if err != nil {
+			v.report(offset, SeverityError, "%s", err)
+			return
+		}
+		if v.frame != nil && !frame.Differential {
+			v.report(offset, SeverityError, "Second %s frame header, only one frame is allowed", seg.segName)
+			return
+		}
+		if v.frame == nil {
+			v.frame = frame
+		}
+
+	case seg.segType == 0xDB:
+		tables, err := decodeQuantTables(seg)
+		if err != nil {
+			v.report(offset, SeverityError, "%s", err)
+			return
+		}
+		for _, table := range tables {
+			v.quant[table.ID&3] = true
+		}
+
+	case seg.segType == 0xC4:
+		tables, err := decodeHuffmanTables(seg)
+		if err != nil {
+			v.report(offset, SeverityError, "%s", err)
+			return
+		}
+		v.dhtSeen = true
+		for _, table := range tables {
+			v.huffman[table.Class&1][table.ID&3] = true
+		}
+
+	case seg.segType == 0xDA:
+		v.scans++
+		v.checkScan(offset, seg)
+	}
+}
+
+// checkScan checks that everything a scan refers to has been defined
+func (v *jpegValidator) checkScan(offset uint64, seg segment) {
+	if v.frame == nil {
+		v.report(offset, SeverityError, "SOS segment before any SOF frame header")
+		return
+	}
+	scan, err := decodeScanHeader(seg)
+	if err != nil {
+		v.report(offset, SeverityError, "%s", err)
+		return
+	}
+
+	lossless := v.frame.Process == CodingLossless
+	for _, sc := range scan.Components {
+		var component *FrameComponent
+		for i := range v.frame.Components {
+			if v.frame.Components[i].ID == sc.Selector {
+				component = &v.frame.Components[i]
+			}
+		}
+		if component == nil {
+			v.report(offset, SeverityError, "Scan refers to component %d, which is not in the frame header", sc.Selector)
+			continue
+		}
+
+		if !lossless && !v.quant[component.QuantTable&3] {
+			v.reportOnce(offset, SeverityError, "Quantization table %d of component %d is not defined by a DQT segment",
+				component.QuantTable, component.ID)
+		}
+
+		if v.frame.Arithmetic {
+			continue
+		}
+		if !v.dhtSeen {
+			v.reportOnce(offset, SeverityWarning, "No DHT segment before the scan, the standard Huffman tables are implied")
+			continue
+		}
+		if (lossless || scan.Ss == 0) && !v.huffman[0][sc.DCTable&3] {
+			v.reportOnce(offset, SeverityError, "DC Huffman table %d of component %d is not defined by a DHT segment",
+				sc.DCTable, sc.Selector)
+		}
+		if !lossless && scan.Se > 0 && !v.huffman[1][sc.ACTable&3] {
+			v.reportOnce(offset, SeverityError, "AC Huffman table %d of component %d is not defined by a DHT segment",
+				sc.ACTable, sc.Selector)
+		}
+	}
+}
+
+// checkEOI checks what is missing when the EOI marker is reached
+func (v *jpegValidator) checkEOI(markerOffset uint64, offset uint64) {
+	if v.frame == nil {
+		v.report(markerOffset, SeverityError, "EOI marker without a SOF frame header")
+	}
+	if v.scans == 0 {
+		v.report(markerOffset, SeverityError, "EOI marker without any scan")
+	}
+	if offset < v.size {
+		v.report(offset, SeverityInfo, "%d bytes of trailing data after the EOI marker", v.size-offset)
+	}
+}
+
+func (v *jpegValidator) readAt(data []byte, offset uint64) error {
+	n, err := v.reader.ReadAt(data, int64(offset))
+	if n < len(data) {
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+func (v *jpegValidator) markerName(marker byte) string {
+	if name, ok := aJPEGSegmentNames[marker]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02X", marker)
+}
+
+/******************************************************************************
+*
+* Function:     findNextJPEGMarker
+*
+* Description:  Looks for the next marker that can start a segment, to get
+*               back in step after corrupted data. Restart markers, stuffed
+*               zero bytes and fill bytes are not considered.
+*
+* Parameters:   reader - the JPEG data
+*               offset - the offset to start looking from
+*               size - the size of the JPEG data
+*
+* Returns:      offset - the offset of the 0xFF of the marker found
+*               found - false if there are no more markers
+*
+******************************************************************************/
+
+func findNextJPEGMarker(reader io.ReaderAt, offset uint64, size uint64) (uint64, bool) {
+	buffer := make([]byte, 64*1024)
+	for offset+1 < size {
+		n, _ := reader.ReadAt(buffer, int64(offset))
+		if n < 2 {
+			return 0, false
+		}
+		for i := 0; i < n-1; i++ {
+			b := buffer[i+1]
+			if buffer[i] == 0xFF && b >= 0xC0 && b != 0xFF && (b < 0xD0 || b > 0xD7) {
+				return offset + uint64(i), true
+			}
+		}
+		offset += uint64(n - 1)
+	}
+	return 0, false
+}
@@ -0,0 +1,92 @@
+package EXIF
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// newTestDHT returns the data of a DHT segment defining DC and AC table 0,
+// each with a single one bit code
+func newTestDHT() []byte {
+	data := []byte{}
+	for _, class := range []byte{0x00, 0x10} {
+		counts := make([]byte, 16)
+		counts[0] = 1
+		data = append(append(append(data, class), counts...), 0x00)
+	}
+	return data
+}
+
+// newTestValidHeader returns the header segments of a complete greyscale JPEG
+func newTestValidHeader() []segment {
+	return []segment{
+		newTestSegment(0xE0, []byte("JFIF\x00\x01\x02\x00\x00\x01\x00\x01\x00\x00")),
+		newTestSegment(0xDB, append([]byte{0x00}, bytes.Repeat([]byte{1}, 64)...)),
+		newTestSegment(0xC0, []byte{8, 0, 8, 0, 8, 1, 1, 0x11, 0}),
+		newTestSegment(0xC4, newTestDHT()),
+	}
+}
+
+func TestValidateJPEG(t *testing.T) {
+	valid := newTestJPEG(t, newTestValidHeader()...)
+	header := newTestValidHeader()
+	jfif, dqt, sof, dht := header[0], header[1], header[2], header[3]
+
+	tests := []struct {
+		name     string
+		data     []byte
+		severity DiagnosticSeverity
+		message  string
+	}{
+		{"empty", nil, SeverityError, "too short"},
+		{"not a JPEG", []byte("GIF89a"), SeverityError, "doesn't start with a SOI"},
+		{"missing EOI", valid[:len(valid)-2], SeverityError, "Scan data is truncated"},
+		{"ends after a segment", valid[:len(valid)-len(testScanData)-2-len(testSOS)-4], SeverityError, "Missing EOI marker"},
+		{"truncated segment", valid[:30], SeverityError, "exceeds the file size"},
+		{"trailing data", append(append([]byte{}, valid...), "trailer"...), SeverityInfo, "7 bytes of trailing data"},
+		{"garbage between segments", bytes.Replace(valid, []byte{0xFF, 0xDB}, []byte{0x00, 0x00, 0xFF, 0xDB}, 1), SeverityError, "skipped 2 bytes"},
+		{"duplicate SOI", append([]byte{0xFF, 0xD8}, valid...), SeverityError, "Duplicate SOI"},
+		{"invalid length", bytes.Replace(valid, []byte{0xFF, 0xE0, 0x00, 0x10}, []byte{0xFF, 0xE0, 0x00, 0x01}, 1), SeverityError, "invalid length of 1"},
+		{"no frame header", newTestJPEG(t, jfif, dqt, dht), SeverityError, "SOS segment before any SOF"},
+		{"no DQT", newTestJPEG(t, jfif, sof, dht), SeverityError, "Quantization table 0 of component 1"},
+		{"no DHT", newTestJPEG(t, jfif, dqt, sof), SeverityWarning, "No DHT segment"},
+		{"JFIF not first", newTestJPEG(t, dqt, jfif, sof, dht), SeverityWarning, "JFIF APP0 segment is not the first"},
+		{"APP after the frame", newTestJPEG(t, jfif, dqt, sof, newTestSegment(0xE1, []byte("Exif\x00\x00")), dht), SeverityWarning, "after the frame header"},
+		{"two frames", newTestJPEG(t, jfif, dqt, sof, sof, dht), SeverityError, "Second SOF0 frame header"},
+		{"scan of unknown component", newTestJPEG(t, jfif, dqt, newTestSegment(0xC0, []byte{8, 0, 8, 0, 8, 1, 2, 0x11, 0}), dht), SeverityError, "component 1, which is not in the frame header"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			diagnostics := validateJPEG(bytes.NewReader(test.data), int64(len(test.data)))
+			found := false
+			for _, d := range diagnostics {
+				if strings.Contains(d.Message, test.message) && d.Severity == test.severity {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("no %s containing %q in %v", test.severity, test.message, diagnostics)
+			}
+			if hasJPEGErrors(diagnostics) != (test.severity == SeverityError) {
+				t.Errorf("hasJPEGErrors = %v for %v", hasJPEGErrors(diagnostics), diagnostics)
+			}
+		})
+	}
+}
+
+func TestValidateJPEGFileValid(t *testing.T) {
+	filename := writeTestFile(t, "test.jpg", newTestJPEG(t, newTestValidHeader()...))
+	diagnostics, err := validateJPEGFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diagnostics) != 0 {
+		t.Errorf("diagnostics for a valid file: %v", diagnostics)
+	}
+
+	if _, err := validateJPEGFile(filename + ".missing"); err == nil {
+		t.Error("no error for a missing file")
+	}
+}
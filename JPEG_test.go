@@ -0,0 +1,470 @@
+package EXIF
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// testScanData is entropy-coded data with a stuffed zero byte and a restart
+// marker in it, neither of which ends the scan
+var testScanData = []byte{0x12, 0xFF, 0x00, 0x34, 0xFF, 0xD0, 0x56, 0x78}
+
+// testSOS is the header of a scan of one component
+var testSOS = []byte{0x01, 0x01, 0x00, 0x00, 0x3F, 0x00}
+
+// newTestSegment returns a segment as the parsers return it, without offsets
+func newTestSegment(segType byte, segData []byte) segment {
+	return segment{
+		segType: segType,
+		segName: aJPEGSegmentNames[segType],
+		segDesc: aJPEGSegmentDescriptions[segType],
+		segData: segData,
+	}
+}
+
+// newTestJPEG builds a JPEG from header segments, which should not include
+// the SOS segment: SOI, the segments, a SOS segment, testScanData and EOI
+func newTestJPEG(t *testing.T, jpegHeader ...segment) []byte {
+	t.Helper()
+	jpegHeader = append(append([]segment{}, jpegHeader...), newTestSegment(0xDA, testSOS))
+	data := []byte{0xFF, 0xD8}
+	for _, seg := range jpegHeader {
+		if len(seg.segData) > 0xFFFD {
+			t.Fatalf("segment %X is too large", seg.segType)
+		}
+		size := len(seg.segData) + 2
+		data = append(data, 0xFF, seg.segType, byte(size>>8), byte(size))
+		data = append(data, seg.segData...)
+	}
+	data = append(data, testScanData...)
+	return append(data, 0xFF, 0xD9)
+}
+
+// writeTestFile writes data to a file in a temporary directory
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// segmentTypes lists the marker types of segments
+func segmentTypes(segments []segment) []byte {
+	types := []byte{}
+	for _, seg := range segments {
+		types = append(types, seg.segType)
+	}
+	return types
+}
+
+func TestReadJPEGHeaderData(t *testing.T) {
+	app0 := []byte("JFIF\x00\x01\x02\x00\x00\x01\x00\x01\x00\x00")
+	com := []byte("a comment")
+	data := newTestJPEG(t, newTestSegment(0xE0, app0), newTestSegment(0xFE, com))
+	filename := writeTestFile(t, "test.jpg", data)
+
+	readers := []struct {
+		name string
+		read func() ([]segment, error)
+	}{
+		{"io.Reader", func() ([]segment, error) { return readJPEGHeaderData(bytes.NewReader(data)) }},
+		{"io.ReaderAt", func() ([]segment, error) { return readJPEGHeaderDataAt(bytes.NewReader(data)) }},
+		{"fs.FS", func() ([]segment, error) {
+			return getJPEGHeaderDataFS(fstest.MapFS{"test.jpg": {Data: data}}, "test.jpg")
+		}},
+		{"filename", func() ([]segment, error) { return getJPEGHeaderData(filename) }},
+	}
+
+	for _, reader := range readers {
+		t.Run(reader.name, func(t *testing.T) {
+			segments, err := reader.read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := segmentTypes(segments), []byte{0xE0, 0xFE, 0xDA}; !bytes.Equal(got, want) {
+				t.Fatalf("segment types = % X, want % X", got, want)
+			}
+			for i, want := range [][]byte{app0, com, testSOS} {
+				seg := segments[i]
+				if !bytes.Equal(seg.segData, want) {
+					t.Errorf("segment %d data = %q, want %q", i, seg.segData, want)
+				}
+				if stored := data[seg.segDataStart : seg.segDataStart+uint64(len(want))]; !bytes.Equal(stored, want) {
+					t.Errorf("segment %d segDataStart %d doesn't point at its data", i, seg.segDataStart)
+				}
+			}
+			if segments[0].segName != "APP0" {
+				t.Errorf("segment name = %q, want APP0", segments[0].segName)
+			}
+		})
+	}
+}
+
+func TestReadJPEGHeaderDataFillBytes(t *testing.T) {
+	// Any number of 0xFF fill bytes may precede a marker
+	data := []byte{0xFF, 0xD8, 0xFF, 0xFF, 0xFF, 0xFE, 0x00, 0x03, 'x', 0xFF, 0xD9}
+	for _, read := range []func() ([]segment, error){
+		func() ([]segment, error) { return readJPEGHeaderData(bytes.NewReader(data)) },
+		func() ([]segment, error) { return readJPEGHeaderDataAt(bytes.NewReader(data)) },
+	} {
+		segments, err := read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(segments) != 1 || string(segments[0].segData) != "x" {
+			t.Errorf("segments = %v, want one COM segment holding x", segments)
+		}
+	}
+}
+
+func TestReadJPEGHeaderDataCorrupt(t *testing.T) {
+	valid := newTestJPEG(t, newTestSegment(0xFE, []byte("comment")))
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not a JPEG", []byte("GIF89a")},
+		{"only SOI", valid[:2]},
+		{"truncated marker", valid[:3]},
+		{"truncated size", valid[:5]},
+		{"truncated data", valid[:8]},
+		{"size below two", []byte{0xFF, 0xD8, 0xFF, 0xFE, 0x00, 0x01}},
+		{"no FF before marker", []byte{0xFF, 0xD8, 0x00, 0xFE, 0x00, 0x02}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := readJPEGHeaderData(bytes.NewReader(test.data)); err == nil {
+				t.Error("readJPEGHeaderData: no error")
+			}
+			if _, err := readJPEGHeaderDataAt(bytes.NewReader(test.data)); err == nil {
+				t.Error("readJPEGHeaderDataAt: no error")
+			}
+		})
+	}
+}
+
+func TestGetJPEGHeaderDataMissingFile(t *testing.T) {
+	if _, err := getJPEGHeaderData(filepath.Join(t.TempDir(), "missing.jpg")); err == nil {
+		t.Error("no error for a missing file")
+	}
+	if _, err := getJPEGHeaderDataFS(fstest.MapFS{}, "missing.jpg"); err == nil {
+		t.Error("no error for a missing file in a file system")
+	}
+}
+
+func TestReadJPEGImageData(t *testing.T) {
+	data := newTestJPEG(t, newTestSegment(0xFE, []byte("comment")))
+
+	for name, read := range map[string]func() ([]byte, error){
+		"io.Reader":   func() ([]byte, error) { return readJPEGImageData(bytes.NewReader(data)) },
+		"io.ReaderAt": func() ([]byte, error) { return readJPEGImageDataAt(bytes.NewReader(data)) },
+		"fs.FS": func() ([]byte, error) {
+			return getJPEGImageDataFS(fstest.MapFS{"test.jpg": {Data: data}}, "test.jpg")
+		},
+	} {
+		compressedData, err := read()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(compressedData, testScanData) {
+			t.Errorf("%s: compressed data = % X, want % X", name, compressedData, testScanData)
+		}
+	}
+}
+
+// recordingReaderAt records the byte ranges read from a reader
+type recordingReaderAt struct {
+	reader io.ReaderAt
+	reads  [][2]int64
+}
+
+func (r *recordingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.reads = append(r.reads, [2]int64{off, off + int64(len(p))})
+	return r.reader.ReadAt(p, off)
+}
+
+// touches tells whether any read overlapped the range from start to end
+func (r *recordingReaderAt) touches(start int64, end int64) bool {
+	for _, read := range r.reads {
+		if read[0] < end && read[1] > start {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSegmentIteratorIsLazy(t *testing.T) {
+	payload := bytes.Repeat([]byte{0xAB}, 1000)
+	data := newTestJPEG(t, newTestSegment(0xE1, payload), newTestSegment(0xFE, []byte("comment")))
+	reader := &recordingReaderAt{reader: bytes.NewReader(data)}
+
+	it := newSegmentIterator(reader, 0)
+	types := []byte{}
+	lengths := []int{}
+	offsets := []uint64{}
+	for it.Next() {
+		types = append(types, it.Segment().segType)
+		lengths = append(lengths, it.Length())
+		offsets = append(offsets, it.Offset())
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if !bytes.Equal(types, []byte{0xE1, 0xFE, 0xDA}) {
+		t.Fatalf("segment types = % X, want E1 FE DA", types)
+	}
+	if lengths[0] != len(payload) || offsets[0] != 2 || offsets[1] != uint64(2+4+len(payload)) {
+		t.Errorf("lengths = %v, offsets = %v", lengths, offsets)
+	}
+	if reader.touches(6, int64(6+len(payload))) {
+		t.Error("the APP1 payload was read without calling Payload")
+	}
+	if reader.touches(int64(len(data)-len(testScanData)-2), int64(len(data))) {
+		t.Error("the iterator read past the SOS segment")
+	}
+	if it.Next() {
+		t.Error("Next returned true after the end")
+	}
+}
+
+func TestSegmentIteratorBudget(t *testing.T) {
+	payload := bytes.Repeat([]byte{0xAB}, 1000)
+	data := newTestJPEG(t, newTestSegment(0xE1, payload))
+	// SOI, the APP1 marker and size, its payload, then the SOS marker and size
+	sosOffset := uint64(2 + 4 + len(payload))
+
+	tests := []struct {
+		name          string
+		maxHeaderSize uint64
+		wantTypes     []byte
+		wantErr       error
+	}{
+		{"no limit", 0, []byte{0xE1, 0xDA}, nil},
+		{"exactly the SOS marker and size", sosOffset + 4, []byte{0xE1, 0xDA}, nil},
+		{"one byte short of the SOS size", sosOffset + 3, []byte{0xE1}, errHeaderBudgetExceeded},
+		{"inside the first payload", 100, []byte{0xE1}, errHeaderBudgetExceeded},
+		{"only SOI", 2, []byte{}, errHeaderBudgetExceeded},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			it := newSegmentIterator(bytes.NewReader(data), test.maxHeaderSize)
+			types := []byte{}
+			for it.Next() {
+				types = append(types, it.Segment().segType)
+			}
+			if !bytes.Equal(types, test.wantTypes) {
+				t.Errorf("segment types = % X, want % X", types, test.wantTypes)
+			}
+			if it.Err() != test.wantErr {
+				t.Errorf("error = %v, want %v", it.Err(), test.wantErr)
+			}
+		})
+	}
+}
+
+func TestSegmentIteratorPayloadTruncated(t *testing.T) {
+	data := newTestJPEG(t, newTestSegment(0xFE, bytes.Repeat([]byte{'x'}, 100)))
+	// Keep the COM marker and size but cut its payload
+	it := newSegmentIterator(bytes.NewReader(data[:50]), 0)
+	if !it.Next() {
+		t.Fatalf("Next returned false: %v", it.Err())
+	}
+	if _, err := it.Payload(); err == nil {
+		t.Error("no error reading a truncated payload")
+	}
+	if it.Next() || it.Err() == nil {
+		t.Error("iteration went on past the end of the file")
+	}
+}
+
+func TestJPEGTrailer(t *testing.T) {
+	baseline := newTestJPEG(t, newTestSegment(0xFE, []byte("comment")))
+	progressive := newTestProgressiveJPEG(t)
+	// A second JPEG appended to the first, as in a Motion Photo or MPF file
+	secondImage := newTestJPEG(t)
+
+	tests := []struct {
+		name    string
+		image   []byte
+		trailer []byte
+	}{
+		{"no trailer", baseline, []byte{}},
+		{"text trailer", baseline, []byte("trailing data")},
+		{"trailer ending in EOI", baseline, secondImage},
+		{"progressive with trailer", progressive, secondImage},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := append(append([]byte{}, test.image...), test.trailer...)
+
+			trailer, err := readJPEGTrailerAt(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if trailer.start != uint64(len(test.image)) || !bytes.Equal(trailer.data, test.trailer) {
+				t.Errorf("readJPEGTrailerAt: trailer at %d = %q, want %d, %q", trailer.start, trailer.data, len(test.image), test.trailer)
+			}
+
+			compressedData, trailer, err := readJPEGImageAndTrailer(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if trailer.start != uint64(len(test.image)) || !bytes.Equal(trailer.data, test.trailer) {
+				t.Errorf("readJPEGImageAndTrailer: trailer at %d = %q, want %d, %q", trailer.start, trailer.data, len(test.image), test.trailer)
+			}
+			if want := test.image[len(test.image)-2-len(compressedData) : len(test.image)-2]; !bytes.Equal(compressedData, want) || !bytes.HasPrefix(compressedData, testScanData) {
+				t.Errorf("compressed data = % X", compressedData)
+			}
+		})
+	}
+}
+
+func TestJPEGTrailerTruncated(t *testing.T) {
+	data := newTestJPEG(t)
+	data = data[:len(data)-2]
+
+	if _, err := readJPEGTrailerAt(bytes.NewReader(data)); err == nil {
+		t.Error("readJPEGTrailerAt: no error without an EOI marker")
+	}
+
+	// The stream reader falls back to treating everything as image data
+	compressedData, trailer, err := readJPEGImageAndTrailer(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(compressedData, testScanData) || len(trailer.data) != 0 {
+		t.Errorf("compressed data % X, trailer %q", compressedData, trailer.data)
+	}
+}
+
+func TestPutJPEGHeaderDataKeepsTrailer(t *testing.T) {
+	trailerData := append([]byte("video"), newTestJPEG(t)...)
+	data := append(newTestJPEG(t, newTestSegment(0xFE, []byte("old"))), trailerData...)
+	filename := writeTestFile(t, "test.jpg", data)
+
+	jpegHeader, err := getJPEGHeaderData(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jpegHeader[0].segData = []byte("a longer comment")
+	if err := putJPEGHeaderData(filename, filename, jpegHeader); err != nil {
+		t.Fatal(err)
+	}
+
+	trailer, err := getJPEGTrailer(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(trailer.data, trailerData) {
+		t.Errorf("trailer = %q, want %q", trailer.data, trailerData)
+	}
+	compressedData, err := getJPEGImageData(filename)
+	if err != nil || !bytes.Equal(compressedData, testScanData) {
+		t.Errorf("compressed data = % X, %v", compressedData, err)
+	}
+}
+
+func TestPutJPEGHeaderDataSafe(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		options     jpegWriteOptions
+		sameFile    bool
+		wantMode    fs.FileMode
+		wantModTime bool
+		wantBackup  bool
+	}{
+		{"defaults", jpegWriteOptions{}, false, 0644, false, false},
+		{"preserve mode of old file", jpegWriteOptions{preserveMode: true}, false, 0600, false, false},
+		{"preserve mode in place", jpegWriteOptions{preserveMode: true}, true, 0600, false, false},
+		{"preserve modification time", jpegWriteOptions{preserveModTime: true}, true, 0644, true, false},
+		{"keep backup", jpegWriteOptions{keepBackup: true}, true, 0644, false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oldData := newTestJPEG(t, newTestSegment(0xFE, []byte("old")))
+			oldFilename := writeTestFile(t, "old.jpg", oldData)
+			if err := os.Chmod(oldFilename, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(oldFilename, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+			newFilename := filepath.Join(filepath.Dir(oldFilename), "new.jpg")
+			if test.sameFile {
+				newFilename = oldFilename
+			}
+
+			jpegHeader := []segment{newTestSegment(0xFE, []byte("new")), newTestSegment(0xDA, testSOS)}
+			if err := putJPEGHeaderDataSafe(oldFilename, newFilename, jpegHeader, test.options); err != nil {
+				t.Fatal(err)
+			}
+
+			if data, err := os.ReadFile(newFilename); err != nil || !bytes.Equal(data, newTestJPEG(t, jpegHeader[0])) {
+				t.Errorf("new file = % X, %v", data, err)
+			}
+			info, err := os.Stat(newFilename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != test.wantMode {
+				t.Errorf("mode = %v, want %v", info.Mode().Perm(), test.wantMode)
+			}
+			if info.ModTime().Equal(modTime) != test.wantModTime {
+				t.Errorf("modification time = %v", info.ModTime())
+			}
+			backup, err := os.ReadFile(newFilename + ".bak")
+			if test.wantBackup && !bytes.Equal(backup, oldData) {
+				t.Errorf("backup = % X, %v", backup, err)
+			} else if !test.wantBackup && err == nil {
+				t.Error("a backup was written")
+			}
+
+			// No temporary files are left behind
+			entries, _ := os.ReadDir(filepath.Dir(newFilename))
+			for _, entry := range entries {
+				if strings.HasSuffix(entry.Name(), ".tmp") {
+					t.Errorf("temporary file %s left behind", entry.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestPutJPEGHeaderDataSafeFailure(t *testing.T) {
+	oldData := newTestJPEG(t, newTestSegment(0xFE, []byte("old")))
+	filename := writeTestFile(t, "test.jpg", oldData)
+
+	// A segment which is too large fails the write after the temporary file is created
+	jpegHeader := []segment{newTestSegment(0xFE, make([]byte, jpegSegmentMaxSize+1)), newTestSegment(0xDA, testSOS)}
+	if err := putJPEGHeaderDataSafe(filename, filename, jpegHeader, jpegWriteOptions{}); err == nil {
+		t.Fatal("no error for a segment which is too large")
+	}
+	if data, err := os.ReadFile(filename); err != nil || !bytes.Equal(data, oldData) {
+		t.Error("the file was changed by a failed write")
+	}
+	entries, _ := os.ReadDir(filepath.Dir(filename))
+	if len(entries) != 1 {
+		t.Errorf("%d files in the directory, want only the JPEG", len(entries))
+	}
+
+	if err := putJPEGHeaderDataSafe(filepath.Join(t.TempDir(), "missing.jpg"), filename, jpegHeader[1:], jpegWriteOptions{}); err == nil {
+		t.Error("no error for a missing old file")
+	}
+}
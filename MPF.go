@@ -0,0 +1,349 @@
+package EXIF
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+/******************************************************************************
+*
+* Type:         MPFIndex
+*
+* Description:  The decoded MP Index IFD of a Multi-Picture Format (CIPA
+*               DC-007) APP2 segment. It lists the individual images stored
+*               in the file: the primary image followed by e.g. the second
+*               image of a stereo pair or a large preview, which are stored
+*               after the EOI marker of the primary image.
+*
+******************************************************************************/
+
+type MPFIndex struct {
+	Version      string // MP Format version, e.g. "0100"
+	ByteOrder    binary.ByteOrder
+	Entries      []MPEntry
+	headerOffset uint64 // Absolute offset of the MP header, which the entry offsets are relative to
+}
+
+// MPEntry describes one individual image of a Multi-Picture file
+type MPEntry struct {
+	Attribute  uint32 // Individual image attribute: flags, image data format and MP type code
+	Size       uint32 // Size of the image in bytes
+	Offset     uint32 // Offset of the image relative to the MP header, 0 for the first image
+	Dependent1 uint16 // Entry number of the first dependent image, 0 if none
+	Dependent2 uint16 // Entry number of the second dependent image, 0 if none
+}
+
+var mpfIdent = []byte("MPF\x00")
+
+// MP Index IFD tags
+const (
+	mpfTagVersion        = 0xB000
+	mpfTagNumberOfImages = 0xB001
+	mpfTagMPEntry        = 0xB002
+)
+
+/******************************************************************************
+*
+* Function:     getMPFIndex
+*
+* Description:  Finds the MPF APP2 segment in the JPEG header data and
+*               decodes its MP Index IFD
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*
+* Returns:      index - the decoded MP Index IFD
+*               error - if no MPF segment was found or it could not be decoded
+*
+******************************************************************************/
+
+func getMPFIndex(jpegHeader []segment) (*MPFIndex, error) {
+	for _, seg := range jpegHeader {
+		if seg.segType == 0xE2 && bytes.HasPrefix(seg.segData, mpfIdent) {
+			return decodeMPFIndex(seg)
+		}
+	}
+	return nil, &jpegError{"Couldn't find MPF segment"}
+}
+
+/******************************************************************************
+*
+* Function:     decodeMPFIndex
+*
+* Description:  Decodes the MP Index IFD of an MPF APP2 segment. The segment
+*               must have been read from a file for segDataStart, and so the
+*               position of the images, to be known.
+*
+* Parameters:   seg - the MPF APP2 segment
+*
+* Returns:      index - the decoded MP Index IFD
+*               error - if the segment has no valid MP Index IFD
+*
+******************************************************************************/
+
+func decodeMPFIndex(seg segment) (*MPFIndex, error) {
+	index := &MPFIndex{headerOffset: seg.segDataStart + uint64(len(mpfIdent))}
+
+	entries, err := findMPFEntries(seg.segData, func(tag uint16, value []byte) {
+		if tag == mpfTagVersion {
+			index.Version = string(value)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	index.ByteOrder = entries.byteOrder
+	index.Entries = make([]MPEntry, entries.count)
+	for i := range index.Entries {
+		entry := entries.data[16*i:]
+		index.Entries[i] = MPEntry{
+			Attribute:  entries.byteOrder.Uint32(entry[0:4]),
+			Size:       entries.byteOrder.Uint32(entry[4:8]),
+			Offset:     entries.byteOrder.Uint32(entry[8:12]),
+			Dependent1: entries.byteOrder.Uint16(entry[12:14]),
+			Dependent2: entries.byteOrder.Uint16(entry[14:16]),
+		}
+	}
+
+	return index, nil
+}
+
+/******************************************************************************
+*
+* Function:     ImageReader
+*
+* Description:  Gives access to one of the individual images of the file
+*
+* Parameters:   reader - the JPEG file the index was read from
+*               i - the number of the image, 0 for the primary image
+*
+* Returns:      imageReader - a reader over just the bytes of the image
+*               error - if there is no such image
+*
+******************************************************************************/
+
+func (idx *MPFIndex) ImageReader(reader io.ReaderAt, i int) (*io.SectionReader, error) {
+	if i < 0 || i >= len(idx.Entries) {
+		return nil, &jpegError{"No such image in MPF index"}
+	}
+	return io.NewSectionReader(reader, int64(idx.imageOffset(i)), int64(idx.Entries[i].Size)), nil
+}
+
+// imageOffset returns the absolute offset of an individual image
+func (idx *MPFIndex) imageOffset(i int) uint64 {
+	// The first image starts at the SOI of the file
+	if idx.Entries[i].Offset == 0 {
+		return 0
+	}
+	return idx.headerOffset + uint64(idx.Entries[i].Offset)
+}
+
+/******************************************************************************
+*
+* Function:     Type
+*
+* Description:  Gets the MP type code of an individual image, e.g. 0x030000
+*               for a baseline MP primary image or 0x010001 for a large
+*               thumbnail
+*
+* Returns:      type - the MP type code
+*
+******************************************************************************/
+
+func (e MPEntry) Type() uint32 {
+	return e.Attribute & 0x00FFFFFF
+}
+
+/******************************************************************************
+*
+* Function:     TypeName
+*
+* Description:  Gets the name of the MP type of an individual image
+*
+* Returns:      name - the name of the MP type
+*
+******************************************************************************/
+
+func (e MPEntry) TypeName() string {
+	if name, ok := aMPTypeNames[e.Type()]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+/******************************************************************************
+*
+* Function:     fixMPFOffsets
+*
+* Description:  Updates the MPF segment in new JPEG header data so that the
+*               sizes and offsets in its MP Index IFD match the file that
+*               will be written. Changing the header changes the size of the
+*               primary image, which moves the images after it, and can move
+*               the MP header that the offsets are relative to.
+*
+* Parameters:   jpegHeader - the new JPEG header data
+*               oldIndex - the MP Index of the file being rewritten, the
+*                          trailing images are located with it
+*               oldPrimarySize - the size of the primary image in the old file
+*               newPrimarySize - the size of the primary image in the new file
+*
+* Returns:      jpegHeader - the JPEG header data with the MPF segment updated
+*               error - if the MPF segment could not be updated
+*
+******************************************************************************/
+
+func fixMPFOffsets(jpegHeader []segment, oldIndex *MPFIndex, oldPrimarySize uint64, newPrimarySize uint64) ([]segment, error) {
+	k := -1
+	for i, seg := range jpegHeader {
+		if seg.segType == 0xE2 && bytes.HasPrefix(seg.segData, mpfIdent) {
+			k = i
+			break
+		}
+	}
+	if k < 0 {
+		return jpegHeader, nil
+	}
+
+	// The MP header follows SOI, the segments before it, its own marker and size, and the identifier
+	before, err := encodeJPEGSegments(jpegHeader[:k])
+	if err != nil {
+		return jpegHeader, err
+	}
+	newHeaderOffset := uint64(2+len(before)+4) + uint64(len(mpfIdent))
+
+	segData := append([]byte{}, jpegHeader[k].segData...)
+	entries, err := findMPFEntries(segData, nil)
+	if err != nil {
+		return jpegHeader, err
+	}
+
+	for i := 0; i < entries.count; i++ {
+		entry := entries.data[16*i:]
+		offset := entries.byteOrder.Uint32(entry[8:12])
+
+		// The primary image, its size changes with the header
+		if offset == 0 {
+			entries.byteOrder.PutUint32(entry[4:8], uint32(newPrimarySize))
+			continue
+		}
+
+		// Images after the primary image keep their place in the trailer
+		position := oldIndex.headerOffset + uint64(offset)
+		if position >= oldPrimarySize {
+			position = position - oldPrimarySize + newPrimarySize
+		}
+		if position < newHeaderOffset {
+			return jpegHeader, &jpegError{"MPF image lies before the MP header"}
+		}
+		entries.byteOrder.PutUint32(entry[8:12], uint32(position-newHeaderOffset))
+	}
+
+	mpf := jpegHeader[k]
+	mpf.segData = segData
+	return spliceJPEGSegments(jpegHeader, k, 1, []segment{mpf}), nil
+}
+
+// mpfEntries points at the MP Entry values inside MPF segment data
+type mpfEntries struct {
+	byteOrder binary.ByteOrder
+	count     int
+	data      []byte
+}
+
+// findMPFEntries walks the MP Index IFD in the data of an MPF segment and
+// locates the MP Entry values. Every IFD entry is passed to visit, if given.
+func findMPFEntries(segData []byte, visit func(tag uint16, value []byte)) (mpfEntries, error) {
+	entries := mpfEntries{}
+
+	if !bytes.HasPrefix(segData, mpfIdent) {
+		return entries, &jpegError{"Segment is not an MPF segment"}
+	}
+
+	// The MP header is a TIFF header: byte order, 42, offset of the first IFD
+	header := segData[len(mpfIdent):]
+	if len(header) < 8 {
+		return entries, &jpegError{"MPF segment is too short"}
+	}
+	switch string(header[0:4]) {
+	case "II*\x00":
+		entries.byteOrder = binary.LittleEndian
+	case "MM\x00*":
+		entries.byteOrder = binary.BigEndian
+	default:
+		return entries, &jpegError{"MPF segment has an invalid MP header"}
+	}
+	byteOrder := entries.byteOrder
+
+	ifdOffset := uint64(byteOrder.Uint32(header[4:8]))
+	if ifdOffset+2 > uint64(len(header)) {
+		return entries, &jpegError{"MPF segment has an invalid MP Index IFD offset"}
+	}
+	numTags := int(byteOrder.Uint16(header[ifdOffset:]))
+	if ifdOffset+2+12*uint64(numTags) > uint64(len(header)) {
+		return entries, &jpegError{"MPF MP Index IFD is truncated"}
+	}
+
+	numImages := -1
+	for i := 0; i < numTags; i++ {
+		// IFD entry: tag (2), type (2), count (4), value or offset (4)
+		ifdEntry := header[ifdOffset+2+12*uint64(i):]
+		tag := byteOrder.Uint16(ifdEntry[0:2])
+		count := uint64(byteOrder.Uint32(ifdEntry[4:8]))
+
+		// All MP Index tags are UNDEFINED or LONG, values up to 4 bytes are stored in the entry
+		size := count
+		if byteOrder.Uint16(ifdEntry[2:4]) == 4 {
+			size = 4 * count
+		}
+		value := ifdEntry[8:12]
+		if size > 4 {
+			valueOffset := uint64(byteOrder.Uint32(ifdEntry[8:12]))
+			if valueOffset+size > uint64(len(header)) {
+				return entries, &jpegError{"MPF tag value lies outside the segment"}
+			}
+			value = header[valueOffset : valueOffset+size]
+		} else {
+			value = value[:size]
+		}
+
+		switch tag {
+		case mpfTagNumberOfImages:
+			if len(value) == 4 {
+				numImages = int(byteOrder.Uint32(value))
+			}
+		case mpfTagMPEntry:
+			entries.data = value
+		}
+		if visit != nil {
+			visit(tag, value)
+		}
+	}
+
+	if entries.data == nil {
+		return entries, &jpegError{"MPF segment has no MP Entry tag"}
+	}
+	entries.count = len(entries.data) / 16
+	if numImages >= 0 && numImages < entries.count {
+		entries.count = numImages
+	}
+	return entries, nil
+}
+
+/******************************************************************************
+* Global Variable:      MP_Type_Names
+*
+* Contents:     The names of the MP type codes of individual images
+*
+******************************************************************************/
+
+var aMPTypeNames = map[uint32]string{
+	0x000000: "Undefined",
+	0x010001: "Large Thumbnail (VGA equivalent)",
+	0x010002: "Large Thumbnail (Full HD equivalent)",
+	0x020001: "Multi-Frame Image Panorama",
+	0x020002: "Multi-Frame Image Disparity",
+	0x020003: "Multi-Frame Image Multi-Angle",
+	0x030000: "Baseline MP Primary Image",
+}
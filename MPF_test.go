@@ -0,0 +1,184 @@
+package EXIF
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+)
+
+// testByteOrder is a byte order that can append values, as both
+// binary.BigEndian and binary.LittleEndian can
+type testByteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// newTestMPFSegment returns an MPF segment listing a primary image and a
+// large thumbnail. The MP header starts at headerOffset in the file.
+func newTestMPFSegment(byteOrder testByteOrder, headerOffset uint64, primarySize uint32, secondSize uint32, secondOffset uint64) segment {
+	header := []byte("MM\x00*")
+	if byteOrder == binary.LittleEndian {
+		header = []byte("II*\x00")
+	}
+	header = byteOrder.AppendUint32(header, 8)
+
+	// MP Index IFD: version, number of images and the MP Entry values after the IFD
+	header = byteOrder.AppendUint16(header, 3)
+	for _, ifdEntry := range [][3]uint32{{mpfTagVersion, 7, 4}, {mpfTagNumberOfImages, 4, 1}, {mpfTagMPEntry, 7, 32}} {
+		header = byteOrder.AppendUint16(header, uint16(ifdEntry[0]))
+		header = byteOrder.AppendUint16(header, uint16(ifdEntry[1]))
+		header = byteOrder.AppendUint32(header, ifdEntry[2])
+		switch ifdEntry[0] {
+		case mpfTagVersion:
+			header = append(header, "0100"...)
+		case mpfTagNumberOfImages:
+			header = byteOrder.AppendUint32(header, 2)
+		case mpfTagMPEntry:
+			header = byteOrder.AppendUint32(header, 8+2+3*12+4)
+		}
+	}
+	header = byteOrder.AppendUint32(header, 0)
+
+	for _, entry := range []MPEntry{
+		{Attribute: 0x20030000, Size: primarySize},
+		{Attribute: 0x00010001, Size: secondSize, Offset: uint32(secondOffset - headerOffset)},
+	} {
+		header = byteOrder.AppendUint32(header, entry.Attribute)
+		header = byteOrder.AppendUint32(header, entry.Size)
+		header = byteOrder.AppendUint32(header, entry.Offset)
+		header = byteOrder.AppendUint16(header, entry.Dependent1)
+		header = byteOrder.AppendUint16(header, entry.Dependent2)
+	}
+
+	return newTestSegment(0xE2, append(append([]byte{}, mpfIdent...), header...))
+}
+
+// newTestMPFJPEG builds a primary image with an MPF segment after an APP1
+// segment, followed by a second image
+func newTestMPFJPEG(t *testing.T, byteOrder testByteOrder, secondImage []byte) []byte {
+	t.Helper()
+	app1 := newTestSegment(0xE1, []byte("Exif\x00\x00"))
+	headerOffset := uint64(2+4+len(app1.segData)+4) + uint64(len(mpfIdent))
+
+	// The sizes don't depend on the values, so build it once to measure it
+	primarySize := len(newTestJPEG(t, app1, newTestMPFSegment(byteOrder, headerOffset, 0, 0, headerOffset)))
+	mpf := newTestMPFSegment(byteOrder, headerOffset, uint32(primarySize), uint32(len(secondImage)), uint64(primarySize))
+	return append(newTestJPEG(t, app1, mpf), secondImage...)
+}
+
+// checkMPFImages checks that the images of the MP index of a file are the primary image and the second image
+func checkMPFImages(t *testing.T, filename string, secondImage []byte) {
+	t.Helper()
+	jpegHeader, err := getJPEGHeaderData(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := getMPFIndex(jpegHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trailer, err := getJPEGTrailer(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range [][]byte{data[:trailer.start], secondImage} {
+		reader, err := index.ImageReader(bytes.NewReader(data), i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		image, err := io.ReadAll(reader)
+		if err != nil || !bytes.Equal(image, want) {
+			t.Errorf("image %d is not where the MP index points, %v", i, err)
+		}
+	}
+	if _, err := index.ImageReader(bytes.NewReader(data), 2); err == nil {
+		t.Error("no error for an image that isn't in the index")
+	}
+}
+
+func TestMPFIndex(t *testing.T) {
+	secondImage := newTestJPEG(t, newTestSegment(0xFE, []byte("second image")))
+
+	for _, byteOrder := range []testByteOrder{binary.BigEndian, binary.LittleEndian} {
+		t.Run(byteOrder.String(), func(t *testing.T) {
+			filename := writeTestFile(t, "test.jpg", newTestMPFJPEG(t, byteOrder, secondImage))
+
+			jpegHeader, err := getJPEGHeaderData(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			index, err := getMPFIndex(jpegHeader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if index.Version != "0100" || index.ByteOrder != byteOrder || len(index.Entries) != 2 {
+				t.Fatalf("index = %+v", index)
+			}
+			if index.Entries[0].TypeName() != "Baseline MP Primary Image" || index.Entries[1].Type() != 0x010001 {
+				t.Errorf("types %s, %06X", index.Entries[0].TypeName(), index.Entries[1].Type())
+			}
+			checkMPFImages(t, filename, secondImage)
+
+			// A larger header moves the second image and the MP header
+			grown, err := addJPEGComment(jpegHeader, "a comment in front of the MPF segment", charsetAuto)
+			if err != nil {
+				t.Fatal(err)
+			}
+			grown = spliceJPEGSegments(grown, 0, 0, []segment{newTestSegment(0xE0, []byte("JFIF\x00"))})
+			if err := putJPEGHeaderDataSafe(filename, filename, grown, jpegWriteOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			checkMPFImages(t, filename, secondImage)
+
+			// Reserving the default padding moves the second image
+			if jpegHeader, err = getJPEGHeaderData(filename); err != nil {
+				t.Fatal(err)
+			}
+			if err := putJPEGHeaderData(filename, filename, jpegHeader); err != nil {
+				t.Fatal(err)
+			}
+			checkMPFImages(t, filename, secondImage)
+
+			// An in place edit can move the MP header within the padding
+			if jpegHeader, err = getJPEGHeaderData(filename); err != nil {
+				t.Fatal(err)
+			}
+			jpegHeader = spliceJPEGSegments(jpegHeader, 0, 0, []segment{newTestSegment(0xE1, make([]byte, 500))})
+			if err := putJPEGHeaderDataInPlace(filename, jpegHeader, jpegWriteOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			checkMPFImages(t, filename, secondImage)
+		})
+	}
+}
+
+func TestDecodeMPFIndexCorrupt(t *testing.T) {
+	valid := newTestMPFSegment(binary.BigEndian, 0, 100, 100, 200).segData
+
+	tests := []struct {
+		name    string
+		segData []byte
+	}{
+		{"not MPF", []byte("Exif\x00\x00")},
+		{"too short", valid[:8]},
+		{"invalid MP header", append([]byte("MPF\x00XX\x00*"), valid[8:]...)},
+		{"IFD offset outside", append(append([]byte{}, valid[:8]...), 0, 0, 1, 0)},
+		{"truncated IFD", valid[:20]},
+		{"MP Entry outside", valid[:len(valid)-1]},
+	}
+	for _, test := range tests {
+		if _, err := decodeMPFIndex(newTestSegment(0xE2, test.segData)); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+	if _, err := getMPFIndex([]segment{newTestSegment(0xDA, testSOS)}); err == nil {
+		t.Error("no error without an MPF segment")
+	}
+}
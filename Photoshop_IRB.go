@@ -0,0 +1,461 @@
+package EXIF
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// irbResource is one Photoshop Image Resource Block (8BIM) resource
+type irbResource struct {
+	resID   uint16
+	resName string
+	resData []byte
+}
+
+// String describes a resource by its ID, name and size, for diagnostics
+func (r irbResource) String() string {
+	description := fmt.Sprintf("0x%04X %s, %d bytes", r.resID, irbResourceName(r.resID), len(r.resData))
+	if r.resName != "" {
+		description += fmt.Sprintf(" (%q)", r.resName)
+	}
+	return description
+}
+
+var photoshopIdent = []byte("Photoshop 3.0\x00")
+
+/******************************************************************************
+*
+* Function:     getPhotoshopIRB
+*
+* Description:  Retrieves the Photoshop Information Resource Block (IRB)
+*               from the APP13 segments of the JPEG header data. An IRB that
+*               is too large for one segment is spread over consecutive
+*               APP13 segments, which are joined before decoding.
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*
+* Returns:      resources - the IRB resources
+*               error - if there is no Photoshop APP13 segment or its
+*                       resources could not be decoded
+*
+******************************************************************************/
+
+func getPhotoshopIRB(jpegHeader []segment) ([]irbResource, error) {
+	var data []byte
+	found := false
+	for _, seg := range jpegHeader {
+		if isPhotoshopIRBSegment(seg) {
+			data = append(data, seg.segData[len(photoshopIdent):]...)
+			found = true
+		}
+	}
+	if !found {
+		return nil, &jpegError{"Couldn't find Photoshop IRB segment"}
+	}
+	return unpackPhotoshopIRBData(data)
+}
+
+/******************************************************************************
+*
+* Function:     putPhotoshopIRB
+*
+* Description:  Stores Photoshop IRB resources in APP13 segments of the JPEG
+*               header data, replacing the existing ones or adding them
+*               after the other APP segments. If there are no resources the
+*               APP13 segments are removed.
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*               resources - the IRB resources
+*
+* Returns:      jpegHeader - the JPEG header data with the APP13 segments
+*               error - if the resources could not be stored
+*
+******************************************************************************/
+
+func putPhotoshopIRB(jpegHeader []segment, resources []irbResource) ([]segment, error) {
+	segments := newPhotoshopIRBSegments(resources)
+
+	// Take out the existing segments, remembering where the first one was
+	newHeader := []segment{}
+	insertAt := -1
+	for _, seg := range jpegHeader {
+		if isPhotoshopIRBSegment(seg) {
+			if insertAt < 0 {
+				insertAt = len(newHeader)
+			}
+			continue
+		}
+		newHeader = append(newHeader, seg)
+	}
+
+	// No preexisting segment, put it after the last APP segment
+	if insertAt < 0 {
+		insertAt = 0
+		for i, seg := range newHeader {
+			if seg.segType >= 0xE0 && seg.segType <= 0xEF {
+				insertAt = i + 1
+			}
+		}
+	}
+	return spliceJPEGSegments(newHeader, insertAt, 0, segments), nil
+}
+
+/******************************************************************************
+*
+* Function:     newPhotoshopIRBSegments
+*
+* Description:  Encodes Photoshop IRB resources into as many APP13 segments
+*               as needed. Segments are filled with whole resources, only a
+*               resource that doesn't fit in a segment of its own is split.
+*
+* Parameters:   resources - the IRB resources
+*
+* Returns:      segments - the APP13 segments, none if there are no resources
+*
+******************************************************************************/
+
+func newPhotoshopIRBSegments(resources []irbResource) []segment {
+	chunkSize := jpegSegmentMaxSize - len(photoshopIdent)
+
+	chunks := [][]byte{}
+	var chunk []byte
+	for _, resource := range resources {
+		data := packPhotoshopIRBData([]irbResource{resource})
+		if len(chunk)+len(data) > chunkSize && len(chunk) > 0 {
+			chunks = append(chunks, chunk)
+			chunk = nil
+		}
+		for len(data) > chunkSize {
+			chunks = append(chunks, data[:chunkSize])
+			data = data[chunkSize:]
+		}
+		chunk = append(chunk, data...)
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+
+	segments := []segment{}
+	for _, chunk := range chunks {
+		segments = append(segments, segment{
+			segType: 0xED,
+			segName: aJPEGSegmentNames[0xED],
+			segDesc: aJPEGSegmentDescriptions[0xED],
+			segData: append(append([]byte{}, photoshopIdent...), chunk...),
+		})
+	}
+	return segments
+}
+
+// isPhotoshopIRBSegment checks if a segment is an APP13 Photoshop segment
+func isPhotoshopIRBSegment(seg segment) bool {
+	return seg.segType == 0xED && bytes.HasPrefix(seg.segData, photoshopIdent)
+}
+
+/******************************************************************************
+*
+* Function:     getJPEGIPTC
+*
+* Description:  Retrieves the IPTC-NAA records from the IPTC-NAA resource
+*               (0x0404) of the Photoshop IRB in the JPEG header data
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*
+* Returns:      records - the IPTC-NAA records, as decoded by getIPTC
+*               error - if there is no IPTC-NAA resource
+*
+******************************************************************************/
+
+func getJPEGIPTC(jpegHeader []segment) ([]iptcRecord, error) {
+	resources, err := getPhotoshopIRB(jpegHeader)
+	if err != nil {
+		return nil, err
+	}
+	for _, resource := range resources {
+		if resource.resID == 0x0404 {
+			return getIPTC(bytes.NewReader(resource.resData)), nil
+		}
+	}
+	return nil, &jpegError{"Couldn't find IPTC-NAA resource in Photoshop IRB"}
+}
+
+/******************************************************************************
+*
+* Function:     putJPEGIPTC
+*
+* Description:  Stores IPTC-NAA records in the IPTC-NAA resource (0x0404) of
+*               the Photoshop IRB in the JPEG header data, keeping the other
+*               resources. The IPTC digest resource (0x0425) is updated to
+*               match. If there are no records both resources are removed.
+*
+* Parameters:   jpegHeader - the JPEG header data, as retrieved
+*                            from the getJPEGHeaderData function
+*               iptcRecords - the IPTC-NAA records
+*
+* Returns:      jpegHeader - the JPEG header data with the IPTC-NAA records
+*               error - if the records could not be encoded or the existing
+*                       IRB could not be decoded
+*
+******************************************************************************/
+
+func putJPEGIPTC(jpegHeader []segment, iptcRecords []iptcRecord) ([]segment, error) {
+	resources := []irbResource{}
+	for _, seg := range jpegHeader {
+		if isPhotoshopIRBSegment(seg) {
+			var err error
+			if resources, err = getPhotoshopIRB(jpegHeader); err != nil {
+				return jpegHeader, err
+			}
+			break
+		}
+	}
+
+	var iptcData []byte
+	if len(iptcRecords) > 0 {
+		var ok bool
+		if iptcData, ok = putIPTC(iptcRecords); !ok {
+			return jpegHeader, &jpegError{"Couldn't encode IPTC records"}
+		}
+	}
+	return putPhotoshopIRB(jpegHeader, setIPTCResources(resources, iptcData))
+}
+
+// setIPTCResources replaces the IPTC-NAA resource (0x0404) and its digest
+// (0x0425), so the digest always matches the IIM data it was written with.
+// Both are removed when there is no IIM data.
+func setIPTCResources(resources []irbResource, iptcData []byte) []irbResource {
+	newData := map[uint16][]byte{}
+	if len(iptcData) > 0 {
+		newData[0x0404] = iptcData
+		newData[0x0425] = computeIPTCDigest(iptcData)
+	}
+
+	// Replace the resources where they are, or add them at the end
+	newResources := []irbResource{}
+	for _, resource := range resources {
+		if resource.resID != 0x0404 && resource.resID != 0x0425 {
+			newResources = append(newResources, resource)
+			continue
+		}
+		if data, ok := newData[resource.resID]; ok {
+			resource.resData = data
+			newResources = append(newResources, resource)
+			delete(newData, resource.resID)
+		}
+	}
+	for _, resID := range []uint16{0x0404, 0x0425} {
+		if data, ok := newData[resID]; ok {
+			newResources = append(newResources, irbResource{resID: resID, resData: data})
+		}
+	}
+	return newResources
+}
+
+// irbResourceName returns the name of a Photoshop IRB resource ID
+func irbResourceName(resID uint16) string {
+	switch {
+	case resID >= 0x07D0 && resID <= 0x0BB6:
+		return "Path Information"
+	case resID >= 0x0FA0 && resID <= 0x1387:
+		return "Plug-In Resource"
+	}
+	if name, ok := aPhotoshopResourceNames[resID]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+/******************************************************************************
+*
+* Function:     unpackPhotoshopIRBData
+*
+* Description:  Decodes Photoshop IRB resources
+*
+* Parameters:   data - the resource data, without the "Photoshop 3.0"
+*                      identifier
+*
+* Returns:      resources - the IRB resources
+*               error - if the data is not valid IRB data
+*
+******************************************************************************/
+
+func unpackPhotoshopIRBData(data []byte) ([]irbResource, error) {
+	resources := []irbResource{}
+	for len(data) > 0 {
+		// Resource layout:
+		// "8BIM" (4), resource ID (2), Pascal string name padded to an even
+		// size, data size (4), data padded to an even size
+		if len(data) < 7 || string(data[0:4]) != "8BIM" {
+			return resources, &jpegError{"Invalid Photoshop IRB resource"}
+		}
+		resource := irbResource{resID: binary.BigEndian.Uint16(data[4:6])}
+
+		nameLength := int(data[6])
+		nameSize := nameLength + 1
+		if nameSize%2 != 0 {
+			nameSize++
+		}
+		if len(data) < 6+nameSize+4 {
+			return resources, &jpegError{fmt.Sprintf("Photoshop IRB resource 0x%04X %s is truncated", resource.resID, irbResourceName(resource.resID))}
+		}
+		resource.resName = string(data[7 : 7+nameLength])
+		data = data[6+nameSize:]
+
+		size := int(binary.BigEndian.Uint32(data[0:4]))
+		data = data[4:]
+		if len(data) < size {
+			return resources, &jpegError{fmt.Sprintf("Photoshop IRB resource 0x%04X %s is truncated", resource.resID, irbResourceName(resource.resID))}
+		}
+		resource.resData = data[:size]
+		if size%2 != 0 && len(data) > size {
+			size++
+		}
+		data = data[size:]
+
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
+
+/******************************************************************************
+*
+* Function:     packPhotoshopIRBData
+*
+* Description:  Encodes Photoshop IRB resources
+*
+* Parameters:   resources - the IRB resources
+*
+* Returns:      data - the resource data, without the "Photoshop 3.0"
+*                      identifier
+*
+******************************************************************************/
+
+func packPhotoshopIRBData(resources []irbResource) []byte {
+	var data bytes.Buffer
+	for _, resource := range resources {
+		data.WriteString("8BIM")
+		binary.Write(&data, binary.BigEndian, resource.resID)
+
+		name := resource.resName
+		if len(name) > 255 {
+			name = name[:255]
+		}
+		data.WriteByte(byte(len(name)))
+		data.WriteString(name)
+		if len(name)%2 == 0 {
+			data.WriteByte(0)
+		}
+
+		binary.Write(&data, binary.BigEndian, uint32(len(resource.resData)))
+		data.Write(resource.resData)
+		if len(resource.resData)%2 != 0 {
+			data.WriteByte(0)
+		}
+	}
+	return data.Bytes()
+}
+
+/******************************************************************************
+* Global Variable:      Photoshop_Resource_Names
+*
+* Contents:     The names of the Photoshop Image Resource Block resources
+*
+******************************************************************************/
+
+var aPhotoshopResourceNames = map[uint16]string{
+	0x03E8: "Channels, Rows, Columns, Depth and Mode",
+	0x03E9: "Macintosh Print Manager Print Info",
+	0x03EB: "Indexed Color Table",
+	0x03ED: "Resolution Info",
+	0x03EE: "Alpha Channel Names",
+	0x03EF: "Display Info",
+	0x03F0: "Caption",
+	0x03F1: "Border Information",
+	0x03F2: "Background Color",
+	0x03F3: "Print Flags",
+	0x03F4: "Grayscale and Multichannel Halftoning Information",
+	0x03F5: "Color Halftoning Information",
+	0x03F6: "Duotone Halftoning Information",
+	0x03F7: "Grayscale and Multichannel Transfer Function",
+	0x03F8: "Color Transfer Functions",
+	0x03F9: "Duotone Transfer Functions",
+	0x03FA: "Duotone Image Information",
+	0x03FB: "Effective Black and White Values",
+	0x03FD: "EPS Options",
+	0x03FE: "Quick Mask Information",
+	0x0400: "Layer State Information",
+	0x0401: "Working Path",
+	0x0402: "Layers Group Information",
+	0x0404: "IPTC-NAA Record",
+	0x0405: "Image Mode for Raw Format Files",
+	0x0406: "JPEG Quality",
+	0x0408: "Grid and Guides Information",
+	0x0409: "Thumbnail (Photoshop 4.0)",
+	0x040A: "Copyright Flag",
+	0x040B: "URL",
+	0x040C: "Thumbnail",
+	0x040D: "Global Angle",
+	0x040E: "Color Samplers Resource (Photoshop 5.0)",
+	0x040F: "ICC Profile",
+	0x0410: "Watermark",
+	0x0411: "ICC Untagged Profile",
+	0x0412: "Effects Visible",
+	0x0413: "Spot Halftone",
+	0x0414: "Document Specific IDs Seed",
+	0x0415: "Unicode Alpha Names",
+	0x0416: "Indexed Color Table Count",
+	0x0417: "Transparency Index",
+	0x0419: "Global Altitude",
+	0x041A: "Slices",
+	0x041B: "Workflow URL",
+	0x041C: "Jump To XPEP",
+	0x041D: "Alpha Identifiers",
+	0x041E: "URL List",
+	0x0421: "Version Info",
+	0x0422: "Exif Data 1",
+	0x0423: "Exif Data 3",
+	0x0424: "XMP Metadata",
+	0x0425: "Caption Digest",
+	0x0426: "Print Scale",
+	0x0428: "Pixel Aspect Ratio",
+	0x0429: "Layer Comps",
+	0x042A: "Alternate Duotone Colors",
+	0x042B: "Alternate Spot Colors",
+	0x042D: "Layer Selection IDs",
+	0x042E: "HDR Toning Information",
+	0x042F: "Print Info",
+	0x0430: "Layer Groups Enabled ID",
+	0x0431: "Color Samplers Resource",
+	0x0432: "Measurement Scale",
+	0x0433: "Timeline Information",
+	0x0434: "Sheet Disclosure",
+	0x0435: "Display Info",
+	0x0436: "Onion Skins",
+	0x0438: "Count Information",
+	0x043A: "Print Information",
+	0x043B: "Print Style",
+	0x043C: "Macintosh NSPrintInfo",
+	0x043D: "Windows DEVMODE",
+	0x043E: "Auto Save File Path",
+	0x043F: "Auto Save Format",
+	0x0440: "Path Selection State",
+	0x0BB7: "Name of Clipping Path",
+	0x0BB8: "Origin Path Info",
+	0x1B58: "Image Ready Variables",
+	0x1B59: "Image Ready Data Sets",
+	0x1B5A: "Image Ready Default Selected State",
+	0x1B5B: "Image Ready 7 Rollover Expanded State",
+	0x1B5C: "Image Ready Rollover Expanded State",
+	0x1B5D: "Image Ready Save Layer Settings",
+	0x1B5E: "Image Ready Version",
+	0x1F40: "Lightroom Workflow",
+	0x2710: "Print Flags Information",
+}
+
+/******************************************************************************
+* End of Global Variable:     Photoshop_Resource_Names
+******************************************************************************/
@@ -0,0 +1,170 @@
+package EXIF
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPhotoshopIRBRoundTrip(t *testing.T) {
+	chunkSize := jpegSegmentMaxSize - len(photoshopIdent)
+	tests := []struct {
+		name         string
+		resources    []irbResource
+		wantSegments int
+	}{
+		{"none", []irbResource{}, 0},
+		{"odd name and data", []irbResource{{0x0404, "ab", []byte{1, 2, 3}}, {0x040F, "", []byte{4}}}, 1},
+		{"even name", []irbResource{{0x0404, "abcd", []byte{1, 2}}}, 1},
+		{"largest in one segment", []irbResource{{0x0404, "", bytes.Repeat([]byte{7}, chunkSize-13)}}, 1},
+		{"smallest over one segment", []irbResource{{0x0404, "", bytes.Repeat([]byte{7}, chunkSize-11)}}, 2},
+		{"whole resources per segment", []irbResource{{0x0404, "", bytes.Repeat([]byte{1}, 40000)}, {0x040C, "", bytes.Repeat([]byte{2}, 40000)}}, 2},
+		{"split resource", []irbResource{{0x0404, "", bytes.Repeat([]byte{3}, 3*chunkSize)}, {0x0425, "", make([]byte, 16)}}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segments := newPhotoshopIRBSegments(tt.resources)
+			if len(segments) != tt.wantSegments {
+				t.Fatalf("newPhotoshopIRBSegments() = %d segments, want %d", len(segments), tt.wantSegments)
+			}
+			for _, seg := range segments {
+				if !isPhotoshopIRBSegment(seg) || len(seg.segData) > jpegSegmentMaxSize {
+					t.Fatalf("segment of %d bytes is not a valid APP13 segment", len(seg.segData))
+				}
+			}
+			if len(segments) == 0 {
+				return
+			}
+
+			header, err := putPhotoshopIRB([]segment{newTestSegment(0xE0, []byte("JFIF\x00")), newTestSegment(0xDB, nil)}, tt.resources)
+			if err != nil {
+				t.Fatalf("putPhotoshopIRB() error = %v", err)
+			}
+			want := append([]byte{0xE0}, bytes.Repeat([]byte{0xED}, tt.wantSegments)...)
+			if got := segmentTypes(header); !bytes.Equal(got, append(want, 0xDB)) {
+				t.Errorf("putPhotoshopIRB() segments = % X", got)
+			}
+			got, err := getPhotoshopIRB(header)
+			if err != nil {
+				t.Fatalf("getPhotoshopIRB() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.resources) {
+				t.Errorf("getPhotoshopIRB() = %v, want %v", got, tt.resources)
+			}
+		})
+	}
+}
+
+func TestPutPhotoshopIRBReplace(t *testing.T) {
+	old := newPhotoshopIRBSegments([]irbResource{{0x0404, "", bytes.Repeat([]byte{1}, 100000)}})
+	header := append([]segment{newTestSegment(0xE1, []byte("Exif\x00\x00"))}, old...)
+	header = append(header, newTestSegment(0xFE, []byte("comment")))
+
+	header, err := putPhotoshopIRB(header, []irbResource{{0x040A, "", []byte{1}}})
+	if err != nil {
+		t.Fatalf("putPhotoshopIRB() error = %v", err)
+	}
+	if got := segmentTypes(header); !bytes.Equal(got, []byte{0xE1, 0xED, 0xFE}) {
+		t.Errorf("segments after replacing = % X", got)
+	}
+
+	header, _ = putPhotoshopIRB(header, nil)
+	if got := segmentTypes(header); !bytes.Equal(got, []byte{0xE1, 0xFE}) {
+		t.Errorf("segments after removing = % X", got)
+	}
+	if _, err := getPhotoshopIRB(header); err == nil {
+		t.Error("getPhotoshopIRB() without APP13 succeeded")
+	}
+}
+
+func TestUnpackPhotoshopIRBDataCorrupt(t *testing.T) {
+	valid := packPhotoshopIRBData([]irbResource{{0x0404, "", []byte{1, 2, 3, 4}}})
+	tests := []struct {
+		name      string
+		data      []byte
+		wantCount int
+		wantErr   string
+	}{
+		{"not 8BIM", append([]byte("8BIX"), valid[4:]...), 0, "Invalid"},
+		{"short header", valid[:6], 0, "Invalid"},
+		{"truncated name", []byte("8BIM\x04\x04\x05ab"), 0, "0x0404 IPTC-NAA Record is truncated"},
+		{"truncated data", valid[:len(valid)-1], 0, "0x0404 IPTC-NAA Record is truncated"},
+		{"garbage after", append(append([]byte{}, valid...), 0, 0), 1, "Invalid"},
+		{"odd data without padding", []byte("8BIM\x04\x0F\x00\x00\x00\x00\x00\x01\xAA"), 1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resources, err := unpackPhotoshopIRBData(tt.data)
+			if len(resources) != tt.wantCount {
+				t.Errorf("unpackPhotoshopIRBData() = %d resources, want %d", len(resources), tt.wantCount)
+			}
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unpackPhotoshopIRBData() error = %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("unpackPhotoshopIRBData() error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIRBResourceString(t *testing.T) {
+	tests := []struct {
+		resource irbResource
+		want     string
+	}{
+		{irbResource{0x0404, "", make([]byte, 10)}, "0x0404 IPTC-NAA Record, 10 bytes"},
+		{irbResource{0x0425, "digest", make([]byte, 16)}, `0x0425 Caption Digest, 16 bytes ("digest")`},
+		{irbResource{0x07D0, "Path 1", nil}, `0x07D0 Path Information, 0 bytes ("Path 1")`},
+		{irbResource{0x0FA0, "", nil}, "0x0FA0 Plug-In Resource, 0 bytes"},
+		{irbResource{0x0BB7, "", nil}, "0x0BB7 Name of Clipping Path, 0 bytes"},
+		{irbResource{0x9999, "", nil}, "0x9999 Unknown, 0 bytes"},
+	}
+	for _, tt := range tests {
+		if got := tt.resource.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestJPEGIPTCMultiSegment(t *testing.T) {
+	caption := strings.Repeat("c", 2000)
+	keywords := []iptcRecord{}
+	for i := 0; i < 40; i++ {
+		keywords = append(keywords, newTestIPTCRecord(2, 25, strings.Repeat("k", 60)))
+	}
+	records := append([]iptcRecord{newTestIPTCRecord(2, 0, "\x00\x04"), newTestIPTCRecord(2, 120, caption)}, keywords...)
+	records = append(records, newTestIPTCRecord(2, 202, strings.Repeat("\xFF", 70000)))
+
+	others := []irbResource{{0x040A, "", []byte{1}}}
+	header, err := putPhotoshopIRB([]segment{newTestSegment(0xDB, nil)}, others)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err = putJPEGIPTC(header, records)
+	if err != nil {
+		t.Fatalf("putJPEGIPTC() error = %v", err)
+	}
+	if n := bytes.Count(segmentTypes(header), []byte{0xED}); n < 2 {
+		t.Fatalf("putJPEGIPTC() wrote %d APP13 segments, want several", n)
+	}
+
+	got, err := getJPEGIPTC(header)
+	if err != nil {
+		t.Fatalf("getJPEGIPTC() error = %v", err)
+	}
+	if len(got) != len(records) || string(got[len(got)-1].recData) != string(records[len(records)-1].recData) {
+		t.Errorf("getJPEGIPTC() = %d records, want %d", len(got), len(records))
+	}
+
+	resources, _ := getPhotoshopIRB(header)
+	ids := []uint16{}
+	for _, resource := range resources {
+		ids = append(ids, resource.resID)
+	}
+	if !reflect.DeepEqual(ids, []uint16{0x040A, 0x0404, 0x0425}) {
+		t.Errorf("resources = %04X, want the other resource kept and the digest added", ids)
+	}
+}
@@ -0,0 +1,3 @@
+module imda
+
+go 1.22